@@ -0,0 +1,61 @@
+// Command faketado runs the fake tado server, to try the proxy without network access:
+//
+//	go run ./cmd/faketado
+//	TADO_BASE_URL=http://127.0.0.1:8081 go run ./cmd serve
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/fake"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8081", "address to listen on")
+	email := flag.String("email", "fake@example.com", "email of the fake account")
+	password := flag.String("password", "password", "password of the fake account")
+	mfaCode := flag.String("mfa-code", "", "two-factor code of the fake account, disabled if empty")
+	locked := flag.Bool("locked", false, "reject every login like a locked account")
+	dailyLimit := flag.Int("daily-limit", 1000, "daily request limit of each client")
+	flag.Parse()
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := fake.NewUnstartedServer()
+	server.Listener.Close()
+	server.Listener = listener
+	server.DailyLimit = *dailyLimit
+
+	server.AddAccount(fake.Account{
+		Email:    *email,
+		Password: *password,
+		MFACode:  *mfaCode,
+		Locked:   *locked,
+		Homes: []fake.Home{
+			{
+				ID:   1,
+				Name: "Fake Home",
+				Zones: []fake.Zone{
+					{ID: 1, Name: "Living Room", Temperature: 21.5, Humidity: 48, Setpoint: 21, HeatingPower: 35},
+					{ID: 2, Name: "Bedroom", Temperature: 18, Humidity: 55, Setpoint: 17, WindowOpen: true},
+				},
+			},
+		},
+	})
+
+	server.Start()
+	defer server.Close()
+
+	log.Printf("fake tado server listening on %s", server.URL)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	<-stop
+}
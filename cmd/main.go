@@ -4,6 +4,7 @@ import (
 	"io/fs"
 	"log"
 	"log/slog"
+	"net/url"
 	"os"
 	"strings"
 
@@ -13,7 +14,10 @@ import (
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/plugins/migratecmd"
 
+	"github.com/s1adem4n/tado-api-proxy/internal/mqtt"
+	"github.com/s1adem4n/tado-api-proxy/internal/notify"
 	"github.com/s1adem4n/tado-api-proxy/internal/proxy"
+	"github.com/s1adem4n/tado-api-proxy/internal/secrets"
 	"github.com/s1adem4n/tado-api-proxy/internal/tado"
 	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
 	_ "github.com/s1adem4n/tado-api-proxy/migrations"
@@ -27,14 +31,35 @@ func main() {
 		Automigrate: app.IsDev(),
 	})
 
+	if _, err := secrets.Default(); err != nil {
+		log.Fatal(err)
+	}
+	if baseURL := os.Getenv(tado.BaseURLEnv); baseURL != "" {
+		if _, err := url.ParseRequestURI(baseURL); err != nil {
+			log.Fatalf("invalid %s: %v", tado.BaseURLEnv, err)
+		}
+		tado.SetBaseURL(baseURL)
+	}
+
+	secrets.Register(app)
+	app.RootCmd.AddCommand(secrets.NewCommand(app))
+
+	notifier := notify.NewNotifier(app)
+	notifier.Register()
+
 	tadoAuth := tado.NewAuth()
-	tokenManager := tokens.NewManager(app, tadoAuth)
+	tokenManager := tokens.NewManager(app, tadoAuth, notifier)
+	tokenManager.Register()
 
 	tadoClient := tado.NewClient(app, tadoAuth, tokenManager)
 	tadoClient.Register()
 
-	proxyHandler := proxy.NewHandler(app, tokenManager)
+	proxyHandler := proxy.NewHandler(app, tokenManager, notifier)
 	proxyHandler.Register()
+	app.RootCmd.AddCommand(proxy.NewPurgeCommand(proxyHandler))
+
+	mqttBridge := mqtt.NewBridge(app, proxyHandler)
+	mqttBridge.Register()
 
 	app.OnBootstrap().BindFunc(func(e *core.BootstrapEvent) error {
 		err := e.Next()
@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tado_proxy"
+
+// Registry holds all metrics exposed on /metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Requests counts proxied requests that were answered by tado.
+	Requests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Proxied requests by method, endpoint template, status, client and account.",
+		},
+		[]string{"method", "endpoint", "status", "client", "account"},
+	)
+
+	// CacheHits counts requests that were answered from the response cache.
+	CacheHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_hits_total",
+			Help:      "Requests answered from the response cache by endpoint template.",
+		},
+		[]string{"endpoint"},
+	)
+
+	// UpstreamDuration observes the latency of requests to tado.
+	UpstreamDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "upstream_request_duration_seconds",
+			Help:      "Latency of requests to the tado API by host and client.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30},
+		},
+		[]string{"host", "client"},
+	)
+
+	// TokenRefreshes counts token refreshes and password grant logins by result.
+	TokenRefreshes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "token_refreshes_total",
+			Help:      "Token refreshes and re-logins by kind (refresh, login) and result (success, failure).",
+		},
+		[]string{"kind", "result"},
+	)
+
+	// LoginFailures counts failed password grant logins by their classification.
+	LoginFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "login_failures_total",
+			Help:      "Failed password grant logins by reason (wrongPassword, locked, deleted, captcha, rateLimited, network, unknown).",
+		},
+		[]string{"reason"},
+	)
+
+	// RequestLogDropped counts request log entries that were dropped because the queue was full or they couldn't be written.
+	RequestLogDropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "request_log_dropped_total",
+			Help:      "Request log entries dropped because the write queue was full or they couldn't be written.",
+		},
+	)
+
+	// RequestsPurged counts request log entries deleted by the retention.
+	RequestsPurged = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_purged_total",
+			Help:      "Request log entries deleted because they expired (expired) or exceeded the maximum row count (overLimit).",
+		},
+		[]string{"reason"},
+	)
+
+	// RequestLogDelay observes how long request log entries wait in the queue.
+	RequestLogDelay = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_log_delay_seconds",
+			Help:      "Time from a proxied request until its entry is written to the requests table.",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 30},
+		},
+	)
+
+	// DeviceCodeAuthorizations counts the outcomes of device code flows.
+	DeviceCodeAuthorizations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "device_code_authorizations_total",
+			Help:      "Device code authorization flows by outcome.",
+		},
+		[]string{"outcome"},
+	)
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Requests,
+		CacheHits,
+		UpstreamDuration,
+		TokenRefreshes,
+		LoginFailures,
+		RequestLogDropped,
+		RequestLogDelay,
+		RequestsPurged,
+		DeviceCodeAuthorizations,
+	)
+}
+
+// Handler returns the HTTP handler serving all registered metrics.
+func Handler() http.Handler {
+	// Compression is left to the router middleware
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{
+		Registry:           Registry,
+		DisableCompression: true,
+	})
+}
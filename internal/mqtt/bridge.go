@@ -0,0 +1,180 @@
+// Package mqtt publishes the state of the tado homes to an MQTT broker and
+// turns command messages into tado API requests. All requests go through the proxy,
+// so the bridge shares the token rotation and the rate limits with the other clients.
+package mqtt
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/s1adem4n/tado-api-proxy/internal/proxy"
+	"github.com/s1adem4n/tado-api-proxy/internal/secrets"
+)
+
+const (
+	defaultTopicPrefix     = "tado"
+	defaultPollInterval    = time.Minute
+	defaultDiscoveryPrefix = "homeassistant"
+)
+
+// config is the MQTT configuration from the settings record.
+type config struct {
+	enabled      bool
+	brokerURL    string
+	username     string
+	password     string
+	topicPrefix  string
+	pollInterval time.Duration
+
+	// discovery enables the Home Assistant MQTT discovery
+	discovery       bool
+	discoveryPrefix string
+}
+
+// loadConfig reads the MQTT configuration from the settings record and applies the defaults.
+func loadConfig(settings *core.Record) (config, error) {
+	password, err := secrets.Get(settings, "mqttPassword")
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg := config{
+		enabled:      settings.GetBool("mqttEnabled"),
+		brokerURL:    strings.TrimSpace(settings.GetString("mqttBrokerURL")),
+		username:     settings.GetString("mqttUsername"),
+		password:     password,
+		topicPrefix:  strings.Trim(settings.GetString("mqttTopicPrefix"), "/ "),
+		pollInterval: time.Duration(settings.GetInt("mqttPollInterval")) * time.Second,
+
+		discovery:       settings.GetBool("mqttDiscovery"),
+		discoveryPrefix: strings.Trim(settings.GetString("mqttDiscoveryPrefix"), "/ "),
+	}
+
+	if cfg.topicPrefix == "" {
+		cfg.topicPrefix = defaultTopicPrefix
+	}
+	if cfg.pollInterval <= 0 {
+		cfg.pollInterval = defaultPollInterval
+	}
+	if cfg.discoveryPrefix == "" {
+		cfg.discoveryPrefix = defaultDiscoveryPrefix
+	}
+
+	if cfg.enabled {
+		brokerURL, err := url.Parse(cfg.brokerURL)
+		if err != nil || brokerURL.Scheme == "" || brokerURL.Host == "" {
+			return config{}, fmt.Errorf("invalid MQTT broker URL %q, expected e.g. tcp://localhost:1883", cfg.brokerURL)
+		}
+	}
+
+	return cfg, nil
+}
+
+// Bridge connects to the MQTT broker configured in the settings.
+type Bridge struct {
+	app   core.App
+	proxy *proxy.Handler
+
+	mu      sync.Mutex
+	config  config
+	session *session
+}
+
+func NewBridge(app core.App, proxyHandler *proxy.Handler) *Bridge {
+	return &Bridge{
+		app:   app,
+		proxy: proxyHandler,
+	}
+}
+
+// Register starts the bridge with the server and restarts it when the settings change.
+func (b *Bridge) Register() {
+	b.app.OnServe().BindFunc(func(e *core.ServeEvent) error {
+		settings, err := b.app.FindFirstRecordByFilter("settings", "")
+		if err == nil {
+			b.apply(settings)
+		}
+
+		return e.Next()
+	})
+
+	b.app.OnRecordAfterUpdateSuccess("settings").BindFunc(func(e *core.RecordEvent) error {
+		b.apply(e.Record)
+
+		return e.Next()
+	})
+
+	// zones are added or removed in Home Assistant when the homes of an account change
+	for _, collection := range []string{"accounts", "homes"} {
+		b.app.OnRecordAfterCreateSuccess(collection).BindFunc(b.onHomesChange)
+		b.app.OnRecordAfterDeleteSuccess(collection).BindFunc(b.onHomesChange)
+	}
+	b.app.OnRecordAfterUpdateSuccess("homes").BindFunc(b.onHomesChange)
+	b.app.OnRecordAfterUpdateSuccess("accounts").BindFunc(func(e *core.RecordEvent) error {
+		// other changes of an account, e.g. its login failures, don't affect the zones
+		if slices.Equal(e.Record.GetStringSlice("homes"), e.Record.Original().GetStringSlice("homes")) {
+			return e.Next()
+		}
+
+		return b.onHomesChange(e)
+	})
+
+	b.app.OnTerminate().BindFunc(func(e *core.TerminateEvent) error {
+		b.stop()
+
+		return e.Next()
+	})
+}
+
+// onHomesChange reloads the zones of all homes with the next poll.
+func (b *Bridge) onHomesChange(e *core.RecordEvent) error {
+	b.mu.Lock()
+	if b.session != nil {
+		b.session.reloadZones()
+	}
+	b.mu.Unlock()
+
+	return e.Next()
+}
+
+// apply (re)starts or stops the bridge if the MQTT configuration changed.
+func (b *Bridge) apply(settings *core.Record) {
+	cfg, err := loadConfig(settings)
+	if err != nil {
+		b.app.Logger().Error("failed to load MQTT settings", "error", err)
+		cfg = config{}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if cfg == b.config {
+		return
+	}
+	b.config = cfg
+
+	if b.session != nil {
+		b.session.stop()
+		b.session = nil
+	}
+
+	if cfg.enabled {
+		b.session = startSession(b.app, b.proxy, cfg)
+	}
+}
+
+func (b *Bridge) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.session != nil {
+		b.session.stop()
+		b.session = nil
+	}
+	b.config = config{}
+}
@@ -0,0 +1,156 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// manualTermination keeps an overlay until it is removed, like a change in the app.
+var manualTermination = map[string]any{"typeSkillBasedApp": "MANUAL"}
+
+// defaultSetpoint is used when switching to manual heating without a known setpoint.
+const defaultSetpoint = 21.0
+
+// handleCommand handles a message of a command topic: {prefix}/{home}/{zone}/{command}/set
+func (s *session) handleCommand(ctx context.Context, topic, payload string) {
+	parts := strings.Split(strings.TrimPrefix(topic, s.topic("")), "/")
+	if len(parts) != 4 {
+		return
+	}
+	homeID, zoneID, command := parts[0], parts[1], parts[2]
+	payload = strings.TrimSpace(payload)
+
+	err := s.runCommand(ctx, homeID, zoneID, command, payload)
+	if err != nil {
+		s.app.Logger().Warn("failed to handle MQTT command", "topic", topic, "payload", payload, "error", err)
+		return
+	}
+
+	s.app.Logger().Info("handled MQTT command", "topic", topic, "payload", payload)
+	s.triggerRefresh()
+}
+
+func (s *session) runCommand(ctx context.Context, homeID, zoneID, command, payload string) error {
+	if _, err := strconv.Atoi(homeID); err != nil {
+		return errors.New("invalid home")
+	}
+	if _, err := strconv.Atoi(zoneID); err != nil {
+		return errors.New("invalid zone")
+	}
+
+	switch command {
+	case "setpoint":
+		celsius, err := strconv.ParseFloat(payload, 64)
+		if err != nil {
+			return fmt.Errorf("invalid temperature: %w", err)
+		}
+
+		return s.setHeating(ctx, homeID, zoneID, celsius)
+	case "power":
+		switch strings.ToUpper(payload) {
+		case "OFF":
+			return s.turnOff(ctx, homeID, zoneID)
+		case "ON":
+			return s.resumeSchedule(ctx, homeID, zoneID)
+		default:
+			return errors.New("expected ON or OFF")
+		}
+	case "mode":
+		switch strings.ToLower(payload) {
+		case "off":
+			return s.turnOff(ctx, homeID, zoneID)
+		case "auto":
+			return s.resumeSchedule(ctx, homeID, zoneID)
+		case "heat":
+			s.mu.Lock()
+			celsius, ok := s.setpoints[homeID+"/"+zoneID]
+			s.mu.Unlock()
+			if !ok {
+				celsius = defaultSetpoint
+			}
+
+			return s.setHeating(ctx, homeID, zoneID, celsius)
+		default:
+			return errors.New("expected auto, heat or off")
+		}
+	case "resume":
+		return s.resumeSchedule(ctx, homeID, zoneID)
+	case "overlay":
+		if !json.Valid([]byte(payload)) {
+			return errors.New("invalid overlay JSON")
+		}
+
+		return s.send(ctx, http.MethodPut, overlayPath(homeID, zoneID), []byte(payload))
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// setHeating heats the zone to the temperature until it is changed again.
+func (s *session) setHeating(ctx context.Context, homeID, zoneID string, celsius float64) error {
+	return s.setOverlay(ctx, homeID, zoneID, map[string]any{
+		"setting": map[string]any{
+			"type":        s.zoneType(homeID, zoneID),
+			"power":       "ON",
+			"temperature": map[string]any{"celsius": celsius},
+		},
+		"termination": manualTermination,
+	})
+}
+
+// turnOff turns the zone off until it is changed again.
+func (s *session) turnOff(ctx context.Context, homeID, zoneID string) error {
+	return s.setOverlay(ctx, homeID, zoneID, map[string]any{
+		"setting": map[string]any{
+			"type":  s.zoneType(homeID, zoneID),
+			"power": "OFF",
+		},
+		"termination": manualTermination,
+	})
+}
+
+func (s *session) setOverlay(ctx context.Context, homeID, zoneID string, overlay map[string]any) error {
+	body, err := json.Marshal(overlay)
+	if err != nil {
+		return err
+	}
+
+	return s.send(ctx, http.MethodPut, overlayPath(homeID, zoneID), body)
+}
+
+func (s *session) resumeSchedule(ctx context.Context, homeID, zoneID string) error {
+	return s.send(ctx, http.MethodDelete, overlayPath(homeID, zoneID), nil)
+}
+
+// send sends a write request through the proxy.
+func (s *session) send(ctx context.Context, method, path string, body []byte) error {
+	resp, err := s.proxy.Do(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	if resp.Status < 200 || resp.Status >= 300 {
+		return fmt.Errorf("tado returned %d: %s", resp.Status, resp.Body)
+	}
+
+	return nil
+}
+
+// zoneType returns the type of a zone, which tado requires in overlays.
+func (s *session) zoneType(homeID, zoneID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if z, ok := s.zones[homeID][zoneID]; ok && z.Type != "" {
+		return z.Type
+	}
+	return "HEATING"
+}
+
+func overlayPath(homeID, zoneID string) string {
+	return "/api/v2/homes/" + homeID + "/zones/" + zoneID + "/overlay"
+}
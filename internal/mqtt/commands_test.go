@@ -0,0 +1,107 @@
+package mqtt
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// zoneState returns the state of the zone of the fake server.
+func (s *testSession) zoneState(t *testing.T) zoneState {
+	t.Helper()
+
+	var states struct {
+		ZoneStates map[string]zoneState `json:"zoneStates"`
+	}
+	if err := s.get(context.Background(), "/api/v2/homes/1/zoneStates", &states); err != nil {
+		t.Fatalf("failed to get zone states: %v", err)
+	}
+	return states.ZoneStates["1"]
+}
+
+// writes returns the number of overlay changes the fake server received.
+func (s *testSession) writes() int {
+	n := 0
+	for _, r := range s.server.Requests() {
+		if r.Method == http.MethodPut || r.Method == http.MethodDelete {
+			n++
+		}
+	}
+	return n
+}
+
+func TestHandleCommand(t *testing.T) {
+	s := newTestSession(t, config{})
+	ctx := context.Background()
+
+	tests := []struct {
+		topic    string
+		payload  string
+		mode     string
+		setpoint float64
+	}{
+		{"tado/1/1/setpoint/set", "22.5", "heat", 22.5},
+		{"tado/1/1/mode/set", "off", "off", 0},
+		// heating again uses the last published setpoint
+		{"tado/1/1/mode/set", "heat", "heat", 21},
+		{"tado/1/1/power/set", "OFF", "off", 0},
+		{"tado/1/1/power/set", "ON", "auto", 21},
+		{"tado/1/1/setpoint/set", "19", "heat", 19},
+		{"tado/1/1/resume/set", "", "auto", 21},
+		{"tado/1/1/overlay/set", `{"setting":{"type":"HEATING","power":"ON","temperature":{"celsius":18}},"termination":{"type":"MANUAL"}}`, "heat", 18},
+		{"tado/1/1/mode/set", "auto", "auto", 21},
+	}
+
+	// the setpoint of the schedule is known from the last poll
+	s.setpoints["1/1"] = 21
+
+	for _, tt := range tests {
+		s.handleCommand(ctx, tt.topic, tt.payload)
+
+		state := s.zoneState(t)
+		if got := state.mode(); got != tt.mode {
+			t.Errorf("%s %q: mode = %q, want %q", tt.topic, tt.payload, got, tt.mode)
+		}
+		if tt.setpoint != 0 && (state.Setting.Temperature == nil || state.Setting.Temperature.Celsius != tt.setpoint) {
+			t.Errorf("%s %q: setting = %+v, want %v °C", tt.topic, tt.payload, state.Setting, tt.setpoint)
+		}
+	}
+
+	select {
+	case <-s.refresh:
+	default:
+		t.Error("the commands didn't trigger a poll")
+	}
+}
+
+func TestHandleCommandRejectsInvalidCommands(t *testing.T) {
+	s := newTestSession(t, config{})
+
+	tests := []struct {
+		topic   string
+		payload string
+	}{
+		{"tado/1/1/setpoint/set", "warm"},
+		{"tado/1/1/power/set", "maybe"},
+		{"tado/1/1/mode/set", "cool"},
+		{"tado/1/1/overlay/set", "{"},
+		{"tado/1/1/unknown/set", "1"},
+		{"tado/home/1/setpoint/set", "21"},
+		{"tado/1/zone/setpoint/set", "21"},
+		{"tado/1/setpoint/set", "21"},
+		{"other/1/1/setpoint/set", "21"},
+	}
+
+	for _, tt := range tests {
+		s.handleCommand(context.Background(), tt.topic, tt.payload)
+	}
+
+	if n := s.writes(); n != 0 {
+		t.Errorf("invalid commands sent %d requests", n)
+	}
+	select {
+	case <-s.refresh:
+		t.Error("an invalid command triggered a poll")
+	default:
+	}
+}
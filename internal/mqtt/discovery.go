@@ -0,0 +1,147 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// nodeID identifies the bridge in the discovery topics, so bridges with different prefixes don't clash.
+func (s *session) nodeID() string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_", " ", "_").Replace(s.config.topicPrefix)
+}
+
+// discoveryTopic returns the Home Assistant discovery topic of an entity.
+func (s *session) discoveryTopic(component, objectID string) string {
+	return s.config.discoveryPrefix + "/" + component + "/" + s.nodeID() + "/" + objectID + "/config"
+}
+
+// discoveryHome returns the tado ID of the home of a discovery topic.
+func discoveryHome(topic string) string {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 2 {
+		return ""
+	}
+
+	home, _, _ := strings.Cut(parts[len(parts)-2], "_")
+	return home
+}
+
+// syncDiscovery announces the heating zones of the homes to Home Assistant
+// and removes the entities of zones that no longer exist.
+func (s *session) syncDiscovery(homeIDs []string) {
+	desired := map[string]string{}
+	// zones of homes that couldn't be loaded are kept
+	unknown := map[string]bool{}
+
+	s.mu.Lock()
+	for _, homeID := range homeIDs {
+		zones, ok := s.zones[homeID]
+		if !ok {
+			unknown[homeID] = true
+			continue
+		}
+
+		for zoneID, z := range zones {
+			if z.Type != "HEATING" {
+				continue
+			}
+
+			for topic, config := range s.zoneDiscovery(homeID, zoneID, z) {
+				desired[topic] = config
+			}
+		}
+	}
+
+	var stale []string
+	prefix := s.config.discoveryPrefix + "/"
+	for topic, payload := range s.published {
+		if payload == "" || !strings.HasPrefix(topic, prefix) || !strings.Contains(topic, "/"+s.nodeID()+"/") {
+			continue
+		}
+		if _, ok := desired[topic]; ok || unknown[discoveryHome(topic)] {
+			continue
+		}
+		stale = append(stale, topic)
+	}
+	s.mu.Unlock()
+
+	for topic, config := range desired {
+		s.publishTopic(topic, config)
+	}
+	for _, topic := range stale {
+		s.publishTopic(topic, "")
+	}
+}
+
+// zoneDiscovery returns the discovery configurations of a heating zone by topic:
+// a climate entity and sensors for the temperature, humidity and heating power.
+func (s *session) zoneDiscovery(homeID, zoneID string, z zone) map[string]string {
+	state := s.topic(homeID + "/" + zoneID + "/")
+	objectID := homeID + "_" + zoneID
+
+	device := map[string]any{
+		"identifiers":    []string{s.nodeID() + "_" + objectID},
+		"name":           z.Name,
+		"manufacturer":   "tado",
+		"model":          "Heating zone",
+		"suggested_area": z.Name,
+	}
+
+	entities := map[string]map[string]any{
+		s.discoveryTopic("climate", objectID+"_climate"): {
+			"name":                      nil,
+			"modes":                     []string{"auto", "heat", "off"},
+			"mode_state_topic":          state + "mode",
+			"mode_command_topic":        state + "mode/set",
+			"temperature_state_topic":   state + "setpoint",
+			"temperature_command_topic": state + "setpoint/set",
+			"current_temperature_topic": state + "temperature",
+			"current_humidity_topic":    state + "humidity",
+			"action_topic":              state + "heating_power",
+			"action_template":           "{{ 'heating' if value | float(0) > 0 else 'idle' }}",
+			"min_temp":                  5,
+			"max_temp":                  25,
+			"temp_step":                 0.1,
+			"precision":                 0.1,
+			"temperature_unit":          "C",
+		},
+		s.discoveryTopic("sensor", objectID+"_temperature"): {
+			"name":                "Temperature",
+			"state_topic":         state + "temperature",
+			"device_class":        "temperature",
+			"unit_of_measurement": "°C",
+			"state_class":         "measurement",
+		},
+		s.discoveryTopic("sensor", objectID+"_humidity"): {
+			"name":                "Humidity",
+			"state_topic":         state + "humidity",
+			"device_class":        "humidity",
+			"unit_of_measurement": "%",
+			"state_class":         "measurement",
+		},
+		s.discoveryTopic("sensor", objectID+"_heating_power"): {
+			"name":                "Heating power",
+			"state_topic":         state + "heating_power",
+			"unit_of_measurement": "%",
+			"state_class":         "measurement",
+			"icon":                "mdi:radiator",
+		},
+	}
+
+	configs := make(map[string]string, len(entities))
+	for topic, entity := range entities {
+		parts := strings.Split(topic, "/")
+		entity["unique_id"] = s.nodeID() + "_" + parts[len(parts)-2]
+		entity["device"] = device
+		entity["availability_topic"] = s.topic("status")
+		entity["qos"] = qos
+
+		config, err := json.Marshal(entity)
+		if err != nil {
+			continue
+		}
+		configs[topic] = string(config)
+	}
+
+	return configs
+}
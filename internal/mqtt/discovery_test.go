@@ -0,0 +1,80 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestPollAnnouncesHeatingZones(t *testing.T) {
+	s := newTestSession(t, config{discovery: true})
+	s.poll(context.Background())
+
+	payload, ok := s.client.message("homeassistant/climate/tado/1_1_climate/config")
+	if !ok {
+		t.Fatal("the climate entity of the zone wasn't announced")
+	}
+
+	var climate map[string]any
+	if err := json.Unmarshal([]byte(payload), &climate); err != nil {
+		t.Fatalf("invalid discovery config: %v", err)
+	}
+	want := map[string]any{
+		"unique_id":                 "tado_1_1_climate",
+		"availability_topic":        "tado/status",
+		"mode_state_topic":          "tado/1/1/mode",
+		"mode_command_topic":        "tado/1/1/mode/set",
+		"temperature_command_topic": "tado/1/1/setpoint/set",
+		"current_temperature_topic": "tado/1/1/temperature",
+	}
+	for key, value := range want {
+		if climate[key] != value {
+			t.Errorf("%s = %v, want %v", key, climate[key], value)
+		}
+	}
+
+	for _, sensor := range []string{"temperature", "humidity", "heating_power"} {
+		if _, ok := s.client.message("homeassistant/sensor/tado/1_1_" + sensor + "/config"); !ok {
+			t.Errorf("the %s sensor of the zone wasn't announced", sensor)
+		}
+	}
+}
+
+func TestPollRemovesStaleZones(t *testing.T) {
+	s := newTestSession(t, config{discovery: true})
+	removed := "homeassistant/sensor/tado/1_9_temperature/config"
+	otherBridge := "homeassistant/sensor/other/1_9_temperature/config"
+
+	// retained configurations announced before the restart
+	s.published[removed] = "{}"
+	s.published[otherBridge] = "{}"
+	s.client.retained[removed] = "{}"
+	s.client.retained[otherBridge] = "{}"
+
+	// zones of a home that couldn't be loaded are kept
+	s.server.FailNext("GET", `^/api/v2/homes/1/zones$`, 500, 1, nil)
+	s.poll(context.Background())
+	if _, ok := s.client.message(removed); !ok {
+		t.Fatal("the zone of a home that couldn't be loaded was removed")
+	}
+
+	s.poll(context.Background())
+	if _, ok := s.client.message(removed); ok {
+		t.Error("the zone that no longer exists wasn't removed")
+	}
+	if _, ok := s.client.message(otherBridge); !ok {
+		t.Error("the zone of another bridge was removed")
+	}
+}
+
+func TestDiscoveryTopic(t *testing.T) {
+	s := &session{config: config{topicPrefix: "home/tado heating", discoveryPrefix: "ha"}}
+
+	topic := s.discoveryTopic("climate", "1_2_climate")
+	if want := "ha/climate/home_tado_heating/1_2_climate/config"; topic != want {
+		t.Errorf("discoveryTopic() = %q, want %q", topic, want)
+	}
+	if home := discoveryHome(topic); home != "1" {
+		t.Errorf("discoveryHome(%q) = %q, want 1", topic, home)
+	}
+}
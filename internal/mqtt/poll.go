@@ -0,0 +1,199 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// homeState is the response of the home state endpoint.
+type homeState struct {
+	Presence string `json:"presence"`
+}
+
+// zoneState is a zone of the zone states endpoint, reduced to the published values.
+type zoneState struct {
+	Setting struct {
+		Type        string       `json:"type"`
+		Power       string       `json:"power"`
+		Temperature *temperature `json:"temperature"`
+	} `json:"setting"`
+	OverlayType        *string         `json:"overlayType"`
+	OpenWindow         json.RawMessage `json:"openWindow"`
+	OpenWindowDetected bool            `json:"openWindowDetected"`
+	ActivityDataPoints struct {
+		HeatingPower *percentage `json:"heatingPower"`
+	} `json:"activityDataPoints"`
+	SensorDataPoints struct {
+		InsideTemperature *temperature `json:"insideTemperature"`
+		Humidity          *percentage  `json:"humidity"`
+	} `json:"sensorDataPoints"`
+}
+
+type temperature struct {
+	Celsius float64 `json:"celsius"`
+}
+
+type percentage struct {
+	Percentage float64 `json:"percentage"`
+}
+
+// windowOpen reports whether tado detected an open window or the open window mode is active.
+func (z *zoneState) windowOpen() bool {
+	return z.OpenWindowDetected || (len(z.OpenWindow) > 0 && string(z.OpenWindow) != "null")
+}
+
+// mode returns the Home Assistant mode of the zone: off, heat if the schedule is
+// replaced by a manual setting, or auto.
+func (z *zoneState) mode() string {
+	if z.Setting.Power != "ON" {
+		return "off"
+	}
+	if z.OverlayType != nil {
+		return "heat"
+	}
+	return "auto"
+}
+
+// poll publishes the state of all homes of the accounts and returns the number of requests sent.
+func (s *session) poll(ctx context.Context) int {
+	homes, err := s.app.FindRecordsByFilter("homes", "accounts_via_homes.id ?!= ''", "", 0, 0)
+	if err != nil {
+		s.app.Logger().Error("failed to find homes for MQTT", "error", err)
+		return 0
+	}
+
+	requests := 0
+	homeIDs := make([]string, 0, len(homes))
+	for _, home := range homes {
+		homeID := home.GetString("tadoID")
+		homeIDs = append(homeIDs, homeID)
+		s.publish(homeID+"/name", home.GetString("name"))
+
+		n, err := s.pollHome(ctx, homeID)
+		requests += n
+		if err != nil {
+			if ctx.Err() != nil {
+				return requests
+			}
+			s.app.Logger().Warn("failed to poll home for MQTT", "home", homeID, "error", err)
+		}
+	}
+
+	if s.config.discovery {
+		s.syncDiscovery(homeIDs)
+	}
+
+	return requests
+}
+
+// pollHome publishes the state of a home and its zones and returns the number of requests sent.
+func (s *session) pollHome(ctx context.Context, homeID string) (int, error) {
+	requests := 0
+
+	s.mu.Lock()
+	zones, ok := s.zones[homeID]
+	s.mu.Unlock()
+	if !ok {
+		var list []zone
+		requests++
+		if err := s.get(ctx, "/api/v2/homes/"+homeID+"/zones", &list); err != nil {
+			return requests, err
+		}
+
+		zones = make(map[string]zone, len(list))
+		for _, z := range list {
+			zones[strconv.Itoa(z.ID)] = z
+		}
+
+		s.mu.Lock()
+		s.zones[homeID] = zones
+		s.mu.Unlock()
+	}
+
+	var home homeState
+	requests++
+	if err := s.get(ctx, "/api/v2/homes/"+homeID+"/state", &home); err != nil {
+		return requests, err
+	}
+	s.publish(homeID+"/presence", home.Presence)
+
+	var zoneStates struct {
+		ZoneStates map[string]zoneState `json:"zoneStates"`
+	}
+	requests++
+	if err := s.get(ctx, "/api/v2/homes/"+homeID+"/zoneStates", &zoneStates); err != nil {
+		return requests, err
+	}
+
+	for zoneID, state := range zoneStates.ZoneStates {
+		s.publishZone(homeID, zoneID, zones[zoneID], &state)
+	}
+
+	return requests, nil
+}
+
+// publishZone publishes the values of a zone. Values a zone doesn't have are cleared.
+func (s *session) publishZone(homeID, zoneID string, z zone, state *zoneState) {
+	prefix := homeID + "/" + zoneID + "/"
+
+	s.publish(prefix+"name", z.Name)
+	s.publish(prefix+"type", state.Setting.Type)
+	s.publish(prefix+"power", state.Setting.Power)
+	s.publish(prefix+"mode", state.mode())
+	s.publish(prefix+"open_window", onOff(state.windowOpen()))
+
+	setpoint := ""
+	if state.Setting.Power == "ON" && state.Setting.Temperature != nil {
+		setpoint = formatFloat(state.Setting.Temperature.Celsius)
+
+		s.mu.Lock()
+		s.setpoints[homeID+"/"+zoneID] = state.Setting.Temperature.Celsius
+		s.mu.Unlock()
+	}
+	s.publish(prefix+"setpoint", setpoint)
+
+	currentTemperature := ""
+	if t := state.SensorDataPoints.InsideTemperature; t != nil {
+		currentTemperature = formatFloat(t.Celsius)
+	}
+	s.publish(prefix+"temperature", currentTemperature)
+
+	humidity := ""
+	if h := state.SensorDataPoints.Humidity; h != nil {
+		humidity = formatFloat(h.Percentage)
+	}
+	s.publish(prefix+"humidity", humidity)
+
+	heatingPower := ""
+	if p := state.ActivityDataPoints.HeatingPower; p != nil {
+		heatingPower = formatFloat(p.Percentage)
+	}
+	s.publish(prefix+"heating_power", heatingPower)
+}
+
+// get sends a GET request through the proxy and decodes the JSON response.
+func (s *session) get(ctx context.Context, path string, target any) error {
+	resp, err := s.proxy.Do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	if resp.Status != http.StatusOK {
+		return fmt.Errorf("tado returned %d: %s", resp.Status, resp.Body)
+	}
+
+	return json.Unmarshal(resp.Body, target)
+}
+
+func onOff(value bool) string {
+	if value {
+		return "ON"
+	}
+	return "OFF"
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
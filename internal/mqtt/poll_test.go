@@ -0,0 +1,37 @@
+package mqtt
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPollPublishesZoneState(t *testing.T) {
+	s := newTestSession(t, config{})
+
+	if n := s.poll(context.Background()); n != 3 {
+		t.Errorf("poll() = %d requests, want 3 for the zones, the home state and the zone states", n)
+	}
+
+	want := map[string]string{
+		"tado/1/name":            "Home",
+		"tado/1/presence":        "HOME",
+		"tado/1/1/name":          "Living Room",
+		"tado/1/1/power":         "ON",
+		"tado/1/1/mode":          "auto",
+		"tado/1/1/setpoint":      "21",
+		"tado/1/1/temperature":   "20.5",
+		"tado/1/1/humidity":      "45",
+		"tado/1/1/open_window":   "OFF",
+		"tado/1/1/heating_power": "0",
+	}
+	for topic, payload := range want {
+		if got, _ := s.client.message(topic); got != payload {
+			t.Errorf("%s = %q, want %q", topic, got, payload)
+		}
+	}
+
+	// the zones are only loaded once
+	if n := s.poll(context.Background()); n != 2 {
+		t.Errorf("second poll() = %d requests, want 2", n)
+	}
+}
@@ -0,0 +1,231 @@
+package mqtt
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/s1adem4n/tado-api-proxy/internal/proxy"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+const (
+	qos            = 1
+	publishTimeout = 10 * time.Second
+
+	// quotaShare is the share of the remaining quota the bridge may use until the reset,
+	// the rest is left to the other clients
+	quotaShare = 0.5
+)
+
+// session is a connection to the broker with the polling loop.
+type session struct {
+	app    core.App
+	proxy  *proxy.Handler
+	config config
+	client paho.Client
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	refresh chan struct{}
+
+	mu sync.Mutex
+	// published holds the last payload of each topic, so unchanged values aren't sent again
+	published map[string]string
+	// zones holds the zones of each home by tado ID
+	zones map[string]map[string]zone
+	// setpoints holds the last setpoint of each zone, used when switching to manual heating
+	setpoints map[string]float64
+}
+
+// zone is a zone of a home as returned by the zones endpoint.
+type zone struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func startSession(app core.App, proxyHandler *proxy.Handler, cfg config) *session {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &session{
+		app:       app,
+		proxy:     proxyHandler,
+		config:    cfg,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		refresh:   make(chan struct{}, 1),
+		published: map[string]string{},
+		zones:     map[string]map[string]zone{},
+		setpoints: map[string]float64{},
+	}
+
+	options := paho.NewClientOptions().
+		AddBroker(cfg.brokerURL).
+		SetClientID("tado-api-proxy-"+security.RandomString(8)).
+		SetUsername(cfg.username).
+		SetPassword(cfg.password).
+		SetWill(s.topic("status"), "offline", qos, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(time.Minute).
+		SetOrderMatters(false).
+		SetOnConnectHandler(func(client paho.Client) {
+			s.onConnect(ctx, client)
+		}).
+		SetConnectionLostHandler(func(client paho.Client, err error) {
+			app.Logger().Warn("lost connection to MQTT broker", "error", err)
+		})
+
+	s.client = paho.NewClient(options)
+	// with connect retry, the client keeps trying in the background
+	s.client.Connect()
+
+	go s.run(ctx)
+
+	return s
+}
+
+// stop marks the bridge offline and closes the connection.
+func (s *session) stop() {
+	s.cancel()
+	<-s.done
+
+	if s.client.IsConnectionOpen() {
+		s.client.Publish(s.topic("status"), qos, true, "offline").WaitTimeout(publishTimeout)
+	}
+	s.client.Disconnect(250)
+}
+
+func (s *session) onConnect(ctx context.Context, client paho.Client) {
+	s.app.Logger().Info("connected to MQTT broker", "broker", s.config.brokerURL)
+
+	// retained messages may have been lost, e.g. if the broker was restarted
+	s.mu.Lock()
+	s.published = map[string]string{}
+	s.mu.Unlock()
+
+	client.Publish(s.topic("status"), qos, true, "online")
+
+	client.Subscribe(s.topic("+/+/+/set"), qos, func(client paho.Client, message paho.Message) {
+		s.handleCommand(ctx, message.Topic(), string(message.Payload()))
+	})
+
+	if s.config.discovery {
+		// the retained configurations show which zones were announced before, so removed zones can be cleared
+		client.Subscribe(s.discoveryTopic("+", "+"), qos, func(client paho.Client, message paho.Message) {
+			if len(message.Payload()) == 0 {
+				return
+			}
+
+			s.mu.Lock()
+			if _, ok := s.published[message.Topic()]; !ok {
+				s.published[message.Topic()] = string(message.Payload())
+			}
+			s.mu.Unlock()
+		})
+	}
+
+	s.triggerRefresh()
+}
+
+// run polls the homes until the session is stopped.
+func (s *session) run(ctx context.Context) {
+	defer close(s.done)
+
+	for {
+		delay := s.config.pollInterval
+		if s.client.IsConnectionOpen() {
+			requests := s.poll(ctx)
+			delay = s.pollDelay(requests)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.refresh:
+		case <-time.After(delay):
+		}
+	}
+}
+
+// reloadZones fetches the zones of all homes again with the next poll.
+func (s *session) reloadZones() {
+	s.mu.Lock()
+	s.zones = map[string]map[string]zone{}
+	s.mu.Unlock()
+
+	s.triggerRefresh()
+}
+
+// triggerRefresh polls the homes right away, e.g. after a command.
+func (s *session) triggerRefresh() {
+	select {
+	case s.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// pollDelay returns the time until the next poll. It is at least the poll interval,
+// but longer if polling that often would use more than the bridge's share of the remaining quota.
+func (s *session) pollDelay(requests int) time.Duration {
+	if requests == 0 {
+		return s.config.pollInterval
+	}
+
+	limit, used, err := s.proxy.Quota()
+	if err != nil {
+		s.app.Logger().Error("failed to get quota for MQTT polling", "error", err)
+		return s.config.pollInterval
+	}
+
+	cutoff, err := tokens.GetRatelimitCutoff()
+	if err != nil {
+		return s.config.pollInterval
+	}
+	untilReset := time.Until(cutoff.Add(24 * time.Hour))
+
+	budget := float64(limit-used) * quotaShare
+	if budget < float64(requests) {
+		s.app.Logger().Warn("quota used up, pausing MQTT polling until the reset", "reset", untilReset)
+		return max(untilReset, s.config.pollInterval)
+	}
+
+	delay := time.Duration(float64(untilReset) * float64(requests) / budget)
+
+	return max(delay, s.config.pollInterval)
+}
+
+// topic returns the full topic below the prefix.
+func (s *session) topic(name string) string {
+	return s.config.topicPrefix + "/" + name
+}
+
+// publish sends a retained message below the prefix if the payload changed since it was last sent.
+// An empty payload clears the retained message.
+func (s *session) publish(name string, payload string) {
+	s.publishTopic(s.topic(name), payload)
+}
+
+// publishTopic sends a retained message if the payload changed since it was last sent.
+func (s *session) publishTopic(topic string, payload string) {
+	s.mu.Lock()
+	last, ok := s.published[topic]
+	s.mu.Unlock()
+	if ok && last == payload {
+		return
+	}
+
+	token := s.client.Publish(topic, qos, true, payload)
+	if !token.WaitTimeout(publishTimeout) || token.Error() != nil {
+		s.app.Logger().Warn("failed to publish MQTT message", "topic", topic, "error", token.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.published[topic] = payload
+	s.mu.Unlock()
+}
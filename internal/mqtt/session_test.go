@@ -0,0 +1,124 @@
+package mqtt
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/s1adem4n/tado-api-proxy/internal/proxy"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/fake"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/faketest"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+const (
+	testEmail    = "test@example.com"
+	testPassword = "password"
+)
+
+// fakeClient keeps the retained messages published by the session instead of sending them to a broker.
+type fakeClient struct {
+	paho.Client
+
+	mu       sync.Mutex
+	retained map[string]string
+}
+
+func (c *fakeClient) IsConnectionOpen() bool {
+	return true
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload any) paho.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if payload == "" {
+		delete(c.retained, topic)
+	} else {
+		c.retained[topic] = payload.(string)
+	}
+	return completedToken{}
+}
+
+// message returns the retained message of the topic.
+func (c *fakeClient) message(topic string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	payload, ok := c.retained[topic]
+	return payload, ok
+}
+
+// completedToken is the token of a publish that was sent right away.
+type completedToken struct{}
+
+func (completedToken) Wait() bool                     { return true }
+func (completedToken) WaitTimeout(time.Duration) bool { return true }
+func (completedToken) Done() <-chan struct{}          { return closedChannel }
+func (completedToken) Error() error                   { return nil }
+
+var closedChannel = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// testSession is a session of the bridge that sends its requests through a proxy
+// to the fake server and publishes to a fakeClient.
+type testSession struct {
+	*session
+	server *fake.Server
+	client *fakeClient
+}
+
+func newTestSession(t *testing.T, cfg config) *testSession {
+	t.Helper()
+
+	server := faketest.NewServer(t, fake.Account{
+		Email:    testEmail,
+		Password: testPassword,
+		Homes: []fake.Home{{ID: 1, Name: "Home", Zones: []fake.Zone{
+			{ID: 1, Name: "Living Room", Temperature: 20.5, Humidity: 45, Setpoint: 21},
+		}}},
+	})
+
+	app := faketest.NewApp(t)
+	auth := tado.NewAuth()
+
+	home := faketest.NewRecord(t, app, "homes", map[string]any{"tadoID": "1", "name": "Home"})
+	account := faketest.NewRecord(t, app, "accounts", map[string]any{
+		"email":    testEmail,
+		"password": testPassword,
+		"homes":    []string{home.Id},
+	})
+	client := faketest.NewClient(t, app, "web", server.DailyLimit)
+	faketest.NewToken(t, app, auth, account, client, testPassword, time.Now().Add(time.Hour))
+
+	if cfg.topicPrefix == "" {
+		cfg.topicPrefix = defaultTopicPrefix
+	}
+	if cfg.pollInterval == 0 {
+		cfg.pollInterval = defaultPollInterval
+	}
+	if cfg.discoveryPrefix == "" {
+		cfg.discoveryPrefix = defaultDiscoveryPrefix
+	}
+
+	fakeClient := &fakeClient{retained: map[string]string{}}
+	return &testSession{
+		session: &session{
+			app:       app,
+			proxy:     proxy.NewHandler(app, tokens.NewManager(app, auth, nil), nil),
+			config:    cfg,
+			client:    fakeClient,
+			refresh:   make(chan struct{}, 1),
+			published: map[string]string{},
+			zones:     map[string]map[string]zone{},
+			setpoints: map[string]float64{},
+		},
+		server: server,
+		client: fakeClient,
+	}
+}
@@ -0,0 +1,171 @@
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"slices"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Register watches the tokens and device codes for events, validates the notification
+// targets and sends the events held back during quiet hours.
+func (n *Notifier) Register() {
+	n.app.OnServe().BindFunc(func(e *core.ServeEvent) error {
+		e.Router.POST("/api/notifiers/{id}/test", n.HandleTestRequest).Bind(apis.RequireSuperuserAuth())
+
+		return e.Next()
+	})
+
+	n.app.OnRecordValidate("notifiers").BindFunc(func(e *core.RecordEvent) error {
+		if err := validateTarget(e.Record); err != nil {
+			return err
+		}
+
+		return e.Next()
+	})
+
+	n.app.OnRecordAfterUpdateSuccess("tokens").BindFunc(func(e *core.RecordEvent) error {
+		status := e.Record.GetString("status")
+		if previous := e.Record.Original().GetString("status"); previous != "" && previous != status {
+			n.notifyTokenStatus(e.Record, status)
+		}
+
+		return e.Next()
+	})
+
+	n.app.OnRecordAfterUpdateSuccess("codes").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetString("status") == "expired" && e.Record.Original().GetString("status") != "expired" {
+			n.notifyCodeExpired(e.Record)
+		}
+
+		return e.Next()
+	})
+
+	n.app.Cron().MustAdd("send-held-notifications", "* * * * *", n.releaseHeld)
+}
+
+func (n *Notifier) notifyTokenStatus(tokenRecord *core.Record, status string) {
+	event := Event{
+		Type: EventTokenStatus,
+		Key:  tokenRecord.Id + "/" + status,
+	}
+
+	if status == "valid" {
+		event.Title = "Token valid again"
+		event.Message = fmt.Sprintf("The token of %s works again.", DescribeToken(n.app, tokenRecord))
+	} else {
+		event.Title = "Token invalid"
+		event.Message = fmt.Sprintf("The token of %s became %s.", DescribeToken(n.app, tokenRecord), status)
+		if lastError := tokenRecord.GetString("lastRefreshError"); lastError != "" {
+			event.Message += "\nLast error: " + lastError
+		}
+	}
+
+	n.Notify(event)
+}
+
+func (n *Notifier) notifyCodeExpired(codeRecord *core.Record) {
+	client := codeRecord.GetString("client")
+	if clientRecord, err := n.app.FindRecordById("clients", client); err == nil {
+		client = clientRecord.GetString("name")
+	}
+
+	n.Notify(Event{
+		Type:  EventDeviceCodeExpired,
+		Key:   codeRecord.Id,
+		Title: "Device code expired",
+		Message: fmt.Sprintf(
+			"The device code %s for the %s client expired before it was authorized. Create a new one to add the token.",
+			codeRecord.GetString("userCode"),
+			client,
+		),
+	})
+}
+
+// DescribeToken returns a readable name of a token for notifications, e.g. "me@example.com (Web App)".
+func DescribeToken(app core.App, tokenRecord *core.Record) string {
+	account := tokenRecord.GetString("account")
+	if accountRecord, err := app.FindRecordById("accounts", account); err == nil {
+		account = accountRecord.GetString("email")
+	}
+
+	client := tokenRecord.GetString("client")
+	if clientRecord, err := app.FindRecordById("clients", client); err == nil {
+		client = clientRecord.GetString("name")
+	}
+
+	return account + " (" + client + ")"
+}
+
+// validateTarget checks that a target has the fields its type needs.
+func validateTarget(target *core.Record) error {
+	errs := validation.Errors{}
+
+	requireURL := func() {
+		if _, err := url.ParseRequestURI(target.GetString("url")); err != nil {
+			errs["url"] = validation.NewError("validation_invalid_url", "Must be a valid URL.")
+		}
+	}
+
+	switch target.GetString("type") {
+	case "webhook", "ntfy":
+		requireURL()
+	case "gotify":
+		requireURL()
+		if target.GetString("token") == "" {
+			errs["token"] = validation.NewError("validation_required", "The application token is required.")
+		}
+	case "email":
+		if _, err := mail.ParseAddress(target.GetString("target")); err != nil {
+			errs["target"] = validation.NewError("validation_invalid_email", "Must be a valid email address.")
+		}
+	case "telegram":
+		if target.GetString("url") != "" {
+			requireURL()
+		}
+		if target.GetString("token") == "" {
+			errs["token"] = validation.NewError("validation_required", "The bot token is required.")
+		}
+		if target.GetString("target") == "" {
+			errs["target"] = validation.NewError("validation_required", "The chat ID is required.")
+		}
+	}
+
+	for _, eventType := range target.GetStringSlice("events") {
+		if !slices.Contains(EventTypes, EventType(eventType)) {
+			errs["events"] = validation.NewError("validation_invalid_value", "Unknown event type.")
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// HandleTestRequest sends a test notification to a target, ignoring the deduplication and quiet hours.
+func (n *Notifier) HandleTestRequest(e *core.RequestEvent) error {
+	target, err := n.app.FindRecordById("notifiers", e.Request.PathValue("id"))
+	if err != nil {
+		return e.NotFoundError("Notification target not found", err)
+	}
+
+	err = n.sendRecorded(target, Event{
+		Type:    "test",
+		Key:     target.Id,
+		Title:   "Test notification",
+		Message: "Notifications from the tado API proxy arrive here.",
+		Time:    time.Now(),
+	})
+	if err != nil {
+		return e.BadRequestError("Failed to send the test notification: "+err.Error(), nil)
+	}
+
+	return e.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,331 @@
+package notify_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/s1adem4n/tado-api-proxy/internal/notify"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/faketest"
+)
+
+// deliveryTimeout is how long a test waits for a notification sent in the background.
+const deliveryTimeout = 5 * time.Second
+
+// delivery is a request a notification target received.
+type delivery struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+}
+
+// newTargetServer starts a server that passes the requests it receives to the channel.
+func newTargetServer(t *testing.T) (*httptest.Server, chan delivery) {
+	t.Helper()
+
+	deliveries := make(chan delivery, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- delivery{method: r.Method, path: r.URL.Path, header: r.Header, body: string(body)}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, deliveries
+}
+
+// testNotifier is a registered notifier of a new app.
+type testNotifier struct {
+	*notify.Notifier
+	app *tests.TestApp
+	// saved receives the targets after the result of a delivery was recorded on them
+	saved chan *core.Record
+}
+
+func newNotifier(t *testing.T) *testNotifier {
+	t.Helper()
+
+	app := faketest.NewApp(t)
+	n := &testNotifier{
+		Notifier: notify.NewNotifier(app),
+		app:      app,
+		saved:    make(chan *core.Record, 10),
+	}
+	n.Register()
+
+	app.OnRecordAfterUpdateSuccess("notifiers").BindFunc(func(e *core.RecordEvent) error {
+		select {
+		case n.saved <- e.Record:
+		default:
+		}
+		return e.Next()
+	})
+
+	return n
+}
+
+// awaitSaved waits until a delivery in the background was recorded, so it doesn't outlive the app.
+func (n *testNotifier) awaitSaved(t *testing.T) *core.Record {
+	t.Helper()
+
+	select {
+	case target := <-n.saved:
+		return target
+	case <-time.After(deliveryTimeout):
+		t.Fatal("the delivery wasn't recorded")
+		return nil
+	}
+}
+
+func receive(t *testing.T, deliveries chan delivery) delivery {
+	t.Helper()
+
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(deliveryTimeout):
+		t.Fatal("no notification was delivered")
+		return delivery{}
+	}
+}
+
+func expectNothing(t *testing.T, deliveries chan delivery) {
+	t.Helper()
+
+	select {
+	case d := <-deliveries:
+		t.Errorf("unexpected notification delivered: %s", d.body)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestNotifySendsToSubscribedTargets(t *testing.T) {
+	n := newNotifier(t)
+	app := n.app
+	server, deliveries := newTargetServer(t)
+	other, otherDeliveries := newTargetServer(t)
+
+	faketest.NewRecord(t, app, "notifiers", map[string]any{
+		"name": "Webhook", "type": "webhook", "url": server.URL, "token": "secret",
+		"events": []string{"loginFailed"}, "enabled": true,
+	})
+	faketest.NewRecord(t, app, "notifiers", map[string]any{
+		"name": "Quota", "type": "webhook", "url": other.URL,
+		"events": []string{"quota"}, "enabled": true,
+	})
+
+	event := notify.Event{Type: notify.EventLoginFailed, Key: "token1", Title: "Login failed", Message: "The login failed."}
+	if !n.Notify(event) {
+		t.Fatal("Notify() dropped the first event")
+	}
+
+	d := receive(t, deliveries)
+	if target := n.awaitSaved(t); target.GetDateTime("lastSent").IsZero() {
+		t.Error("the delivery wasn't recorded on the target")
+	}
+	if got := d.header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization = %q, want the target token", got)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal([]byte(d.body), &payload); err != nil {
+		t.Fatalf("invalid webhook payload: %v", err)
+	}
+	if payload["type"] != "loginFailed" || payload["key"] != "token1" || payload["message"] != "The login failed." {
+		t.Errorf("payload = %v, want the event", payload)
+	}
+	expectNothing(t, otherDeliveries)
+
+	// the same event is only sent once within the deduplication window
+	if n.Notify(event) {
+		t.Error("Notify() accepted a duplicate event")
+	}
+	expectNothing(t, deliveries)
+
+	event.Key = "token2"
+	if !n.Notify(event) {
+		t.Error("Notify() dropped the event of another token")
+	}
+	receive(t, deliveries)
+	n.awaitSaved(t)
+}
+
+func TestNotifyHoldsEventsDuringQuietHours(t *testing.T) {
+	n := newNotifier(t)
+	app := n.app
+	server, deliveries := newTargetServer(t)
+
+	faketest.NewRecord(t, app, "notifiers", map[string]any{
+		"name": "Webhook", "type": "webhook", "url": server.URL,
+		"events": []string{"loginFailed"}, "enabled": true,
+	})
+
+	now := time.Now()
+	quiet := now.Add(-time.Hour).Format("15:04") + "-" + now.Add(time.Hour).Format("15:04")
+	settings := faketest.NewRecord(t, app, "settings", map[string]any{
+		"notificationEvents": map[string]any{"loginFailed": map[string]any{"quietHours": quiet}},
+	})
+
+	if !n.Notify(notify.Event{Type: notify.EventLoginFailed, Key: "token1", Title: "Login failed"}) {
+		t.Fatal("Notify() dropped the event")
+	}
+	expectNothing(t, deliveries)
+
+	settings.Set("notificationEvents", map[string]any{})
+	if err := app.Save(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, job := range app.Cron().Jobs() {
+		if job.Id() == "send-held-notifications" {
+			job.Run()
+		}
+	}
+	receive(t, deliveries)
+}
+
+func TestTargetsAreValidated(t *testing.T) {
+	app := newNotifier(t).app
+
+	tests := []struct {
+		name    string
+		data    map[string]any
+		wantErr string
+	}{
+		{"webhook", map[string]any{"type": "webhook", "url": "https://example.com/hook"}, ""},
+		{"webhook without URL", map[string]any{"type": "webhook"}, "url"},
+		{"gotify without token", map[string]any{"type": "gotify", "url": "https://gotify.example.com"}, "token"},
+		{"email", map[string]any{"type": "email", "target": "me@example.com"}, ""},
+		{"invalid email", map[string]any{"type": "email", "target": "me"}, "target"},
+		{"telegram without chat", map[string]any{"type": "telegram", "token": "123:abc"}, "target"},
+		{"telegram without token", map[string]any{"type": "telegram", "target": "42"}, "token"},
+	}
+
+	collection, err := app.FindCollectionByNameOrId("notifiers")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := core.NewRecord(collection)
+			record.Load(tt.data)
+			record.Set("name", tt.name)
+
+			err := app.Save(record)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Save() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Save() error = %v, want an error for %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandleTestRequest(t *testing.T) {
+	n := newNotifier(t)
+	app := n.app
+	server, deliveries := newTargetServer(t)
+
+	tests := []struct {
+		name  string
+		data  map[string]any
+		check func(t *testing.T, d delivery)
+	}{
+		{
+			"ntfy",
+			map[string]any{"name": "ntfy", "type": "ntfy", "url": server.URL + "/alerts"},
+			func(t *testing.T, d delivery) {
+				if d.path != "/alerts" || d.header.Get("Title") != "Test notification" {
+					t.Errorf("ntfy request = %s %v, want the topic and the title header", d.path, d.header)
+				}
+			},
+		},
+		{
+			"gotify",
+			map[string]any{"name": "Gotify", "type": "gotify", "url": server.URL, "token": "app-token"},
+			func(t *testing.T, d delivery) {
+				if d.path != "/message" || d.header.Get("X-Gotify-Key") != "app-token" {
+					t.Errorf("gotify request = %s %v, want /message with the application token", d.path, d.header)
+				}
+			},
+		},
+		{
+			"telegram",
+			map[string]any{"name": "Telegram", "type": "telegram", "url": server.URL, "token": "123:abc", "target": "42"},
+			func(t *testing.T, d delivery) {
+				if d.path != "/bot123:abc/sendMessage" || !strings.Contains(d.body, `"chat_id":"42"`) {
+					t.Errorf("telegram request = %s %s, want the bot token and chat ID", d.path, d.body)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := faketest.NewRecord(t, app, "notifiers", tt.data)
+
+			if err := sendTest(t, n, target); err != nil {
+				t.Fatalf("HandleTestRequest() error = %v", err)
+			}
+			tt.check(t, receive(t, deliveries))
+
+			target, err := app.FindRecordById("notifiers", target.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target.GetDateTime("lastSent").IsZero() || target.GetString("lastError") != "" {
+				t.Errorf("lastSent = %v, lastError = %q, want the successful delivery recorded",
+					target.GetDateTime("lastSent"), target.GetString("lastError"))
+			}
+		})
+	}
+}
+
+func TestHandleTestRequestHidesTelegramToken(t *testing.T) {
+	n := newNotifier(t)
+	app := n.app
+	server, _ := newTargetServer(t)
+	server.Close()
+
+	target := faketest.NewRecord(t, app, "notifiers", map[string]any{
+		"name": "Telegram", "type": "telegram", "url": server.URL, "token": "123:abc", "target": "42",
+	})
+
+	err := sendTest(t, n, target)
+	if err == nil {
+		t.Fatal("HandleTestRequest() to a closed server succeeded")
+	}
+	if strings.Contains(err.Error(), "123:abc") {
+		t.Errorf("error %q contains the bot token", err)
+	}
+
+	target, err = app.FindRecordById("notifiers", target.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastError := target.GetString("lastError"); lastError == "" || strings.Contains(lastError, "123:abc") {
+		t.Errorf("lastError = %q, want the error without the bot token", lastError)
+	}
+}
+
+// sendTest sends a test notification to the target like the web UI.
+func sendTest(t *testing.T, n *testNotifier, target *core.Record) error {
+	t.Helper()
+
+	e := &core.RequestEvent{}
+	e.Request = httptest.NewRequest(http.MethodPost, "/api/notifiers/"+target.Id+"/test", nil)
+	e.Request.SetPathValue("id", target.Id)
+	e.Response = httptest.NewRecorder()
+
+	return n.HandleTestRequest(e)
+}
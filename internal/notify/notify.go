@@ -0,0 +1,312 @@
+// Package notify sends notifications about failing tokens, logins and upstream requests
+// to webhooks, ntfy, Gotify, email and Telegram.
+//
+// The targets are the records of the notifiers collection, each subscribed to a set of event types.
+// Every event type has a deduplication window and optional quiet hours in the settings record.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// EventType is the kind of an event, which targets subscribe to.
+type EventType string
+
+const (
+	// EventTokenStatus is sent when a token becomes invalid or valid again.
+	EventTokenStatus EventType = "tokenStatus"
+	// EventLoginFailed is sent when a password grant login fails,
+	// e.g. because the password changed or tado deleted the account.
+	EventLoginFailed EventType = "loginFailed"
+	// EventAccountQuarantined is sent when the logins of an account are stopped after repeated failures.
+	EventAccountQuarantined EventType = "accountQuarantined"
+	// EventQuota is sent when a token used 80, 95 or 100% of its daily limit.
+	EventQuota EventType = "quota"
+	// EventDeviceCodeExpired is sent when a device code expired before it was authorized.
+	EventDeviceCodeExpired EventType = "deviceCodeExpired"
+	// EventUpstreamErrors is sent when the tado API answers repeatedly with a server error.
+	EventUpstreamErrors EventType = "upstreamErrors"
+	// EventQuotaForecast is sent when the quota of a home or of all tokens is projected to run out before the reset.
+	EventQuotaForecast EventType = "quotaForecast"
+)
+
+// EventTypes lists all event types.
+var EventTypes = []EventType{
+	EventTokenStatus,
+	EventLoginFailed,
+	EventAccountQuarantined,
+	EventQuota,
+	EventDeviceCodeExpired,
+	EventUpstreamErrors,
+	EventQuotaForecast,
+}
+
+// Event is a notification.
+type Event struct {
+	Type EventType
+	// Key identifies the subject of the event within its type, e.g. the token ID.
+	// Events with the same type and key are deduplicated.
+	Key     string
+	Title   string
+	Message string
+	Time    time.Time
+}
+
+// eventSettings are the settings of an event type in the notificationEvents settings field.
+type eventSettings struct {
+	// DedupMinutes is the time in which an event with the same key is only sent once.
+	DedupMinutes *float64 `json:"dedupMinutes"`
+	// QuietHours is a local time range like "22:00-07:00", in which events are held back.
+	QuietHours string `json:"quietHours"`
+}
+
+// defaultDedup is the deduplication window by event type. Quota events are keyed by the
+// day, so they are sent once per threshold and day, forecasts once per home and day.
+var defaultDedup = map[EventType]time.Duration{
+	EventTokenStatus:        time.Hour,
+	EventLoginFailed:        6 * time.Hour,
+	EventAccountQuarantined: 0,
+	EventQuota:              24 * time.Hour,
+	EventDeviceCodeExpired:  0,
+	EventUpstreamErrors:     30 * time.Minute,
+	EventQuotaForecast:      24 * time.Hour,
+}
+
+// maxDedup is the time after which sent events are forgotten.
+const maxDedup = 7 * 24 * time.Hour
+
+// sendTimeout limits the delivery to a single target.
+const sendTimeout = 30 * time.Second
+
+// eventConfig is the parsed configuration of an event type.
+type eventConfig struct {
+	dedup time.Duration
+	quiet *quietHours
+}
+
+// Notifier sends events to the notification targets.
+type Notifier struct {
+	app    core.App
+	client *req.Client
+
+	mu   sync.Mutex
+	sent map[string]time.Time
+	// held contains the events that arrived during the quiet hours of their type
+	held []Event
+}
+
+// NewNotifier creates a new notifier.
+func NewNotifier(app core.App) *Notifier {
+	return &Notifier{
+		app:    app,
+		client: req.C().SetTimeout(sendTimeout),
+		sent:   make(map[string]time.Time),
+	}
+}
+
+// Notify sends the event to all enabled targets subscribed to its type. An event is dropped if one
+// with the same type and key was sent within the deduplication window, and held back until
+// the quiet hours of its type end. It doesn't block, the delivery happens in the background.
+// It reports whether the event was accepted, i.e. wasn't dropped as a duplicate.
+func (n *Notifier) Notify(event Event) bool {
+	if n == nil {
+		return false
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	cfg := n.eventConfig(event.Type)
+	key := string(event.Type) + "/" + event.Key
+
+	n.mu.Lock()
+	for k, sent := range n.sent {
+		if event.Time.Sub(sent) > maxDedup {
+			delete(n.sent, k)
+		}
+	}
+	if sent, ok := n.sent[key]; ok && event.Time.Sub(sent) < cfg.dedup {
+		n.mu.Unlock()
+		n.app.Logger().Debug("dropped duplicate notification", "type", event.Type, "key", event.Key)
+		return false
+	}
+	n.sent[key] = event.Time
+
+	if cfg.quiet.contains(event.Time) {
+		n.held = append(n.held, event)
+		n.mu.Unlock()
+		n.app.Logger().Debug("holding notification during quiet hours", "type", event.Type, "key", event.Key)
+		return true
+	}
+	n.mu.Unlock()
+
+	go n.send(event)
+	return true
+}
+
+// releaseHeld sends the held events whose quiet hours ended.
+func (n *Notifier) releaseHeld() {
+	now := time.Now()
+
+	quiet := map[EventType]bool{}
+	for _, eventType := range EventTypes {
+		quiet[eventType] = n.eventConfig(eventType).quiet.contains(now)
+	}
+
+	n.mu.Lock()
+	var due, held []Event
+	for _, event := range n.held {
+		if quiet[event.Type] {
+			held = append(held, event)
+		} else {
+			due = append(due, event)
+		}
+	}
+	n.held = held
+	n.mu.Unlock()
+
+	for _, event := range due {
+		n.send(event)
+	}
+}
+
+// send delivers the event to all subscribed targets and records the result on them.
+func (n *Notifier) send(event Event) {
+	targets, err := n.app.FindRecordsByFilter(
+		"notifiers",
+		"enabled = true && events:each ?= {:type}",
+		"", 0, 0,
+		map[string]any{"type": string(event.Type)},
+	)
+	if err != nil {
+		n.app.Logger().Error("failed to find notification targets", "error", err)
+		return
+	}
+
+	for _, target := range targets {
+		if err := n.sendRecorded(target, event); err != nil {
+			n.app.Logger().Warn("failed to send notification", "target", target.GetString("name"), "type", event.Type, "error", err)
+		}
+	}
+}
+
+// sendRecorded delivers the event to a target and records the result on it.
+func (n *Notifier) sendRecorded(target *core.Record, event Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	err := n.sendTo(ctx, target, event)
+	if err != nil {
+		target.Set("lastError", err.Error())
+	} else {
+		target.Set("lastSent", time.Now())
+		target.Set("lastError", "")
+	}
+
+	if saveErr := n.app.Save(target); saveErr != nil {
+		n.app.Logger().Error("failed to save notification target", "target", target.GetString("name"), "error", saveErr)
+	}
+
+	return err
+}
+
+// eventConfig returns the configuration of an event type from the settings record.
+// Invalid settings are logged and replaced by the defaults.
+func (n *Notifier) eventConfig(eventType EventType) eventConfig {
+	cfg := eventConfig{dedup: defaultDedup[eventType]}
+
+	settings, err := n.app.FindFirstRecordByFilter("settings", "")
+	if err != nil || settings.GetString("notificationEvents") == "" {
+		return cfg
+	}
+
+	var events map[EventType]eventSettings
+	if err := settings.UnmarshalJSONField("notificationEvents", &events); err != nil {
+		n.app.Logger().Error("invalid notificationEvents", "error", err)
+		return cfg
+	}
+
+	s, ok := events[eventType]
+	if !ok {
+		return cfg
+	}
+	if s.DedupMinutes != nil {
+		cfg.dedup = time.Duration(max(*s.DedupMinutes, 0) * float64(time.Minute))
+	}
+	if s.QuietHours != "" {
+		quiet, err := parseQuietHours(s.QuietHours)
+		if err != nil {
+			n.app.Logger().Error("invalid quiet hours", "type", eventType, "error", err)
+		}
+		cfg.quiet = quiet
+	}
+
+	return cfg
+}
+
+// quietHours is a daily time range in local time, in minutes since midnight.
+// The range wraps around midnight if the start is after the end.
+type quietHours struct {
+	start, end int
+}
+
+// parseQuietHours parses a time range like "22:00-07:00".
+func parseQuietHours(value string) (*quietHours, error) {
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return nil, fmt.Errorf("expected a range like 22:00-07:00, got %q", value)
+	}
+
+	start, err := parseClock(from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &quietHours{start: start, end: end}, nil
+}
+
+// parseClock parses a time like "07:30" into minutes since midnight.
+func parseClock(value string) (int, error) {
+	hours, minutes, _ := strings.Cut(strings.TrimSpace(value), ":")
+	h, err := strconv.Atoi(hours)
+	if err != nil || h < 0 || h > 23 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+
+	m := 0
+	if minutes != "" {
+		m, err = strconv.Atoi(minutes)
+		if err != nil || m < 0 || m > 59 {
+			return 0, fmt.Errorf("invalid time %q", value)
+		}
+	}
+
+	return h*60 + m, nil
+}
+
+// contains reports whether the time is within the quiet hours. A nil range contains no time.
+func (q *quietHours) contains(t time.Time) bool {
+	if q == nil || q.start == q.end {
+		return false
+	}
+
+	local := t.Local()
+	minute := local.Hour()*60 + local.Minute()
+
+	if q.start < q.end {
+		return minute >= q.start && minute < q.end
+	}
+	return minute >= q.start || minute < q.end
+}
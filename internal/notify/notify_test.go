@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    quietHours
+		wantErr bool
+	}{
+		{"22:00-07:00", quietHours{start: 22 * 60, end: 7 * 60}, false},
+		{" 8-9:30 ", quietHours{start: 8 * 60, end: 9*60 + 30}, false},
+		{"22:00", quietHours{}, true},
+		{"24:00-07:00", quietHours{}, true},
+		{"22:60-07:00", quietHours{}, true},
+		{"late-early", quietHours{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseQuietHours(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseQuietHours(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && *got != tt.want {
+			t.Errorf("parseQuietHours(%q) = %+v, want %+v", tt.value, *got, tt.want)
+		}
+	}
+}
+
+func TestQuietHoursContains(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2026, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+	overnight := &quietHours{start: 22 * 60, end: 7 * 60}
+	daytime := &quietHours{start: 9 * 60, end: 17 * 60}
+
+	tests := []struct {
+		quiet *quietHours
+		time  time.Time
+		want  bool
+	}{
+		{overnight, at(23, 0), true},
+		{overnight, at(3, 0), true},
+		{overnight, at(7, 0), false},
+		{overnight, at(12, 0), false},
+		{daytime, at(9, 0), true},
+		{daytime, at(16, 59), true},
+		{daytime, at(17, 0), false},
+		{daytime, at(8, 0), false},
+		// an empty range and no range contain no time
+		{&quietHours{start: 60, end: 60}, at(1, 0), false},
+		{nil, at(1, 0), false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.quiet.contains(tt.time); got != tt.want {
+			t.Errorf("%+v.contains(%s) = %v, want %v", tt.quiet, tt.time.Format("15:04"), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+	"github.com/s1adem4n/tado-api-proxy/internal/secrets"
+)
+
+// defaultTelegramURL is the Telegram Bot API, used if a telegram target has no URL.
+const defaultTelegramURL = "https://api.telegram.org"
+
+// webhookPayload is the JSON body posted to webhook targets.
+type webhookPayload struct {
+	Type    EventType `json:"type"`
+	Key     string    `json:"key"`
+	Title   string    `json:"title"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// sendTo delivers the event to a target. The meaning of the url, token and target
+// fields depends on the target type.
+func (n *Notifier) sendTo(ctx context.Context, target *core.Record, event Event) error {
+	token, err := secrets.Get(target, "token")
+	if err != nil {
+		return err
+	}
+	targetURL := strings.TrimSuffix(target.GetString("url"), "/")
+
+	switch target.GetString("type") {
+	case "webhook":
+		request := n.client.R().
+			SetContext(ctx).
+			SetBodyJsonMarshal(webhookPayload{
+				Type:    event.Type,
+				Key:     event.Key,
+				Title:   event.Title,
+				Message: event.Message,
+				Time:    event.Time,
+			})
+		if token != "" {
+			request.SetBearerAuthToken(token)
+		}
+
+		return checkResponse(request.Post(targetURL))
+	case "ntfy":
+		// the URL includes the topic, e.g. https://ntfy.sh/my-topic
+		request := n.client.R().
+			SetContext(ctx).
+			SetHeader("Title", event.Title).
+			SetHeader("Tags", "warning").
+			SetBodyString(event.Message)
+		if token != "" {
+			request.SetBearerAuthToken(token)
+		}
+
+		return checkResponse(request.Post(targetURL))
+	case "gotify":
+		return checkResponse(n.client.R().
+			SetContext(ctx).
+			SetHeader("X-Gotify-Key", token).
+			SetBodyJsonMarshal(map[string]any{
+				"title":    event.Title,
+				"message":  event.Message,
+				"priority": 5,
+			}).
+			Post(targetURL + "/message"))
+	case "email":
+		settings := n.app.Settings()
+		return n.app.NewMailClient().Send(&mailer.Message{
+			From: mail.Address{
+				Name:    settings.Meta.SenderName,
+				Address: settings.Meta.SenderAddress,
+			},
+			To:      []mail.Address{{Address: target.GetString("target")}},
+			Subject: event.Title,
+			Text:    event.Message,
+			HTML:    "<p>" + strings.ReplaceAll(html.EscapeString(event.Message), "\n", "<br>") + "</p>",
+		})
+	case "telegram":
+		if targetURL == "" {
+			targetURL = defaultTelegramURL
+		}
+
+		err := checkResponse(n.client.R().
+			SetContext(ctx).
+			SetBodyJsonMarshal(map[string]any{
+				"chat_id": target.GetString("target"),
+				"text":    event.Title + "\n\n" + event.Message,
+			}).
+			Post(targetURL + "/bot" + token + "/sendMessage"))
+
+		// the bot token is part of the URL, so it must not end up in the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown target type %q", target.GetString("type"))
+	}
+}
+
+// checkResponse returns an error if the request failed or the response has an error status.
+func checkResponse(resp *req.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	if resp.IsErrorState() {
+		return fmt.Errorf("target returned %d: %s", resp.StatusCode, resp.String())
+	}
+
+	return nil
+}
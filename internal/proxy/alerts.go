@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"cmp"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/s1adem4n/tado-api-proxy/internal/notify"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+const (
+	// serverErrorThreshold is the number of 5xx responses within serverErrorWindow that triggers a notification.
+	serverErrorThreshold = 5
+	serverErrorWindow    = 10 * time.Minute
+)
+
+// quotaThresholds are the shares of a token's daily limit that trigger a notification, highest first.
+var quotaThresholds = []int{100, 95, 80}
+
+// serverErrors counts the recent 5xx responses of the upstream hosts.
+type serverErrors struct {
+	mu    sync.Mutex
+	times map[string][]time.Time
+}
+
+// record adds a 5xx response of the host and returns the number of them within the window.
+func (s *serverErrors) record(host string, now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.times == nil {
+		s.times = make(map[string][]time.Time)
+	}
+
+	recent := s.times[host][:0]
+	for _, t := range s.times[host] {
+		if now.Sub(t) < serverErrorWindow {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	s.times[host] = recent
+
+	return len(recent)
+}
+
+// recordServerError notifies if the upstream host answered repeatedly with a server error.
+func (h *Handler) recordServerError(host string, status int) {
+	count := h.serverErrors.record(host, time.Now())
+	if count < serverErrorThreshold {
+		return
+	}
+
+	h.notifier.Notify(notify.Event{
+		Type:  notify.EventUpstreamErrors,
+		Key:   host,
+		Title: "tado API errors",
+		Message: fmt.Sprintf(
+			"%s answered %d requests with a server error in the last %d minutes, the last one with %d.",
+			host, count, int(serverErrorWindow.Minutes()), status,
+		),
+	})
+}
+
+// checkQuotaThresholds notifies about the tokens that used a threshold share of their daily limit.
+// Only the highest threshold reached is sent, once per token and day.
+func (h *Handler) checkQuotaThresholds() error {
+	cutoff, err := tokens.GetRatelimitCutoff()
+	if err != nil {
+		return err
+	}
+
+	usages, err := h.getTokensUsage()
+	if err != nil {
+		return err
+	}
+
+	for _, u := range usages {
+		limit := u.client.GetInt("dailyLimit")
+		if u.token.GetBool("disabled") || limit <= 0 {
+			continue
+		}
+
+		for _, threshold := range quotaThresholds {
+			if u.used*100 < limit*threshold {
+				continue
+			}
+
+			h.notifier.Notify(notify.Event{
+				Type:  notify.EventQuota,
+				Key:   fmt.Sprintf("%s/%d/%d", u.token.Id, threshold, cutoff.Unix()),
+				Title: fmt.Sprintf("%d%% of the daily quota used", threshold),
+				Message: fmt.Sprintf(
+					"The token of %s made %d of %d requests since the reset at %s.",
+					notify.DescribeToken(h.app, u.token), u.used, limit, cutoff.Local().Format("15:04"),
+				),
+			})
+			break
+		}
+	}
+
+	return nil
+}
+
+// checkQuotaForecast notifies about the homes and the pool of all tokens whose quota is projected
+// to run out before the reset at the current burn rate, once per home and day. Quotas that are
+// already used up are left to the thresholds.
+func (h *Handler) checkQuotaForecast() error {
+	now := time.Now()
+
+	usages, err := h.getTokensUsage()
+	if err != nil {
+		return err
+	}
+
+	forecast, err := h.forecast(usages, now)
+	if err != nil {
+		return err
+	}
+	if now.Sub(forecast.Reset.Add(-24*time.Hour)) < forecastWarmup {
+		return nil
+	}
+
+	notifyEarly := func(key, subject string, f QuotaForecast) {
+		if !f.ExhaustsEarly || f.Remaining <= 0 {
+			return
+		}
+
+		accepted := h.notifier.Notify(notify.Event{
+			Type:  notify.EventQuotaForecast,
+			Key:   fmt.Sprintf("%s/%d", key, forecast.Reset.Unix()),
+			Title: "Quota runs out before the reset",
+			Message: fmt.Sprintf(
+				"At %.0f requests per hour, the %d remaining requests of %s run out at %s, before the reset at %s.",
+				f.BurnRate, f.Remaining, subject, f.ExhaustsAt.Local().Format("15:04"), forecast.Reset.Local().Format("15:04"),
+			),
+		})
+
+		// the check runs every minute, so only the first one of the day is logged
+		if accepted {
+			h.app.Logger().Warn(
+				"quota projected to run out before the reset",
+				"subject", subject,
+				"burnRate", f.BurnRate,
+				"exhaustsAt", f.ExhaustsAt,
+				"reset", forecast.Reset,
+			)
+		}
+	}
+
+	notifyEarly("pool", "all tokens", forecast.Pool)
+	for _, home := range forecast.Homes {
+		notifyEarly("home/"+home.ID, fmt.Sprintf("the home %s", cmp.Or(home.Name, home.ID)), home.QuotaForecast)
+	}
+
+	return nil
+}
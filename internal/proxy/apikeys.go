@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// mePath lists the homes of the account. Keys limited to some homes only see those.
+const mePath = "/api/v2/me"
+
+// homelessReads are the paths without a home ID that keys limited to some homes may read.
+var homelessReads = []string{mePath}
+
+// apiKeyPathCache keeps the compiled path patterns of each API key, so they aren't compiled for every request.
+type apiKeyPathCache struct {
+	mu   sync.Mutex
+	keys map[string]compiledPaths
+}
+
+// compiledPaths are the path patterns of an API key.
+type compiledPaths struct {
+	// raw is the value of the paths field the patterns were compiled from
+	raw      string
+	patterns []*regexp.Regexp
+}
+
+func newAPIKeyPathCache() *apiKeyPathCache {
+	return &apiKeyPathCache{keys: make(map[string]compiledPaths)}
+}
+
+// get returns the compiled path patterns of the API key. They are compiled again after the paths changed.
+func (c *apiKeyPathCache) get(apiKey *core.Record) ([]*regexp.Regexp, error) {
+	raw := apiKey.GetString("paths")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if compiled, ok := c.keys[apiKey.Id]; ok && compiled.raw == raw {
+		return compiled.patterns, nil
+	}
+
+	patterns, err := compileAPIKeyPaths(apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	c.keys[apiKey.Id] = compiledPaths{raw: raw, patterns: patterns}
+	return patterns, nil
+}
+
+// remove drops the patterns of a deleted API key.
+func (c *apiKeyPathCache) remove(e *core.RecordEvent) error {
+	c.mu.Lock()
+	delete(c.keys, e.Record.Id)
+	c.mu.Unlock()
+
+	return e.Next()
+}
+
+// compileAPIKeyPaths compiles the path patterns of the API key.
+func compileAPIKeyPaths(apiKey *core.Record) ([]*regexp.Regexp, error) {
+	if apiKey.GetString("paths") == "" {
+		return nil, nil
+	}
+
+	var paths []string
+	if err := apiKey.UnmarshalJSONField("paths", &paths); err != nil {
+		return nil, err
+	}
+
+	patterns := make([]*regexp.Regexp, 0, len(paths))
+	for _, path := range paths {
+		pattern, err := regexp.Compile(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", path, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	return patterns, nil
+}
+
+// validateAPIKey checks that the path patterns of an API key compile.
+func validateAPIKey(apiKey *core.Record) error {
+	if _, err := compileAPIKeyPaths(apiKey); err != nil {
+		return validation.Errors{
+			"paths": validation.NewError("validation_invalid_pattern", "Must be a list of valid regular expressions: "+err.Error()),
+		}
+	}
+
+	return nil
+}
+
+// findAPIKey returns the API key record for the given key.
+// Expired keys are treated as if they did not exist.
+func (h *Handler) findAPIKey(key string) (*core.Record, error) {
+	record, err := h.app.FindFirstRecordByData("apiKeys", "key", key)
+	if err != nil {
+		return nil, err
+	}
+
+	expires := record.GetDateTime("expires")
+	if !expires.IsZero() && time.Now().After(expires.Time()) {
+		return nil, fmt.Errorf("api key %s expired", record.Id)
+	}
+
+	return record, nil
+}
+
+// requestAPIKey looks up the API key sent in the X-Api-Key or Authorization header.
+// It returns nil if the request carries no known key.
+func (h *Handler) requestAPIKey(e *core.RequestEvent) (*core.Record, error) {
+	if key := e.Request.Header.Get("X-Api-Key"); key != "" {
+		apiKey, err := h.findAPIKey(key)
+		if err != nil {
+			return nil, e.UnauthorizedError("Invalid API key", nil)
+		}
+		return apiKey, nil
+	}
+
+	// Clients often send their own tado token, so unknown bearer tokens are ignored
+	authorization := e.Request.Header.Get("Authorization")
+	if key, ok := strings.CutPrefix(authorization, "Bearer "); ok && key != "" {
+		apiKey, err := h.findAPIKey(key)
+		if err == nil {
+			return apiKey, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// checkAPIKeyScope verifies that the API key is allowed to make the request.
+func (h *Handler) checkAPIKeyScope(e *core.RequestEvent, apiKey *core.Record, upstreamPath string) error {
+	if apiKey.GetString("access") != "readWrite" {
+		switch e.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			return e.ForbiddenError("API key is read-only", nil)
+		}
+	}
+
+	homes := apiKey.GetStringSlice("homes")
+	homeID := extractHomeID(upstreamPath)
+	if len(homes) > 0 {
+		// other paths, like the devices, can reach any home of the account
+		if homeID == "" {
+			if e.Request.Method != http.MethodGet || !slices.Contains(homelessReads, upstreamPath) {
+				return e.ForbiddenError("API key is limited to homes and not allowed to access this path", nil)
+			}
+		} else {
+			home, err := h.app.FindFirstRecordByData("homes", "tadoID", homeID)
+			if err != nil || !slices.Contains(homes, home.Id) {
+				return e.ForbiddenError("API key is not allowed to access this home", nil)
+			}
+		}
+	}
+
+	// the patterns are validated when the key is saved, so this only fails for keys saved before
+	patterns, err := h.apiKeyPaths.get(apiKey)
+	if err != nil {
+		h.app.Logger().Error("invalid api key paths", "id", apiKey.Id, "error", err)
+		return e.ForbiddenError("API key is not allowed to access this path", nil)
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	for _, pattern := range patterns {
+		if pattern.MatchString(upstreamPath) {
+			return nil
+		}
+	}
+
+	return e.ForbiddenError("API key is not allowed to access this path", nil)
+}
+
+// restrictResponse removes the homes the API key isn't allowed to access from the response of /me.
+// Other responses are returned as is.
+func (h *Handler) restrictResponse(apiKey *core.Record, upstreamPath string, response *proxyResponse) (*proxyResponse, error) {
+	if apiKey == nil || upstreamPath != mePath || response.status != http.StatusOK {
+		return response, nil
+	}
+	homes := apiKey.GetStringSlice("homes")
+	if len(homes) == 0 {
+		return response, nil
+	}
+
+	records, err := h.app.FindRecordsByIds("homes", homes)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[string]bool, len(records))
+	for _, record := range records {
+		allowed[record.GetString("tadoID")] = true
+	}
+
+	var me map[string]json.RawMessage
+	if err := json.Unmarshal(response.body, &me); err != nil {
+		return nil, fmt.Errorf("invalid /me response: %w", err)
+	}
+	var all []json.RawMessage
+	if err := json.Unmarshal(me["homes"], &all); err != nil {
+		return nil, fmt.Errorf("invalid homes in /me response: %w", err)
+	}
+
+	visible := make([]json.RawMessage, 0, len(all))
+	for _, raw := range all {
+		var home struct {
+			ID json.Number `json:"id"`
+		}
+		if err := json.Unmarshal(raw, &home); err != nil {
+			return nil, fmt.Errorf("invalid home in /me response: %w", err)
+		}
+		if allowed[home.ID.String()] {
+			visible = append(visible, raw)
+		}
+	}
+
+	if me["homes"], err = json.Marshal(visible); err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(me)
+	if err != nil {
+		return nil, err
+	}
+
+	restricted := *response
+	restricted.body = body
+	restricted.header = response.header.Clone()
+	restricted.header.Del("Content-Length")
+	return &restricted, nil
+}
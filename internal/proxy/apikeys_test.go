@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/faketest"
+)
+
+func TestAPIKeyPathsAreValidated(t *testing.T) {
+	p := newTestProxy(t)
+	p.register(t)
+
+	apiKey := faketest.NewRecord(t, p.app, "apiKeys", map[string]any{
+		"name":   "valid",
+		"key":    "valid-key",
+		"access": "readOnly",
+		"paths":  []string{`^/api/v2/homes/\d+/zones$`},
+	})
+
+	apiKey.Set("paths", []string{`^/api/v2/homes/(\d+/zones$`})
+	if err := p.app.Save(apiKey); err == nil {
+		t.Errorf("saving an API key with an invalid pattern succeeded")
+	}
+}
+
+func TestCheckAPIKeyScopeCachesPatterns(t *testing.T) {
+	p := newTestProxy(t)
+	apiKey := faketest.NewRecord(t, p.app, "apiKeys", map[string]any{
+		"name":   "zones",
+		"key":    "zones-key",
+		"access": "readOnly",
+		"paths":  []string{`^/api/v2/homes/\d+/zones$`},
+	})
+
+	e, _ := newTestRequestEvent(http.MethodGet, "/zones-key/api/v2/homes/1/zones")
+	if err := p.handler.checkAPIKeyScope(e, apiKey, "/api/v2/homes/1/zones"); err != nil {
+		t.Fatalf("checkAPIKeyScope() error = %v", err)
+	}
+	first := p.handler.apiKeyPaths.keys[apiKey.Id].patterns[0]
+
+	if err := p.handler.checkAPIKeyScope(e, apiKey, "/api/v2/homes/1/weather"); err == nil {
+		t.Error("checkAPIKeyScope() allowed a path outside the patterns")
+	}
+	if p.handler.apiKeyPaths.keys[apiKey.Id].patterns[0] != first {
+		t.Error("the patterns were compiled again for the same paths")
+	}
+
+	// changed paths are compiled again
+	apiKey.Set("paths", []string{`^/api/v2/homes/\d+/weather$`})
+	if err := p.handler.checkAPIKeyScope(e, apiKey, "/api/v2/homes/1/weather"); err != nil {
+		t.Errorf("checkAPIKeyScope() with the changed paths error = %v", err)
+	}
+}
+
+func TestAPIKeyAccess(t *testing.T) {
+	addKeys := func(t testing.TB, p *testProxy) {
+		home, err := p.app.FindFirstRecordByData("homes", "tadoID", "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		other := faketest.NewRecord(t, p.app, "homes", map[string]any{"tadoID": "2", "name": "Other"})
+
+		faketest.NewRecord(t, p.app, "apiKeys", map[string]any{"name": "read", "key": "read-key", "access": "readOnly"})
+		faketest.NewRecord(t, p.app, "apiKeys", map[string]any{
+			"name": "home", "key": "home-key", "access": "readOnly", "homes": []string{home.Id},
+		})
+		faketest.NewRecord(t, p.app, "apiKeys", map[string]any{
+			"name": "other", "key": "other-key", "access": "readOnly", "homes": []string{other.Id},
+		})
+		faketest.NewRecord(t, p.app, "apiKeys", map[string]any{
+			"name": "home write", "key": "home-write-key", "access": "readWrite", "homes": []string{home.Id},
+		})
+		faketest.NewRecord(t, p.app, "apiKeys", map[string]any{
+			"name": "weather", "key": "weather-key", "access": "readOnly", "paths": []string{`/weather$`},
+		})
+		faketest.NewRecord(t, p.app, "apiKeys", map[string]any{
+			"name": "expired", "key": "expired-key", "access": "readWrite", "expires": time.Now().Add(-time.Hour),
+		})
+	}
+
+	scenarios := []struct {
+		name   string
+		method string
+		url    string
+		status int
+	}{
+		{"read-only key", http.MethodGet, "/read-key" + zonesPath, http.StatusOK},
+		{"read-only key writing", http.MethodPut, "/read-key" + zonesPath, http.StatusForbidden},
+		{"key of the home", http.MethodGet, "/home-key" + zonesPath, http.StatusOK},
+		{"key of another home", http.MethodGet, "/other-key" + zonesPath, http.StatusForbidden},
+		{"key of the home reading a device", http.MethodGet, "/home-key/api/v2/devices/X/temperatureOffset", http.StatusForbidden},
+		{"key of the home writing a device", http.MethodPut, "/home-write-key/api/v2/devices/X/temperatureOffset", http.StatusForbidden},
+		{"key of the home reading the account", http.MethodGet, "/home-key/api/v2/me", http.StatusOK},
+		{"path outside the patterns", http.MethodGet, "/weather-key" + zonesPath, http.StatusForbidden},
+		{"expired key", http.MethodGet, "/expired-key" + zonesPath, http.StatusNotFound},
+		{"unknown key", http.MethodGet, "/unknown-key" + zonesPath, http.StatusNotFound},
+	}
+
+	for _, s := range scenarios {
+		scenario := newRouteScenario(s.name, s.url, false, addKeys)
+		scenario.Method = s.method
+		scenario.ExpectedStatus = s.status
+		scenario.ExpectedContent = []string{"{"}
+		scenario.Test(t)
+	}
+
+	// the homes of the account are limited to the ones of the key
+	me := newRouteScenario("key of the home listing the homes", "/home-key/api/v2/me", false, addKeys)
+	me.ExpectedStatus = http.StatusOK
+	me.ExpectedContent = []string{`"homes":[{"id":1,"name":"Home"}]`}
+	me.NotExpectedContent = []string{`"Other"`}
+	me.Test(t)
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+// pooledLimitCache keeps the combined daily limit of all enabled tokens, so the budgets of API keys
+// don't query it for every request. Changes of tokens and clients invalidate it.
+type pooledLimitCache struct {
+	mu    sync.Mutex
+	valid bool
+	limit int
+	// generation is incremented by each invalidation, so a query that ran meanwhile isn't stored
+	generation int
+}
+
+// pooledLimit returns the combined daily limit of all enabled tokens.
+func (h *Handler) pooledLimit() (int, error) {
+	h.pooled.mu.Lock()
+	if h.pooled.valid {
+		defer h.pooled.mu.Unlock()
+		return h.pooled.limit, nil
+	}
+	generation := h.pooled.generation
+	h.pooled.mu.Unlock()
+
+	var limit int
+	err := h.app.DB().NewQuery(
+		"SELECT COALESCE(SUM(clients.dailyLimit), 0) FROM tokens JOIN clients ON clients.id = tokens.client WHERE tokens.disabled = false",
+	).Row(&limit)
+	if err != nil {
+		return 0, err
+	}
+
+	h.pooled.mu.Lock()
+	if h.pooled.generation == generation {
+		h.pooled.valid = true
+		h.pooled.limit = limit
+	}
+	h.pooled.mu.Unlock()
+
+	return limit, nil
+}
+
+// invalidatePooledLimit drops the cached pooled limit after a token or client changed.
+func (h *Handler) invalidatePooledLimit(e *core.RecordEvent) error {
+	h.pooled.mu.Lock()
+	h.pooled.valid = false
+	h.pooled.generation++
+	h.pooled.mu.Unlock()
+
+	return e.Next()
+}
+
+// consumerBudget returns the daily request budget of an API key, or 0 if it has none.
+// If both an absolute budget and a share of the pooled limit are set, the lower one applies.
+func (h *Handler) consumerBudget(apiKey *core.Record) (int, error) {
+	budget := apiKey.GetInt("dailyBudget")
+
+	percent := apiKey.GetFloat("budgetPercent")
+	if percent > 0 {
+		totalLimit, err := h.pooledLimit()
+		if err != nil {
+			return 0, err
+		}
+
+		share := int(float64(totalLimit) * percent / 100)
+		if budget == 0 || share < budget {
+			budget = share
+		}
+	}
+
+	return budget, nil
+}
+
+// checkConsumerBudget answers with 429 if the API key has used up its daily budget.
+// Other consumers are not affected.
+func (h *Handler) checkConsumerBudget(e *core.RequestEvent, apiKey *core.Record) error {
+	budget, err := h.consumerBudget(apiKey)
+	if err != nil {
+		return err
+	}
+	if budget <= 0 {
+		return nil
+	}
+
+	cutoff, err := tokens.GetRatelimitCutoff()
+	if err != nil {
+		return err
+	}
+
+	if h.consumerUsage.get(apiKey.Id) < budget {
+		return nil
+	}
+
+	retryAfter := int(time.Until(cutoff.Add(24*time.Hour)).Seconds()) + 1
+	rateLimitPolicy := fmt.Sprintf(`"perday";q=%d;w=86400`, budget)
+	rateLimit := fmt.Sprintf(`"perday";r=0;t=%d`, retryAfter)
+
+	e.Response.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	e.Response.Header().Set("Ratelimit-Policy", rateLimitPolicy)
+	e.Response.Header().Set("Ratelimit", rateLimit)
+
+	// compatibilty for tado_hijack
+	e.Response.Header()["RateLimit-Policy"] = []string{rateLimitPolicy}
+	e.Response.Header()["RateLimit"] = []string{rateLimit}
+
+	return e.TooManyRequestsError("API key has used up its daily budget", nil)
+}
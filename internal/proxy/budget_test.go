@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func newTestAPIKey(data map[string]any) *core.Record {
+	apiKey := core.NewRecord(core.NewBaseCollection("apiKeys"))
+	apiKey.Id = "key"
+	apiKey.Load(data)
+	return apiKey
+}
+
+func newTestRequestEvent(method, target string) (*core.RequestEvent, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	e := &core.RequestEvent{}
+	e.Request = httptest.NewRequest(method, target, nil)
+	e.Response = recorder
+	return e, recorder
+}
+
+func TestConsumerBudget(t *testing.T) {
+	p := newTestProxy(t)
+
+	// the two tokens of the test proxy pool 2000 requests
+	tests := []struct {
+		name string
+		data map[string]any
+		want int
+	}{
+		{"no budget", nil, 0},
+		{"absolute", map[string]any{"dailyBudget": 100}, 100},
+		{"share of the pool", map[string]any{"budgetPercent": 10}, 200},
+		{"lower absolute", map[string]any{"dailyBudget": 100, "budgetPercent": 10}, 100},
+		{"lower share", map[string]any{"dailyBudget": 500, "budgetPercent": 10}, 200},
+	}
+
+	for _, tt := range tests {
+		got, err := p.handler.consumerBudget(newTestAPIKey(tt.data))
+		if err != nil {
+			t.Fatalf("%s: consumerBudget() error = %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: consumerBudget() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPooledLimitIsCachedUntilTokensChange(t *testing.T) {
+	p := newTestProxy(t)
+	p.register(t)
+
+	if limit, err := p.handler.pooledLimit(); err != nil || limit != 2*p.server.DailyLimit {
+		t.Fatalf("pooledLimit() = %d, %v, want %d", limit, err, 2*p.server.DailyLimit)
+	}
+
+	// changes that bypass the records aren't seen until a token or client changes
+	if _, err := p.app.DB().NewQuery("UPDATE clients SET dailyLimit = 10").Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if limit, _ := p.handler.pooledLimit(); limit != 2*p.server.DailyLimit {
+		t.Errorf("pooledLimit() = %d, want the cached %d", limit, 2*p.server.DailyLimit)
+	}
+
+	token := p.tokens["mobile-client"]
+	token.Set("disabled", true)
+	if err := p.app.Save(token); err != nil {
+		t.Fatal(err)
+	}
+	if limit, _ := p.handler.pooledLimit(); limit != 10 {
+		t.Errorf("pooledLimit() = %d, want 10 of the enabled token", limit)
+	}
+}
+
+func TestCheckConsumerBudget(t *testing.T) {
+	p := newTestProxy(t)
+	apiKey := newTestAPIKey(map[string]any{"dailyBudget": 2})
+
+	p.handler.consumerUsage.add(apiKey.Id)
+
+	e, _ := newTestRequestEvent(http.MethodGet, "/key/api/v2/me")
+	if err := p.handler.checkConsumerBudget(e, apiKey); err != nil {
+		t.Fatalf("checkConsumerBudget() with budget left error = %v", err)
+	}
+
+	// other keys don't use up the budget
+	p.handler.consumerUsage.add("other")
+	p.handler.consumerUsage.add(apiKey.Id)
+
+	e, recorder := newTestRequestEvent(http.MethodGet, "/key/api/v2/me")
+	err := p.handler.checkConsumerBudget(e, apiKey)
+	if err == nil {
+		t.Fatal("checkConsumerBudget() with the budget used up succeeded")
+	}
+	if recorder.Header().Get("Retry-After") == "" {
+		t.Error("Retry-After header is missing")
+	}
+	if got, want := recorder.Header().Get("Ratelimit-Policy"), `"perday";q=2;w=86400`; got != want {
+		t.Errorf("Ratelimit-Policy = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// defaultCacheTTLs are used when the settings record has no cacheTTLs configured.
+// Keys are regular expressions matched against the upstream path, values are seconds.
+var defaultCacheTTLs = map[string]int{
+	`/zoneStates$`:        30,
+	`^/api/v2/me$`:        3600,
+	`/homes/\d+/weather$`: 600,
+}
+
+// cacheRule maps an upstream path pattern to how long its responses stay cached.
+type cacheRule struct {
+	pattern *regexp.Regexp
+	ttl     time.Duration
+}
+
+// cachedResponse is a response that was written to a client and can be replayed.
+type cachedResponse struct {
+	response *proxyResponse
+	homeID   string
+	expires  time.Time
+	// warmed is set for the responses of the polling scheduler, which are cached without an account scope
+	warmed bool
+}
+
+// responseCache stores upstream GET responses keyed by path, query and account scope.
+type responseCache struct {
+	mu      sync.RWMutex
+	ttls    map[string]int
+	rules   []cacheRule
+	entries map[string]*cachedResponse
+	// generations counts the invalidations of each home, so a response fetched before a write isn't stored after it
+	generations map[string]uint64
+}
+
+func newResponseCache() *responseCache {
+	c := &responseCache{
+		entries:     make(map[string]*cachedResponse),
+		generations: make(map[string]uint64),
+	}
+	c.ttls = defaultCacheTTLs
+	c.rules, _ = parseCacheRules(defaultCacheTTLs)
+	return c
+}
+
+// loadSettings replaces the cache rules with the ones configured in the settings record.
+// Cached entries are only dropped when the rules actually changed.
+func (c *responseCache) loadSettings(settings *core.Record) error {
+	var ttls map[string]int
+	if settings.GetString("cacheTTLs") != "" {
+		if err := settings.UnmarshalJSONField("cacheTTLs", &ttls); err != nil {
+			return fmt.Errorf("invalid cacheTTLs: %w", err)
+		}
+	}
+	if ttls == nil {
+		ttls = defaultCacheTTLs
+	}
+
+	c.mu.RLock()
+	unchanged := maps.Equal(c.ttls, ttls)
+	c.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	rules, err := parseCacheRules(ttls)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.ttls = ttls
+	c.rules = rules
+	c.entries = make(map[string]*cachedResponse)
+	c.mu.Unlock()
+
+	return nil
+}
+
+// parseCacheRules compiles the given patterns in a stable order.
+func parseCacheRules(ttls map[string]int) ([]cacheRule, error) {
+	patterns := make([]string, 0, len(ttls))
+	for pattern := range ttls {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	rules := make([]cacheRule, 0, len(patterns))
+	for _, pattern := range patterns {
+		if ttls[pattern] <= 0 {
+			continue
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache pattern %q: %w", pattern, err)
+		}
+
+		rules = append(rules, cacheRule{
+			pattern: re,
+			ttl:     time.Duration(ttls[pattern]) * time.Second,
+		})
+	}
+
+	return rules, nil
+}
+
+// ttl returns how long responses for the given upstream path may be cached.
+// A zero duration means the path is not cacheable.
+func (c *responseCache) ttl(upstreamPath string) time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, rule := range c.rules {
+		if rule.pattern.MatchString(upstreamPath) {
+			return rule.ttl
+		}
+	}
+
+	return 0
+}
+
+func (c *responseCache) get(key string) *cachedResponse {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil
+	}
+
+	return entry
+}
+
+// generation returns the number of invalidations of the home. Requests read it before they are sent.
+func (c *responseCache) generation(homeID string) uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.generations[homeID]
+}
+
+// set stores the entry unless its home was invalidated since the request read the generation.
+func (c *responseCache) set(key string, entry *cachedResponse, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generations[entry.homeID] != generation {
+		return
+	}
+	c.entries[key] = entry
+}
+
+// invalidateHome removes all cached entries belonging to the given home.
+func (c *responseCache) invalidateHome(homeID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generations[homeID]++
+	for key, entry := range c.entries {
+		if entry.homeID == homeID {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// purgeExpired removes all entries whose TTL has passed.
+func (c *responseCache) purgeExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// cacheKey builds the key for a request, scoped to the account the caller targets.
+func cacheKey(upstreamPath, rawQuery, scope string) string {
+	return strings.Join([]string{strings.ToLower(scope), upstreamPath, rawQuery}, "|")
+}
+
+// cachedResponse returns the cached response for the key. Requests for an account fall back to
+// the response the scheduler polled for the home, if the account can access the home. Responses
+// without a home, like /me, depend on the account, so they have no fallback.
+func (h *Handler) cachedResponse(key, upstreamPath, rawQuery, scope, homeID string) *cachedResponse {
+	if cached := h.cache.get(key); cached != nil {
+		return cached
+	}
+	if scope == "" || homeID == "" {
+		return nil
+	}
+
+	warmed := h.cache.get(cacheKey(upstreamPath, rawQuery, ""))
+	if warmed == nil || !warmed.warmed {
+		return nil
+	}
+
+	account, err := h.app.FindFirstRecordByFilter(
+		"accounts",
+		"email = {:email} && homes.tadoID ?= {:homeID}",
+		dbx.Params{"email": scope, "homeID": homeID},
+	)
+	if err != nil || account == nil {
+		return nil
+	}
+
+	return warmed
+}
+
+// writeCachedResponse replays a cached response to the client.
+func (h *Handler) writeCachedResponse(e *core.RequestEvent, response *proxyResponse) {
+	e.Response.Header().Set("X-Cache", "HIT")
+	h.writeProxyResponse(e, response)
+}
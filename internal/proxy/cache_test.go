@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResponseCacheSkipsResponsesFetchedBeforeWrite(t *testing.T) {
+	c := newResponseCache()
+	entry := func(homeID string) *cachedResponse {
+		return &cachedResponse{homeID: homeID, expires: time.Now().Add(time.Minute)}
+	}
+
+	// a GET reads the generation, then a write invalidates the home before it's answered
+	inFlight := c.generation("1")
+	other := c.generation("2")
+	c.invalidateHome("1")
+
+	c.set("home1", entry("1"), inFlight)
+	if c.get("home1") != nil {
+		t.Error("response fetched before the write was stored")
+	}
+
+	c.set("home2", entry("2"), other)
+	if c.get("home2") == nil {
+		t.Error("response of another home wasn't stored")
+	}
+
+	c.set("home1", entry("1"), c.generation("1"))
+	if c.get("home1") == nil {
+		t.Error("response fetched after the write wasn't stored")
+	}
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// coalescedRequestTimeout limits a shared upstream request, which isn't bound to any client.
+const coalescedRequestTimeout = time.Minute
+
+// coalesceRequest runs fn once for all identical requests that arrive while it is in flight.
+// fn gets a context that is detached from the request, so a disconnecting client doesn't
+// cancel the upstream request for the others. The returned flag is true for callers
+// that received the response of another request.
+func (h *Handler) coalesceRequest(
+	e *core.RequestEvent,
+	key string,
+	fn func(ctx context.Context) (*proxyResponse, error),
+) (*proxyResponse, bool, error) {
+	leader := false
+	ch := h.inflight.DoChan(key, func() (any, error) {
+		leader = true
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(e.Request.Context()), coalescedRequestTimeout)
+		defer cancel()
+
+		return fn(ctx)
+	})
+
+	select {
+	case result := <-ch:
+		response, _ := result.Val.(*proxyResponse)
+		return response, !leader, result.Err
+	case <-e.Request.Context().Done():
+		return nil, false, e.Request.Context().Err()
+	}
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesceRequestSharesResponse(t *testing.T) {
+	h := &Handler{}
+	release := make(chan struct{})
+	var calls atomic.Int32
+
+	fn := func(ctx context.Context) (*proxyResponse, error) {
+		calls.Add(1)
+		<-release
+		return &proxyResponse{status: http.StatusOK}, nil
+	}
+
+	const n = 5
+	var wg sync.WaitGroup
+	var sharedCount atomic.Int32
+	for range n {
+		wg.Go(func() {
+			e, _ := newTestRequestEvent(http.MethodGet, "/api/v2/homes/1/zoneStates")
+			response, shared, err := h.coalesceRequest(e, "zoneStates", fn)
+			if err != nil {
+				t.Errorf("coalesceRequest() error = %v", err)
+				return
+			}
+			if response.status != http.StatusOK {
+				t.Errorf("coalesceRequest() status = %d, want 200", response.status)
+			}
+			if shared {
+				sharedCount.Add(1)
+			}
+		})
+	}
+
+	// give the callers time to join the request in flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("upstream request ran %d times, want 1", got)
+	}
+	if got := sharedCount.Load(); got != n-1 {
+		t.Errorf("%d callers got a shared response, want %d", got, n-1)
+	}
+}
+
+func TestCoalesceRequestOutlivesDisconnectedClient(t *testing.T) {
+	h := &Handler{}
+	release := make(chan struct{})
+	upstreamErr := make(chan error, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e, _ := newTestRequestEvent(http.MethodGet, "/api/v2/homes/1/zoneStates")
+	e.Request = e.Request.WithContext(ctx)
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := h.coalesceRequest(e, "zoneStates", func(ctx context.Context) (*proxyResponse, error) {
+			<-release
+			upstreamErr <- ctx.Err()
+			return &proxyResponse{status: http.StatusOK}, nil
+		})
+		done <- err
+	}()
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("coalesceRequest() error = %v, want %v", err, context.Canceled)
+	}
+
+	close(release)
+	if err := <-upstreamErr; err != nil {
+		t.Errorf("upstream request context error = %v, want it to outlive the client", err)
+	}
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/imroc/req/v3"
+)
+
+const (
+	// defaultRateLimitCooldown is used for 429 responses without a reset hint.
+	defaultRateLimitCooldown = 5 * time.Minute
+	// defaultServerErrorCooldown is used for 5xx responses without a Retry-After header.
+	defaultServerErrorCooldown = 30 * time.Second
+)
+
+var ratelimitResetRegex = regexp.MustCompile(`;t=(\d+)`)
+
+// shouldFailover reports whether a response with the given method and status should be retried with the next token.
+// tado rejected rate limited requests, but it may have applied a write before answering with a 5xx,
+// so only reads are retried on server errors.
+func (h *Handler) shouldFailover(method string, status int) bool {
+	if status == http.StatusTooManyRequests {
+		return true
+	}
+	if method != http.MethodGet && method != http.MethodHead {
+		return false
+	}
+
+	return status >= http.StatusInternalServerError && h.retryOnServerError.Load()
+}
+
+// upstreamCooldown returns how long a token should not be used after a 429 or 5xx response.
+// It respects the Retry-After header and the reset time of tado's ratelimit header.
+func upstreamCooldown(resp *req.Response) time.Duration {
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil {
+			return time.Until(date)
+		}
+	}
+
+	matches := ratelimitResetRegex.FindStringSubmatch(resp.Header.Get("ratelimit"))
+	if len(matches) == 2 {
+		if seconds, err := strconv.Atoi(matches[1]); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return defaultRateLimitCooldown
+	}
+
+	return defaultServerErrorCooldown
+}
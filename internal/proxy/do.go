@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Response is the response of a request sent with Do.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Do sends a request to the tado API through the proxy, like a client with full access would.
+// It uses the token rotation, the response cache and the request log, so integrations
+// built into the server share the rate limits with all other clients.
+// The path is a proxy path, e.g. /api/v2/homes/123/zoneStates.
+func (h *Handler) Do(ctx context.Context, method, path string, body []byte) (*Response, error) {
+	return h.do(ctx, method, path, body, time.Time{})
+}
+
+func (h *Handler) do(ctx context.Context, method, path string, body []byte, warmUntil time.Time) (*Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > 0 {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	recorder := httptest.NewRecorder()
+
+	e := &core.RequestEvent{App: h.app}
+	e.Request = request
+	e.Response = recorder
+
+	if err := h.performProxyRequest(e, request.URL.Path, nil, warmUntil); err != nil {
+		return nil, err
+	}
+
+	return &Response{
+		Status: recorder.Code,
+		Header: recorder.Header(),
+		Body:   recorder.Body.Bytes(),
+	}, nil
+}
+
+// Quota returns the combined daily limit of all enabled tokens and the requests made since the last reset.
+func (h *Handler) Quota() (limit int, used int, err error) {
+	usages, err := h.getTokensUsage()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, u := range usages {
+		if u.token.GetBool("disabled") {
+			continue
+		}
+
+		limit += u.client.GetInt("dailyLimit")
+		used += u.used
+	}
+
+	return limit, used, nil
+}
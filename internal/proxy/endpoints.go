@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// specPrefix is the server URL of the paths in the OpenAPI spec.
+const specPrefix = "/api/v2"
+
+var idSegmentRegex = regexp.MustCompile(`/\d+`)
+
+// endpointRoute is a path template of the OpenAPI spec, split into its segments.
+type endpointRoute struct {
+	template string
+	segments []string
+}
+
+// endpointMatcher maps upstream paths to the route templates of the bundled OpenAPI spec,
+// e.g. /api/v2/homes/123/zones/1/state to /homes/{homeId}/zones/{zoneId}/state.
+type endpointMatcher struct {
+	// routes by their number of segments
+	routes map[int][]endpointRoute
+}
+
+// newEndpointMatcher reads the path templates of an OpenAPI spec.
+func newEndpointMatcher(spec []byte) (*endpointMatcher, error) {
+	var document struct {
+		Paths map[string]yaml.Node `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(spec, &document); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI spec: %w", err)
+	}
+
+	m := &endpointMatcher{routes: make(map[int][]endpointRoute)}
+	for _, template := range slices.Sorted(maps.Keys(document.Paths)) {
+		segments := splitPath(template)
+		m.routes[len(segments)] = append(m.routes[len(segments)], endpointRoute{
+			template: template,
+			segments: segments,
+		})
+	}
+
+	return m, nil
+}
+
+// match returns the template of the upstream path. Paths the spec doesn't know, e.g. of
+// the hops API, keep their prefix and get their numeric IDs replaced by {id}.
+func (m *endpointMatcher) match(upstreamPath string) string {
+	if path, ok := strings.CutPrefix(upstreamPath, specPrefix); ok {
+		segments := splitPath(path)
+
+		// prefer the route with the most literal segments, e.g. /zones/{zoneId}/state/openWindow
+		// over a parameter in the last segment
+		best, bestLiterals := "", -1
+		for _, route := range m.routes[len(segments)] {
+			if literals, ok := route.matches(segments); ok && literals > bestLiterals {
+				best, bestLiterals = route.template, literals
+			}
+		}
+		if best != "" {
+			return best
+		}
+	}
+
+	return idSegmentRegex.ReplaceAllString(upstreamPath, "/{id}")
+}
+
+// matches reports whether the segments match the route and how many of them are literal.
+func (r endpointRoute) matches(segments []string) (int, bool) {
+	literals := 0
+	for i, segment := range r.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if segments[i] == "" {
+				return 0, false
+			}
+			continue
+		}
+		if segment != segments[i] {
+			return 0, false
+		}
+		literals++
+	}
+	return literals, true
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
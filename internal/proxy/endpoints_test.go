@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/s1adem4n/tado-api-proxy/web"
+)
+
+func TestEndpointMatcher(t *testing.T) {
+	m, err := newEndpointMatcher(web.OpenAPISpec)
+	if err != nil {
+		t.Fatalf("newEndpointMatcher() error = %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/v2/me", "/me"},
+		{"/api/v2/homes/123", "/homes/{homeId}"},
+		{"/api/v2/homes/123/zoneStates", "/homes/{homeId}/zoneStates"},
+		{"/api/v2/homes/123/zones/4/state", "/homes/{homeId}/zones/{zoneId}/state"},
+		// a literal segment wins over a parameter
+		{"/api/v2/homes/123/zones/4/state/openWindow", "/homes/{homeId}/zones/{zoneId}/state/openWindow"},
+		{"/api/v2/homes/123/zones/4/state/openWindow/activate", "/homes/{homeId}/zones/{zoneId}/state/openWindow/activate"},
+		// paths the spec doesn't know get their IDs replaced
+		{"/api/hops/homes/123/rooms", "/api/hops/homes/{id}/rooms"},
+		{"/api/v2/homes/123/unknown/4", "/api/v2/homes/{id}/unknown/{id}"},
+		// an empty segment doesn't match a parameter
+		{"/api/v2/homes//zones", "/api/v2/homes//zones"},
+	}
+
+	for _, tt := range tests {
+		if got := m.match(tt.path); got != tt.want {
+			t.Errorf("match(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestEndpointMatcherInvalidSpec(t *testing.T) {
+	if _, err := newEndpointMatcher([]byte("paths: [")); err == nil {
+		t.Error("newEndpointMatcher() with an invalid spec succeeded")
+	}
+}
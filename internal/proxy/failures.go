@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/pocketbase/pocketbase/tools/router"
+)
+
+// errorClass is the short reason of a failed request in the request log.
+type errorClass string
+
+const (
+	// errorClassRateLimited is a 429 response from tado.
+	errorClassRateLimited errorClass = "rateLimited"
+	// errorClassServerError is a 5xx response from tado.
+	errorClassServerError errorClass = "serverError"
+	// errorClassClientError is another 4xx response from tado.
+	errorClassClientError errorClass = "clientError"
+	// errorClassUnauthorized means that tado rejected the tokens or they couldn't be refreshed.
+	errorClassUnauthorized errorClass = "unauthorized"
+	// errorClassNetwork means that the request didn't reach tado or got no response.
+	errorClassNetwork errorClass = "network"
+	// errorClassNoToken means that no token was usable, e.g. because all used up their limit.
+	errorClassNoToken errorClass = "noToken"
+)
+
+// classifyStatus returns the error class of an upstream response, or an empty class if it succeeded.
+func classifyStatus(status int) errorClass {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return errorClassRateLimited
+	case status >= http.StatusInternalServerError:
+		return errorClassServerError
+	case status >= http.StatusBadRequest:
+		return errorClassClientError
+	default:
+		return ""
+	}
+}
+
+// forwardError is returned by forwardRequest if no token got a response from tado,
+// so the failure can be logged with the tokens it tried.
+type forwardError struct {
+	apiErr      *router.ApiError
+	class       errorClass
+	url         url.URL
+	tokensTried int
+	attempts    int
+}
+
+func (e *forwardError) Error() string {
+	return e.apiErr.Error()
+}
+
+func (e *forwardError) Unwrap() error {
+	return e.apiErr
+}
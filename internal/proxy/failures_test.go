@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestClassifyStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		want   errorClass
+	}{
+		{http.StatusOK, ""},
+		{http.StatusNoContent, ""},
+		{http.StatusNotModified, ""},
+		{http.StatusNotFound, errorClassClientError},
+		{http.StatusUnprocessableEntity, errorClassClientError},
+		{http.StatusTooManyRequests, errorClassRateLimited},
+		{http.StatusInternalServerError, errorClassServerError},
+		{http.StatusServiceUnavailable, errorClassServerError},
+	}
+
+	for _, tt := range tests {
+		if got := classifyStatus(tt.status); got != tt.want {
+			t.Errorf("classifyStatus(%d) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+// flushRequestLog writes the queued request log entries and returns the logged requests.
+func (p *testProxy) flushRequestLog(t *testing.T) []*core.Record {
+	t.Helper()
+
+	var batch []requestLog
+	for len(p.handler.requestLog.queue) > 0 {
+		batch = append(batch, <-p.handler.requestLog.queue)
+	}
+	p.handler.requestLog.write(batch)
+
+	records, err := p.app.FindRecordsByFilter("requests", "", "created", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestRequestLogRecordsUpstreamDetails(t *testing.T) {
+	p := newTestProxy(t)
+	// the first token is rate limited, so the request fails over to the second one
+	p.server.FailNext(http.MethodGet, "^"+zonesPath+"$", http.StatusTooManyRequests, 1, nil)
+
+	response, err := p.handler.Do(context.Background(), http.MethodGet, zonesPath, nil)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if response.Status != http.StatusOK {
+		t.Fatalf("Do() status = %d, want 200", response.Status)
+	}
+
+	// the rate limited attempt reached tado, so it is logged for its own token
+	records := p.flushRequestLog(t)
+	if len(records) != 2 {
+		t.Fatalf("%d requests logged, want 2", len(records))
+	}
+	byAttempt := map[int]*core.Record{}
+	for _, r := range records {
+		byAttempt[r.GetInt("attempt")] = r
+	}
+	failover, r := byAttempt[1], byAttempt[2]
+	if failover == nil || r == nil {
+		t.Fatalf("logged requests %v, want the attempts 1 and 2", records)
+	}
+	if failover.GetInt("status") != http.StatusTooManyRequests || failover.GetString("errorClass") != string(errorClassRateLimited) {
+		t.Errorf("first attempt status = %d, errorClass = %q, want a rate limited 429",
+			failover.GetInt("status"), failover.GetString("errorClass"))
+	}
+	if failover.GetString("token") == "" || failover.GetString("token") == r.GetString("token") {
+		t.Errorf("first attempt token = %q, want the rate limited token, not %q",
+			failover.GetString("token"), r.GetString("token"))
+	}
+
+	serverURL, err := url.Parse(p.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.GetString("host"); got != serverURL.Host {
+		t.Errorf("host = %q, want %q", got, serverURL.Host)
+	}
+	if got := r.GetInt("bytes"); got != len(response.Body) {
+		t.Errorf("bytes = %d, want %d", got, len(response.Body))
+	}
+	if r.GetInt("attempt") != 2 || r.GetInt("tokensTried") != 2 {
+		t.Errorf("attempt = %d, tokensTried = %d, want the second attempt with the second token",
+			r.GetInt("attempt"), r.GetInt("tokensTried"))
+	}
+	if r.GetString("errorClass") != "" {
+		t.Errorf("errorClass = %q, want none", r.GetString("errorClass"))
+	}
+	if r.GetInt("duration") < 0 {
+		t.Errorf("duration = %d, want the latency in milliseconds", r.GetInt("duration"))
+	}
+}
+
+func TestRequestLogRecordsErrors(t *testing.T) {
+	p := newTestProxy(t)
+
+	p.server.FailNext(http.MethodGet, "^"+zonesPath+"$", http.StatusInternalServerError, 1, nil)
+	if _, err := p.handler.Do(context.Background(), http.MethodGet, zonesPath, nil); err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+
+	// without usable tokens the request doesn't reach tado
+	for _, token := range p.tokens {
+		token.Set("disabled", true)
+		if err := p.app.Save(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := p.handler.Do(context.Background(), http.MethodGet, zonesPath, nil); err == nil {
+		t.Fatal("Do() without tokens succeeded")
+	}
+
+	records := p.flushRequestLog(t)
+	if len(records) != 2 {
+		t.Fatalf("%d requests logged, want 2", len(records))
+	}
+
+	byClass := map[string]*core.Record{}
+	for _, r := range records {
+		byClass[r.GetString("errorClass")] = r
+	}
+	if byClass[string(errorClassServerError)] == nil {
+		t.Error("the 500 response wasn't logged as serverError")
+	}
+
+	noToken := byClass[string(errorClassNoToken)]
+	if noToken == nil {
+		t.Fatal("the request without tokens wasn't logged as noToken")
+	}
+	if noToken.GetString("token") != "" || noToken.GetInt("attempt") != 0 {
+		t.Errorf("token = %q, attempt = %d, want a request that wasn't sent",
+			noToken.GetString("token"), noToken.GetInt("attempt"))
+	}
+}
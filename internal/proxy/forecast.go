@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"cmp"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+const (
+	// forecastWindow is the time of recent requests the burn rate is computed from.
+	forecastWindow = time.Hour
+	// forecastWarmup is the time after a reset before an early exhaustion is notified, so the
+	// requests of the first minutes don't make the projection jump.
+	forecastWarmup = 15 * time.Minute
+)
+
+// QuotaForecast projects the usage of a quota until the next rate limit reset, assuming the
+// requests continue at the recent burn rate.
+type QuotaForecast struct {
+	Used      int `json:"used"`
+	Limit     int `json:"limit"`
+	Remaining int `json:"remaining"`
+	// BurnRate is the number of requests per hour within the forecast window
+	BurnRate float64 `json:"burnRate"`
+	// Projected is the usage at the reset
+	Projected int `json:"projected"`
+	// ExhaustsAt is when the quota runs out, or nil if it lasts until the reset
+	ExhaustsAt *time.Time `json:"exhaustsAt"`
+	// ExhaustsEarly reports whether the quota runs out before the reset
+	ExhaustsEarly bool `json:"exhaustsEarly"`
+}
+
+// HomeForecast is the forecast of the combined quota of the tokens that can access a home.
+type HomeForecast struct {
+	// ID is the tado ID of the home
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Tokens []string `json:"tokens"`
+	QuotaForecast
+}
+
+// ForecastResponse is the response of /api/ratelimits/forecast.
+type ForecastResponse struct {
+	Reset time.Time `json:"reset"`
+	// Since is the start of the window the burn rates are computed from
+	Since  time.Time                `json:"since"`
+	Tokens map[string]QuotaForecast `json:"tokens"`
+	Homes  []HomeForecast           `json:"homes"`
+	Pool   QuotaForecast            `json:"pool"`
+}
+
+// newQuotaForecast projects the usage at the reset. A limit of 0 is treated as unlimited.
+func newQuotaForecast(used, limit int, burnRate float64, now, reset time.Time) QuotaForecast {
+	f := QuotaForecast{
+		Used:      used,
+		Limit:     limit,
+		Remaining: limit - used,
+		BurnRate:  burnRate,
+		Projected: used + int(math.Round(burnRate*reset.Sub(now).Hours())),
+	}
+	if limit <= 0 {
+		return f
+	}
+
+	exhaustsAt := now
+	if f.Remaining > 0 {
+		if burnRate <= 0 {
+			return f
+		}
+		exhaustsAt = now.Add(time.Duration(float64(f.Remaining) / burnRate * float64(time.Hour)))
+	}
+
+	if exhaustsAt.Before(reset) {
+		f.ExhaustsAt = &exhaustsAt
+		f.ExhaustsEarly = true
+	}
+	return f
+}
+
+// forecast projects the quotas of the tokens, of the homes their accounts can access and of the
+// whole pool until the next reset. Disabled tokens are forecast, but don't count towards the
+// homes and the pool.
+func (h *Handler) forecast(usages []tokenUsage, now time.Time) (ForecastResponse, error) {
+	cutoff, err := tokens.GetRatelimitCutoff()
+	if err != nil {
+		return ForecastResponse{}, err
+	}
+
+	since := now.Add(-forecastWindow)
+	if cutoff.After(since) {
+		since = cutoff
+	}
+
+	response := ForecastResponse{
+		Reset:  cutoff.Add(24 * time.Hour),
+		Since:  since,
+		Tokens: make(map[string]QuotaForecast, len(usages)),
+		Homes:  []HomeForecast{},
+	}
+
+	var rows []struct {
+		Token string `db:"token"`
+		Count int    `db:"count"`
+	}
+	err = h.app.DB().NewQuery(
+		"SELECT token, count(*) AS count FROM requests WHERE created >= {:since} AND coalesced = false AND token != '' GROUP BY token",
+	).Bind(dbx.Params{
+		"since": formatBucket(response.Since),
+	}).All(&rows)
+	if err != nil {
+		return response, err
+	}
+
+	// right after the reset the window counts as at least a minute, so a single request doesn't make up a huge rate
+	hours := max(now.Sub(response.Since), time.Minute).Hours()
+	burnRates := make(map[string]float64, len(rows))
+	for _, row := range rows {
+		burnRates[row.Token] = float64(row.Count) / hours
+	}
+
+	accounts, err := h.app.FindAllRecords("accounts")
+	if err != nil {
+		return response, err
+	}
+	accountHomes := make(map[string][]string, len(accounts))
+	for _, account := range accounts {
+		accountHomes[account.Id] = account.GetStringSlice("homes")
+	}
+
+	homes, err := h.app.FindAllRecords("homes")
+	if err != nil {
+		return response, err
+	}
+	homesByID := make(map[string]*core.Record, len(homes))
+	for _, home := range homes {
+		homesByID[home.Id] = home
+	}
+
+	type total struct {
+		used, limit int
+		burnRate    float64
+		tokens      []string
+	}
+	var pool total
+	homeTotals := map[string]*total{}
+
+	for _, u := range usages {
+		limit := u.client.GetInt("dailyLimit")
+		burnRate := burnRates[u.token.Id]
+		response.Tokens[u.token.Id] = newQuotaForecast(u.used, limit, burnRate, now, response.Reset)
+
+		if u.token.GetBool("disabled") {
+			continue
+		}
+
+		pool.used += u.used
+		pool.limit += limit
+		pool.burnRate += burnRate
+
+		for _, homeID := range accountHomes[u.token.GetString("account")] {
+			t, ok := homeTotals[homeID]
+			if !ok {
+				t = &total{}
+				homeTotals[homeID] = t
+			}
+			t.used += u.used
+			t.limit += limit
+			t.burnRate += burnRate
+			t.tokens = append(t.tokens, u.token.Id)
+		}
+	}
+
+	response.Pool = newQuotaForecast(pool.used, pool.limit, pool.burnRate, now, response.Reset)
+
+	for homeID, t := range homeTotals {
+		home, ok := homesByID[homeID]
+		if !ok {
+			continue
+		}
+
+		response.Homes = append(response.Homes, HomeForecast{
+			ID:            home.GetString("tadoID"),
+			Name:          home.GetString("name"),
+			Tokens:        t.tokens,
+			QuotaForecast: newQuotaForecast(t.used, t.limit, t.burnRate, now, response.Reset),
+		})
+	}
+	slices.SortFunc(response.Homes, func(a, b HomeForecast) int {
+		return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.ID, b.ID))
+	})
+
+	return response, nil
+}
+
+// HandleForecastRequest returns the projected usage of the tokens, homes and the whole pool at the next reset.
+func (h *Handler) HandleForecastRequest(e *core.RequestEvent) error {
+	usages, err := h.getTokensUsage()
+	if err != nil {
+		return err
+	}
+
+	response, err := h.forecast(usages, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return e.JSON(http.StatusOK, response)
+}
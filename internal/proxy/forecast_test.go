@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewQuotaForecast(t *testing.T) {
+	now := time.Date(2026, 1, 1, 18, 0, 0, 0, time.UTC)
+	reset := now.Add(10 * time.Hour)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name          string
+		used, limit   int
+		burnRate      float64
+		wantProjected int
+		wantExhausts  *time.Time
+	}{
+		{
+			name: "lasts until the reset",
+			used: 100, limit: 1000, burnRate: 50,
+			wantProjected: 600,
+		},
+		{
+			name: "runs out before the reset",
+			used: 400, limit: 1000, burnRate: 100,
+			wantProjected: 1400,
+			wantExhausts:  at(6 * time.Hour),
+		},
+		{
+			name: "runs out exactly at the reset",
+			used: 0, limit: 1000, burnRate: 100,
+			wantProjected: 1000,
+		},
+		{
+			name: "no requests",
+			used: 10, limit: 1000, burnRate: 0,
+			wantProjected: 10,
+		},
+		{
+			name: "already used up",
+			used: 1000, limit: 1000, burnRate: 0,
+			wantProjected: 1000,
+			wantExhausts:  at(0),
+		},
+		{
+			name: "unlimited",
+			used: 5000, limit: 0, burnRate: 100,
+			wantProjected: 6000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newQuotaForecast(tt.used, tt.limit, tt.burnRate, now, reset)
+
+			if f.Remaining != tt.limit-tt.used {
+				t.Errorf("Remaining = %d, want %d", f.Remaining, tt.limit-tt.used)
+			}
+			if f.Projected != tt.wantProjected {
+				t.Errorf("Projected = %d, want %d", f.Projected, tt.wantProjected)
+			}
+			if f.ExhaustsEarly != (tt.wantExhausts != nil) {
+				t.Errorf("ExhaustsEarly = %v, want %v", f.ExhaustsEarly, tt.wantExhausts != nil)
+			}
+			switch {
+			case tt.wantExhausts == nil && f.ExhaustsAt != nil:
+				t.Errorf("ExhaustsAt = %v, want nil", *f.ExhaustsAt)
+			case tt.wantExhausts != nil && (f.ExhaustsAt == nil || !f.ExhaustsAt.Equal(*tt.wantExhausts)):
+				t.Errorf("ExhaustsAt = %v, want %v", f.ExhaustsAt, *tt.wantExhausts)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/s1adem4n/tado-api-proxy/internal/metrics"
+)
+
+// HandleMetricsRequest serves the Prometheus metrics.
+// If a metrics key is configured, it has to be sent as a bearer token.
+func (h *Handler) HandleMetricsRequest(e *core.RequestEvent) error {
+	settings, err := h.app.FindFirstRecordByFilter("settings", "")
+	if err != nil {
+		return err
+	}
+
+	if key := settings.GetString("metricsKey"); key != "" {
+		authorization := e.Request.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(authorization), []byte("Bearer "+key)) != 1 {
+			return e.UnauthorizedError("Invalid metrics key", nil)
+		}
+	}
+
+	metrics.Handler().ServeHTTP(e.Response, e.Request)
+	return nil
+}
+
+// usageMetrics is the collector of the token usage. The registry takes a single collector
+// for the metrics, so it's registered once and reports the usage of the handler registered last.
+var (
+	usageMetrics         = newUsageCollector()
+	registerUsageMetrics sync.Once
+)
+
+// registerUsageCollector makes the usage collector report the usage of the handler.
+func (h *Handler) registerUsageCollector() {
+	usageMetrics.handler.Store(h)
+	registerUsageMetrics.Do(func() {
+		metrics.Registry.MustRegister(usageMetrics)
+	})
+}
+
+// usageCollector exposes the token usage of /api/ratelimits as gauges.
+type usageCollector struct {
+	handler   atomic.Pointer[Handler]
+	used      *prometheus.Desc
+	limit     *prometheus.Desc
+	remaining *prometheus.Desc
+}
+
+func newUsageCollector() *usageCollector {
+	labels := []string{"token", "client", "account"}
+
+	return &usageCollector{
+		used: prometheus.NewDesc(
+			"tado_proxy_token_used",
+			"Requests made with the token since the last rate limit reset.",
+			labels, nil,
+		),
+		limit: prometheus.NewDesc(
+			"tado_proxy_token_limit",
+			"Daily request limit of the token's client.",
+			labels, nil,
+		),
+		remaining: prometheus.NewDesc(
+			"tado_proxy_token_remaining",
+			"Requests left for the token until the next rate limit reset.",
+			labels, nil,
+		),
+	}
+}
+
+func (c *usageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.used
+	ch <- c.limit
+	ch <- c.remaining
+}
+
+func (c *usageCollector) Collect(ch chan<- prometheus.Metric) {
+	h := c.handler.Load()
+	if h == nil {
+		return
+	}
+
+	usages, err := h.getTokensUsage()
+	if err != nil {
+		h.app.Logger().Error("failed to collect token usage", "error", err)
+		return
+	}
+
+	for _, u := range usages {
+		labels := []string{u.token.Id, u.client.GetString("name"), u.token.GetString("account")}
+		limit := u.client.GetInt("dailyLimit")
+
+		ch <- prometheus.MustNewConstMetric(c.used, prometheus.GaugeValue, float64(u.used), labels...)
+		ch <- prometheus.MustNewConstMetric(c.limit, prometheus.GaugeValue, float64(limit), labels...)
+		ch <- prometheus.MustNewConstMetric(c.remaining, prometheus.GaugeValue, float64(limit-u.used), labels...)
+	}
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/s1adem4n/tado-api-proxy/internal/metrics"
+)
+
+// withMetricsKey sets the key /metrics requires.
+func withMetricsKey(key string) func(t testing.TB, p *testProxy) {
+	return func(t testing.TB, p *testProxy) {
+		settings, err := p.handler.ensureSettings()
+		if err != nil {
+			t.Fatalf("ensureSettings() error = %v", err)
+		}
+		settings.Set("metricsKey", key)
+		if err := p.app.Save(settings); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+}
+
+func TestMetricsExposesUsage(t *testing.T) {
+	scenario := newRouteScenario("without key", "/metrics", false, func(t testing.TB, p *testProxy) {
+		if _, err := p.handler.Do(context.Background(), http.MethodGet, zonesPath, nil); err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+	})
+	scenario.ExpectedStatus = http.StatusOK
+	scenario.ExpectedContent = []string{
+		`tado_proxy_requests_total{account=`,
+		`endpoint="/homes/{homeId}/zones",method="GET",status="200"}`,
+		`tado_proxy_token_limit{`,
+		`tado_proxy_token_remaining{`,
+	}
+	scenario.Test(t)
+}
+
+func TestMetricsRequiresKey(t *testing.T) {
+	missing := newRouteScenario("missing key", "/metrics", false, withMetricsKey("secret"))
+	missing.ExpectedStatus = http.StatusUnauthorized
+	missing.ExpectedContent = []string{`"data":{}`}
+	missing.Test(t)
+
+	wrong := newRouteScenario("wrong key", "/metrics", false, withMetricsKey("secret"))
+	wrong.Headers = map[string]string{"Authorization": "Bearer other"}
+	wrong.ExpectedStatus = http.StatusUnauthorized
+	wrong.ExpectedContent = []string{`"data":{}`}
+	wrong.Test(t)
+
+	valid := newRouteScenario("valid key", "/metrics", false, withMetricsKey("secret"))
+	valid.Headers = map[string]string{"Authorization": "Bearer secret"}
+	valid.ExpectedStatus = http.StatusOK
+	valid.ExpectedContent = []string{`tado_proxy_token_used{`}
+	valid.Test(t)
+}
+
+func TestUsageMetricsFollowLastRegisteredHandler(t *testing.T) {
+	first := newTestProxy(t)
+	first.register(t)
+	second := newTestProxy(t)
+	second.register(t)
+
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	var tokenIDs []string
+	for _, family := range families {
+		if family.GetName() != "tado_proxy_token_used" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "token" {
+					tokenIDs = append(tokenIDs, label.GetValue())
+				}
+			}
+		}
+	}
+
+	if len(tokenIDs) != len(second.tokens) {
+		t.Fatalf("token usage of %v, want the %d tokens of the second handler", tokenIDs, len(second.tokens))
+	}
+	for _, id := range tokenIDs {
+		if _, err := second.app.FindRecordById("tokens", id); err != nil {
+			t.Errorf("token %s isn't one of the second handler", id)
+		}
+	}
+}
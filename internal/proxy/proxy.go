@@ -2,8 +2,8 @@ package proxy
 
 import (
 	"bytes"
-	"crypto/rand"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,60 +11,171 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/imroc/req/v3"
 	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/hook"
+	"github.com/s1adem4n/tado-api-proxy/internal/metrics"
+	"github.com/s1adem4n/tado-api-proxy/internal/notify"
+	"github.com/s1adem4n/tado-api-proxy/internal/secrets"
 	"github.com/s1adem4n/tado-api-proxy/internal/tado"
 	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+	"github.com/s1adem4n/tado-api-proxy/web"
+	"golang.org/x/sync/singleflight"
 )
 
+// authenticatedPathRegex matches proxy paths that are prefixed with an API key.
+var authenticatedPathRegex = regexp.MustCompile(`^/([A-Za-z0-9_-]+)/api/(?:v2|hops)/`)
+
 type Handler struct {
 	app          core.App
 	tokenManager *tokens.Manager
+	notifier     *notify.Notifier
+	cache        *responseCache
+	inflight     singleflight.Group
+	scheduler    *scheduler
+	selector     *tokenSelector
+	usage        *usageCounter
+	// consumerUsage counts the requests of each API key for the budgets
+	consumerUsage *usageCounter
+	requestLog    *requestLogWriter
+	pooled        pooledLimitCache
+	apiKeyPaths   *apiKeyPathCache
+	rollups       *requestRollups
+	endpoints     *endpointMatcher
+	serverErrors  serverErrors
+
+	// retryOnServerError enables token failover for 5xx responses
+	retryOnServerError atomic.Bool
 }
 
-func NewHandler(app core.App, tokenManager *tokens.Manager) *Handler {
-	return &Handler{
-		app:          app,
-		tokenManager: tokenManager,
+func NewHandler(app core.App, tokenManager *tokens.Manager, notifier *notify.Notifier) *Handler {
+	h := &Handler{
+		app:           app,
+		tokenManager:  tokenManager,
+		notifier:      notifier,
+		cache:         newResponseCache(),
+		selector:      newTokenSelector(),
+		usage:         newUsageCounter(app, "token"),
+		consumerUsage: newUsageCounter(app, "apiKey"),
+		apiKeyPaths:   newAPIKeyPathCache(),
+	}
+	h.requestLog = newRequestLogWriter(app, h.countLogged, h.countUnlogged)
+	h.scheduler = newScheduler(h)
+
+	endpoints, err := newEndpointMatcher(web.OpenAPISpec)
+	if err != nil {
+		app.Logger().Error("failed to read endpoint templates", "error", err)
+		endpoints = &endpointMatcher{}
 	}
+	h.endpoints = endpoints
+	h.rollups = newRequestRollups(app, endpoints)
+
+	return h
 }
 
 func (h *Handler) Register() {
+	h.registerUsageCollector()
+
 	h.app.OnServe().BindFunc(func(e *core.ServeEvent) error {
 		settingsRecord, err := h.ensureSettings()
 		if err != nil {
 			return err
 		}
 
+		h.loadSettings(settingsRecord)
+
+		if err := h.reconcileUsage(); err != nil {
+			return err
+		}
+
 		e.Router.Any("/api/v2/{path...}", h.HandleLegacyProxyRequest)
 		e.Router.Any("/api/hops/{path...}", h.HandleLegacyProxyRequest)
-		e.Router.Any(
-			fmt.Sprintf(
-				"/%s/api/v2/{path...}",
-				settingsRecord.GetString("proxyToken"),
-			),
-			h.HandleAuthenticatedProxyRequest,
-		)
-		e.Router.Any(
-			fmt.Sprintf(
-				"/%s/api/hops/{path...}",
-				settingsRecord.GetString("proxyToken"),
-			),
-			h.HandleAuthenticatedProxyRequest,
-		)
+		// Keys are created at runtime and a "/{key}/api/v2" route would conflict with
+		// the dashboard routes, so key prefixed paths are dispatched by a middleware.
+		// It runs after the middlewares bound with the default priority (e.g. gzip).
+		e.Router.Bind(&hook.Handler[*core.RequestEvent]{
+			Func: func(e *core.RequestEvent) error {
+				matches := authenticatedPathRegex.FindStringSubmatch(e.Request.URL.Path)
+				if len(matches) < 2 || matches[1] == "api" || matches[1] == "_" {
+					return e.Next()
+				}
+
+				return h.HandleAuthenticatedProxyRequest(e)
+			},
+			Priority: 1,
+		})
 		e.Router.GET("/api/ratelimits", h.HandleRatelimitsRequest)
+		// the forecast per home contains the home IDs and names
+		e.Router.GET("/api/ratelimits/forecast", h.HandleForecastRequest).Bind(apis.RequireSuperuserAuth())
 		e.Router.GET("/api/stats", h.HandleStatsRequest)
+		e.Router.GET("/metrics", h.HandleMetricsRequest)
+		// the polled endpoints contain the home and zone IDs
+		e.Router.GET("/api/scheduler", h.HandleSchedulerRequest).Bind(apis.RequireSuperuserAuth())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go h.scheduler.run(ctx)
+		go h.requestLog.run(ctx)
+		h.app.OnTerminate().BindFunc(func(e *core.TerminateEvent) error {
+			cancel()
+			h.requestLog.wait()
+			return e.Next()
+		})
 
 		return e.Next()
 	})
 
+	h.app.OnRecordAfterUpdateSuccess("settings").BindFunc(func(e *core.RecordEvent) error {
+		h.loadSettings(e.Record)
+
+		return e.Next()
+	})
+
+	h.app.OnRecordValidate("apiKeys").BindFunc(func(e *core.RecordEvent) error {
+		if err := validateAPIKey(e.Record); err != nil {
+			return err
+		}
+
+		return e.Next()
+	})
+	h.app.OnRecordAfterDeleteSuccess("apiKeys").BindFunc(h.apiKeyPaths.remove)
+
+	h.app.OnRecordAfterCreateSuccess("tokens", "clients").BindFunc(h.invalidatePooledLimit)
+	h.app.OnRecordAfterUpdateSuccess("tokens", "clients").BindFunc(h.invalidatePooledLimit)
+	h.app.OnRecordAfterDeleteSuccess("tokens", "clients").BindFunc(h.invalidatePooledLimit)
+
+	h.app.Cron().MustAdd("purge-response-cache", "*/5 * * * *", func() {
+		h.cache.purgeExpired()
+	})
+
+	h.app.Cron().MustAdd("reconcile-token-usage", "*/5 * * * *", func() {
+		if err := h.reconcileUsage(); err != nil {
+			h.app.Logger().Error("failed to reconcile usage", "error", err)
+		}
+	})
+
+	h.app.Cron().MustAdd("check-quota-thresholds", "* * * * *", func() {
+		if err := h.checkQuotaThresholds(); err != nil {
+			h.app.Logger().Error("failed to check quota thresholds", "error", err)
+		}
+		if err := h.checkQuotaForecast(); err != nil {
+			h.app.Logger().Error("failed to check quota forecast", "error", err)
+		}
+	})
+
+	h.app.Cron().MustAdd("roll-up-requests", "*/5 * * * *", func() {
+		if err := h.rollups.run(); err != nil {
+			h.app.Logger().Error("failed to roll up requests", "error", err)
+		}
+	})
+
 	h.app.Cron().MustAdd("clean-request-logs", "0 * * * *", func() {
 		h.app.Logger().Info("cleaning request logs")
-		err := h.CleanRequestLogs()
-		if err != nil {
+		if _, err := h.CleanRequestLogs(); err != nil {
 			h.app.Logger().Error("failed to clean request logs", "error", err)
 		}
 	})
@@ -82,13 +193,6 @@ func (h *Handler) ensureSettings() (*core.Record, error) {
 	}
 
 	record = core.NewRecord(collection)
-	b := make([]byte, 4)
-	if _, err := rand.Read(b); err != nil {
-		return nil, fmt.Errorf("failed to generate proxy token: %w", err)
-	}
-	token := hex.EncodeToString(b)
-
-	record.Set("proxyToken", token)
 	record.Set("proxyTokenEnabled", false)
 	err = h.app.Save(record)
 	if err != nil {
@@ -98,16 +202,37 @@ func (h *Handler) ensureSettings() (*core.Record, error) {
 	return record, nil
 }
 
+// loadSettings applies the settings record to the handler.
+func (h *Handler) loadSettings(settings *core.Record) {
+	if err := h.cache.loadSettings(settings); err != nil {
+		h.app.Logger().Error("failed to load cache settings", "error", err)
+	}
+
+	h.retryOnServerError.Store(settings.GetBool("retryOnServerError"))
+
+	if err := h.selector.loadSettings(settings); err != nil {
+		h.app.Logger().Error("failed to load token selection settings", "error", err)
+	}
+
+	if err := h.scheduler.loadSettings(settings); err != nil {
+		h.app.Logger().Error("failed to load poll settings", "error", err)
+	}
+
+	h.rollups.loadSettings(settings)
+}
+
 // tokenWithClient pairs a token record with its associated client record.
 type tokenWithClient struct {
 	client *core.Record
 	token  *core.Record
+	// used is the number of requests made with the token since the last rate limit reset
+	used int
 }
 
-// tokenSelection contains categorized tokens and usage stats.
+// tokenSelection contains the usable tokens in the order they should be tried and usage stats.
 type tokenSelection struct {
-	preferred  []tokenWithClient
-	other      []tokenWithClient
+	tokens     []tokenWithClient
+	strategy   selectionStrategy
 	totalUsed  int
 	totalLimit int
 }
@@ -116,86 +241,304 @@ type tokenSelection struct {
 type proxyResult struct {
 	response *req.Response
 	token    tokenWithClient
+	// failover is set if the request should be retried with the next token
+	failover bool
+	duration time.Duration
+	// attempt is the number of requests sent upstream for this proxy request, including this one
+	attempt int
+}
+
+// proxyResponse is a finished upstream response that can be written to one or more clients.
+type proxyResponse struct {
+	status     int
+	header     http.Header
+	body       []byte
+	tokenID    string
+	accountID  string
+	clientName string
+	url        string
+	host       string
+	strategy   selectionStrategy
+	duration   time.Duration
+	attempt    int
+	// tokensTried is the number of tokens tried, including the ones that couldn't be refreshed
+	tokensTried int
+	// failovers are the earlier responses that made the request fail over to the next token.
+	// They reached tado, so they count towards the limits of their tokens.
+	failovers []*proxyResponse
 }
 
 func (h *Handler) HandleLegacyProxyRequest(e *core.RequestEvent) error {
+	apiKey, err := h.requestAPIKey(e)
+	if err != nil {
+		return err
+	}
+
 	// Check if legacy access is disabled
-	record, err := h.app.FindFirstRecordByFilter("settings", "proxyTokenEnabled = true")
-	if err == nil && record != nil {
-		return e.ForbiddenError("Please use the authenticated endpoint for access or disable protected access in the WebUI", nil)
+	if apiKey == nil {
+		record, err := h.app.FindFirstRecordByFilter("settings", "proxyTokenEnabled = true")
+		if err == nil && record != nil {
+			return e.ForbiddenError("Please use an API key for access or disable protected access in the WebUI", nil)
+		}
 	}
 
-	return h.performProxyRequest(e, e.Request.URL.Path)
+	return h.performProxyRequest(e, e.Request.URL.Path, apiKey, time.Time{})
 }
 
 func (h *Handler) HandleAuthenticatedProxyRequest(e *core.RequestEvent) error {
-	// token is first path segment
+	// key is first path segment
 	parts := strings.Split(e.Request.URL.Path, "/")
-	token := parts[1]
+	key := parts[1]
 
-	// Verify token
-	_, err := h.app.FindFirstRecordByFilter("settings", "proxyToken = {:token}", dbx.Params{"token": token})
+	apiKey, err := h.findAPIKey(key)
 	if err != nil {
 		return e.NotFoundError("Not Found", nil)
 	}
 
-	// Strip token from path to get upstream path
-	requestPath := e.Request.URL.Path
-	prefix := "/" + token
-	upstreamPath := strings.TrimPrefix(requestPath, prefix)
+	// Strip key from path to get upstream path
+	upstreamPath := strings.TrimPrefix(e.Request.URL.Path, "/"+key)
 
-	return h.performProxyRequest(e, upstreamPath)
+	return h.performProxyRequest(e, upstreamPath, apiKey, time.Time{})
 }
 
-func (h *Handler) performProxyRequest(e *core.RequestEvent, upstreamPath string) error {
-	tokenRecords, err := h.findTokens(e, upstreamPath)
+// performProxyRequest answers the request from the cache or forwards it upstream.
+// If warmUntil is set, the response is fetched upstream and cached at least until then.
+func (h *Handler) performProxyRequest(e *core.RequestEvent, upstreamPath string, apiKey *core.Record, warmUntil time.Time) error {
+	var apiKeyID string
+	if apiKey != nil {
+		if err := h.checkAPIKeyScope(e, apiKey, upstreamPath); err != nil {
+			return err
+		}
+		apiKeyID = apiKey.Id
+	}
+
+	homeID := extractHomeID(upstreamPath)
+	endpoint := h.endpoints.match(upstreamPath)
+	scope := e.Request.Header.Get("X-Tado-Email")
+	key := cacheKey(upstreamPath, e.Request.URL.RawQuery, scope)
+
+	var cacheTTL time.Duration
+	if e.Request.Method == http.MethodGet {
+		cacheTTL = h.cache.ttl(upstreamPath)
+	}
+	if !warmUntil.IsZero() {
+		cacheTTL = max(cacheTTL, time.Until(warmUntil))
+	}
+	// a write to the home while the request is in flight makes its response stale
+	generation := h.cache.generation(homeID)
+	// polled responses are cached until the next poll, even if the path has no cache rule
+	if e.Request.Method == http.MethodGet && warmUntil.IsZero() {
+		if cached := h.cachedResponse(key, upstreamPath, e.Request.URL.RawQuery, scope, homeID); cached != nil {
+			response, err := h.restrictResponse(apiKey, upstreamPath, cached.response)
+			if err != nil {
+				return err
+			}
+			metrics.CacheHits.WithLabelValues(endpoint).Inc()
+			h.writeCachedResponse(e, response)
+			return nil
+		}
+		if cacheTTL > 0 {
+			e.Response.Header().Set("X-Cache", "MISS")
+		}
+	}
+
+	// Cache hits are free, so the budget is only checked for upstream requests
+	if apiKey != nil {
+		if err := h.checkConsumerBudget(e, apiKey); err != nil {
+			return err
+		}
+	}
+
+	bodyBytes, err := io.ReadAll(e.Request.Body)
 	if err != nil {
 		return err
 	}
-	if len(tokenRecords) == 0 {
-		return e.BadRequestError("no valid tokens found", nil)
+	e.Request.Body.Close()
+
+	entry := requestLog{
+		apiKeyID: apiKeyID,
+		method:   e.Request.Method,
+		endpoint: endpoint,
+	}
+
+	var response *proxyResponse
+	var coalesced bool
+	if e.Request.Method == http.MethodGet {
+		response, coalesced, err = h.coalesceRequest(e, key, func(ctx context.Context) (*proxyResponse, error) {
+			return h.forwardAndLog(ctx, e, upstreamPath, bodyBytes, entry)
+		})
+	} else {
+		response, err = h.forwardAndLog(e.Request.Context(), e, upstreamPath, bodyBytes, entry)
 	}
 
-	selection, err := h.categorizeTokens(tokenRecords)
+	// The shared upstream request was logged by the request that sent it
+	if coalesced {
+		entry.coalesced = true
+		h.logForwardResult(entry, response, err)
+	}
 	if err != nil {
 		return err
 	}
 
-	bodyBytes, err := io.ReadAll(e.Request.Body)
+	written, err := h.restrictResponse(apiKey, upstreamPath, response)
 	if err != nil {
 		return err
 	}
-	e.Request.Body.Close()
+	h.writeProxyResponse(e, written)
+	metrics.Requests.WithLabelValues(
+		e.Request.Method,
+		endpoint,
+		strconv.Itoa(response.status),
+		response.clientName,
+		response.accountID,
+	).Inc()
 
+	if cacheTTL > 0 && response.status >= 200 && response.status < 300 {
+		h.cache.set(key, &cachedResponse{
+			response: response,
+			homeID:   homeID,
+			expires:  time.Now().Add(cacheTTL),
+			warmed:   !warmUntil.IsZero(),
+		}, generation)
+	}
+
+	// Writes change the state of the home, so cached reads are stale now
+	if e.Request.Method != http.MethodGet && e.Request.Method != http.MethodHead && homeID != "" {
+		h.cache.invalidateHome(homeID)
+	}
+
+	return nil
+}
+
+// forwardAndLog forwards the request upstream with the given context and logs the result,
+// so the upstream request is counted even if the client that sent it is gone.
+func (h *Handler) forwardAndLog(ctx context.Context, e *core.RequestEvent, upstreamPath string, bodyBytes []byte, entry requestLog) (*proxyResponse, error) {
+	forward := &core.RequestEvent{App: h.app}
+	forward.Request = e.Request.WithContext(ctx)
+
+	response, err := h.forwardRequest(forward, upstreamPath, bodyBytes)
+	h.logForwardResult(entry, response, err)
+
+	return response, err
+}
+
+// logForwardResult completes the entry with the upstream response or failure and logs it.
+func (h *Handler) logForwardResult(entry requestLog, response *proxyResponse, err error) {
+	// The followers of a coalesced request didn't send the earlier attempts
+	if response != nil && !entry.coalesced {
+		for _, failover := range response.failovers {
+			h.logRequest(newRequestLogEntry(entry, failover))
+		}
+	}
+
+	if err != nil {
+		// Requests that got no response are logged without a token, so they don't count towards its limit
+		var failure *forwardError
+		if errors.As(err, &failure) {
+			entry.url = failure.url.String()
+			entry.host = failure.url.Host
+			entry.status = failure.apiErr.Status
+			entry.attempt = failure.attempts
+			entry.tokensTried = failure.tokensTried
+			entry.errorClass = failure.class
+			h.logRequest(entry)
+		}
+		return
+	}
+
+	h.logRequest(newRequestLogEntry(entry, response))
+}
+
+// newRequestLogEntry completes the entry with an upstream response.
+func newRequestLogEntry(entry requestLog, response *proxyResponse) requestLog {
+	entry.tokenID = response.tokenID
+	entry.url = response.url
+	entry.host = response.host
+	entry.status = response.status
+	entry.strategy = response.strategy
+	entry.duration = response.duration
+	entry.bytes = len(response.body)
+	entry.attempt = response.attempt
+	entry.tokensTried = response.tokensTried
+	entry.errorClass = classifyStatus(response.status)
+	return entry
+}
+
+// forwardRequest sends the request upstream, trying all usable tokens in order.
+func (h *Handler) forwardRequest(e *core.RequestEvent, upstreamPath string, bodyBytes []byte) (*proxyResponse, error) {
 	targetURL := h.buildTargetURL(e.Request.URL, upstreamPath)
 
-	validTokens := append(selection.preferred, selection.other...)
-	for _, t := range validTokens {
+	tokenRecords, err := h.findTokens(e, upstreamPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokenRecords) == 0 {
+		return nil, &forwardError{
+			apiErr: e.BadRequestError("no valid tokens found", nil),
+			class:  errorClassNoToken,
+			url:    targetURL,
+		}
+	}
+
+	selection, err := h.selectTokens(tokenRecords)
+	if err != nil {
+		return nil, err
+	}
+
+	var failovers []*proxyResponse
+	var tokensTried, attempts int
+	class := errorClassNoToken
+
+	for _, t := range selection.tokens {
+		tokensTried++
+
 		// Ensure the token is valid (refresh if needed) before using it
 		validToken, err := h.tokenManager.GetValidToken(e.Request.Context(), t.token)
 		if err != nil {
 			h.app.Logger().Debug("failed to get valid token", "id", t.token.Id, "error", err)
+			class = errorClassUnauthorized
 			continue
 		}
 		t.token = validToken
 
+		attempts++
 		result, err := h.tryProxyRequest(e, t, targetURL, bodyBytes)
 		if err != nil {
+			class = errorClassNetwork
 			continue
 		}
 		if result == nil {
+			class = errorClassUnauthorized
+			continue
+		}
+		result.attempt = attempts
+		if result.failover {
+			failovers = append(failovers, newProxyResponse(result, targetURL, selection, tokensTried))
 			continue
 		}
 
 		h.updateClientRateLimit(t.client, result.response.Header.Get("ratelimit-policy"))
+		h.app.Logger().Debug("selected token", "id", t.token.Id, "client", t.client.GetString("name"), "strategy", selection.strategy)
 
-		h.writeProxyResponse(e, result.response, selection.totalLimit, selection.totalUsed)
-		h.logRequest(t.token.Id, e.Request.Method, targetURL.String(), result.response.StatusCode)
+		response := newProxyResponse(result, targetURL, selection, tokensTried)
+		response.failovers = failovers
+		return response, nil
+	}
 
-		return nil
+	// Every token was rejected upstream, so pass the last error on to the client
+	if len(failovers) > 0 {
+		response := failovers[len(failovers)-1]
+		response.failovers = failovers[:len(failovers)-1]
+		return response, nil
 	}
 
-	return e.UnauthorizedError("no valid tokens found", nil)
+	return nil, &forwardError{
+		apiErr:      e.UnauthorizedError("no valid tokens found", nil),
+		class:       class,
+		url:         targetURL,
+		tokensTried: tokensTried,
+		attempts:    attempts,
+	}
 }
 
 // findTokens retrieves tokens based on request headers and path.
@@ -226,73 +569,78 @@ func (h *Handler) findTokens(e *core.RequestEvent, upstreamPath string) ([]*core
 	)
 }
 
-// categorizeTokens separates tokens into preferred (deviceCode) and other types,
-// filtering out those that have exceeded their rate limit.
-func (h *Handler) categorizeTokens(tokenRecords []*core.Record) (*tokenSelection, error) {
-	cutoff, err := tokens.GetRatelimitCutoff()
+// selectTokens filters out the tokens that exceeded their rate limit or are in a cooldown
+// and orders the others by the selection strategy.
+func (h *Handler) selectTokens(tokenRecords []*core.Record) (*tokenSelection, error) {
+	clients, err := h.findClients()
 	if err != nil {
 		return nil, err
 	}
 
 	selection := &tokenSelection{}
+	now := time.Now()
+
+	var usable []tokenWithClient
 
 	for _, token := range tokenRecords {
-		client, err := h.app.FindRecordById("clients", token.GetString("client"))
-		if err != nil {
-			return nil, err
+		client, ok := clients[token.GetString("client")]
+		if !ok {
+			return nil, fmt.Errorf("client %s of token %s not found", token.GetString("client"), token.Id)
 		}
 
 		selection.totalLimit += client.GetInt("dailyLimit")
-		count, err := h.getTokenUsageCount(token.Id, cutoff)
-		if err != nil {
-			return nil, err
-		}
+		count := h.usage.get(token.Id)
 
 		selection.totalUsed += count
 		if count >= client.GetInt("dailyLimit") {
 			continue
 		}
 
-		tc := tokenWithClient{client: client, token: token}
-		if client.GetString("type") == "deviceCode" {
-			selection.preferred = append(selection.preferred, tc)
-		} else {
-			selection.other = append(selection.other, tc)
+		// Skip tokens that were rate limited or failed upstream recently
+		if token.GetDateTime("cooldownUntil").Time().After(now) {
+			continue
 		}
+
+		usable = append(usable, tokenWithClient{client: client, token: token, used: count})
 	}
+
+	selection.tokens, selection.strategy = h.selector.order(usable)
 	return selection, nil
 }
 
-// getTokenUsageCount returns the number of requests made with a token since the cutoff time.
-func (h *Handler) getTokenUsageCount(tokenID string, cutoff time.Time) (int, error) {
-	var count int
-
-	err := h.app.DB().NewQuery(
-		"SELECT count(*) FROM requests WHERE token = {:tokenID} AND created > {:cutoff}",
-	).Bind(dbx.Params{
-		"tokenID": tokenID,
-		"cutoff":  cutoff,
-	}).Row(&count)
+// findClients returns all clients by ID.
+func (h *Handler) findClients() (map[string]*core.Record, error) {
+	clientRecords, err := h.app.FindAllRecords("clients")
+	if err != nil {
+		return nil, err
+	}
 
-	return count, err
+	clients := make(map[string]*core.Record, len(clientRecords))
+	for _, client := range clientRecords {
+		clients[client.Id] = client
+	}
+	return clients, nil
 }
 
 // buildTargetURL constructs the target URL for the Tado API.
 func (h *Handler) buildTargetURL(requestURL *url.URL, upstreamPath string) url.URL {
-	host := "my.tado.com"
+	baseURL := tado.APIURL
 	targetPath := upstreamPath
 	if strings.HasPrefix(upstreamPath, "/api/hops") {
-		host = "hops.tado.com"
+		baseURL = tado.HopsURL
 		targetPath = strings.TrimPrefix(upstreamPath, "/api/hops")
 		if targetPath == "" {
 			targetPath = "/"
 		}
 	}
 
+	// the base URLs are checked at startup
+	base, _ := url.Parse(baseURL)
+
 	targetURL := url.URL{
-		Scheme:   "https",
-		Host:     host,
-		Path:     targetPath,
+		Scheme:   base.Scheme,
+		Host:     base.Host,
+		Path:     strings.TrimSuffix(base.Path, "/") + targetPath,
 		RawQuery: requestURL.RawQuery,
 	}
 
@@ -309,13 +657,19 @@ func (h *Handler) buildTargetURL(requestURL *url.URL, upstreamPath string) url.U
 // tryProxyRequest attempts to proxy the request using the given token.
 // Returns nil result if the token is invalid and should be skipped.
 func (h *Handler) tryProxyRequest(e *core.RequestEvent, t tokenWithClient, targetURL url.URL, bodyBytes []byte) (*proxyResult, error) {
+	accessToken, err := secrets.Get(t.token, "accessToken")
+	if err != nil {
+		h.app.Logger().Error("failed to decrypt access token", "id", t.token.Id, "error", err)
+		return nil, err
+	}
+
 	apiClient := h.createAPIClient(t.client)
 	request := apiClient.R().
 		SetContext(e.Request.Context()).
-		SetHeader("authorization", "Bearer "+t.token.GetString("accessToken"))
+		SetHeader("authorization", "Bearer "+accessToken)
 
 	for k, v := range e.Request.Header {
-		if k == "Authorization" || k == "X-Tado-Email" || k == "Host" || k == "Accept-Encoding" {
+		if k == "Authorization" || k == "X-Api-Key" || k == "X-Tado-Email" || k == "Host" || k == "Accept-Encoding" {
 			continue
 		}
 		for _, vv := range v {
@@ -327,11 +681,20 @@ func (h *Handler) tryProxyRequest(e *core.RequestEvent, t tokenWithClient, targe
 		request.SetBody(bytes.NewReader(bodyBytes))
 	}
 
+	start := time.Now()
 	resp, err := request.Send(e.Request.Method, targetURL.String())
 	if err != nil {
 		h.app.Logger().Error("proxy request failed", "error", err)
 		return nil, err
 	}
+	duration := time.Since(start)
+	metrics.UpstreamDuration.
+		WithLabelValues(targetURL.Host, t.client.GetString("name")).
+		Observe(duration.Seconds())
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		h.recordServerError(targetURL.Host, resp.StatusCode)
+	}
 
 	if resp.StatusCode == http.StatusUnauthorized {
 		// Use token manager to mark as invalid (thread-safe)
@@ -341,12 +704,17 @@ func (h *Handler) tryProxyRequest(e *core.RequestEvent, t tokenWithClient, targe
 		return nil, nil
 	}
 
-	// Update token usage
-	if err := h.tokenManager.UpdateTokenUsed(t.token.Id); err != nil {
-		h.app.Logger().Error("failed to update token used time", "error", err)
+	if h.shouldFailover(e.Request.Method, resp.StatusCode) {
+		cooldown := upstreamCooldown(resp)
+		h.app.Logger().Warn("upstream rejected request, trying next token", "id", t.token.Id, "status", resp.StatusCode, "cooldown", cooldown)
+
+		if err := h.tokenManager.SetTokenCooldown(t.token.Id, time.Now().Add(cooldown)); err != nil {
+			h.app.Logger().Error("failed to set token cooldown", "error", err)
+		}
+		return &proxyResult{response: resp, token: t, failover: true, duration: duration}, nil
 	}
 
-	return &proxyResult{response: resp, token: t}, nil
+	return &proxyResult{response: resp, token: t, duration: duration}, nil
 }
 
 // createAPIClient creates the appropriate API client based on the client platform.
@@ -387,125 +755,194 @@ func (h *Handler) updateClientRateLimit(client *core.Record, policyHeader string
 	}
 }
 
-// writeProxyResponse writes the proxy response to the client.
-func (h *Handler) writeProxyResponse(e *core.RequestEvent, resp *req.Response, totalLimit, totalUsed int) {
-	for k, v := range resp.Header {
-		for _, vv := range v {
-			e.Response.Header().Add(k, vv)
-		}
-	}
+// newProxyResponse builds the response for the client from the upstream response,
+// replacing the rate limit headers with the combined limit of all tokens.
+func newProxyResponse(result *proxyResult, targetURL url.URL, selection *tokenSelection, tokensTried int) *proxyResponse {
+	header := result.response.Header.Clone()
 
-	rateLimitPolicy := fmt.Sprintf(`"perday";q=%d;w=86400`, totalLimit)
-	rateLimit := fmt.Sprintf(`"perday";r=%d`, totalLimit-totalUsed-1)
+	rateLimitPolicy := fmt.Sprintf(`"perday";q=%d;w=86400`, selection.totalLimit)
+	rateLimit := fmt.Sprintf(`"perday";r=%d`, selection.totalLimit-selection.totalUsed-1)
 
-	e.Response.Header().Set("Ratelimit-Policy", rateLimitPolicy)
-	e.Response.Header().Set("Ratelimit", fmt.Sprintf(`"perday";r=%d`, totalLimit-totalUsed-1))
+	header.Set("Ratelimit-Policy", rateLimitPolicy)
+	header.Set("Ratelimit", rateLimit)
 
 	// compatibilty for tado_hijack
-	e.Response.Header()["RateLimit-Policy"] = []string{rateLimitPolicy}
-	e.Response.Header()["RateLimit"] = []string{rateLimit}
+	header["RateLimit-Policy"] = []string{rateLimitPolicy}
+	header["RateLimit"] = []string{rateLimit}
+
+	return &proxyResponse{
+		status:      result.response.StatusCode,
+		header:      header,
+		body:        result.response.Bytes(),
+		tokenID:     result.token.token.Id,
+		accountID:   result.token.token.GetString("account"),
+		clientName:  result.token.client.GetString("name"),
+		url:         targetURL.String(),
+		host:        targetURL.Host,
+		strategy:    selection.strategy,
+		duration:    result.duration,
+		attempt:     result.attempt,
+		tokensTried: tokensTried,
+	}
+}
+
+// writeProxyResponse writes the proxy response to the client.
+func (h *Handler) writeProxyResponse(e *core.RequestEvent, response *proxyResponse) {
+	// Copy without canonicalizing keys to keep the tado_hijack compatibility headers
+	for k, v := range response.header {
+		e.Response.Header()[k] = append(e.Response.Header()[k], v...)
+	}
 
-	e.Response.WriteHeader(resp.StatusCode)
-	if _, err := e.Response.Write(resp.Bytes()); err != nil {
+	e.Response.WriteHeader(response.status)
+	if _, err := e.Response.Write(response.body); err != nil {
 		h.app.Logger().Error("failed to write response", "error", err)
 	}
 }
 
-// logRequest creates a request log entry in the database.
-func (h *Handler) logRequest(tokenID, method, url string, status int) {
-	requestsCollection, err := h.app.FindCollectionByNameOrId("requests")
-	if err != nil {
-		h.app.Logger().Error("failed to find requests collection", "error", err)
+// requestLog describes a proxied request for the requests collection.
+type requestLog struct {
+	tokenID   string
+	apiKeyID  string
+	method    string
+	url       string
+	endpoint  string
+	status    int
+	coalesced bool
+	strategy  selectionStrategy
+	time      time.Time
+	// duration is the latency of the upstream request that answered
+	duration    time.Duration
+	bytes       int
+	attempt     int
+	tokensTried int
+	host        string
+	errorClass  errorClass
+}
+
+// logRequest counts the request for its token and API key and queues its entry for the requests collection.
+// The last use of the token is updated together with the entry.
+func (h *Handler) logRequest(entry requestLog) {
+	entry.time = time.Now()
+
+	counted := !entry.coalesced && entry.tokenID != ""
+	if counted {
+		h.usage.add(entry.tokenID)
+		if entry.apiKeyID != "" {
+			h.consumerUsage.add(entry.apiKeyID)
+		}
+	}
+
+	h.requestLog.enqueue(entry)
+}
+
+// countLogged remembers a counted request whose entry the request log writer wrote,
+// so the reconciliation finds it in the table.
+func (h *Handler) countLogged(entry requestLog) {
+	if entry.coalesced || entry.tokenID == "" {
 		return
 	}
 
-	requestRecord := core.NewRecord(requestsCollection)
-	requestRecord.Set("token", tokenID)
-	requestRecord.Set("method", method)
-	requestRecord.Set("url", url)
-	requestRecord.Set("status", status)
+	h.usage.logged(entry.tokenID, entry.time)
+	if entry.apiKeyID != "" {
+		h.consumerUsage.logged(entry.apiKeyID, entry.time)
+	}
+}
 
-	if err := h.app.Save(requestRecord); err != nil {
-		h.app.Logger().Error("failed to log request", "error", err)
+// countUnlogged remembers a counted request whose entry the request log writer dropped.
+// It is missing in the table, so the reconciliation has to add it back.
+func (h *Handler) countUnlogged(entry requestLog) {
+	if entry.coalesced || entry.tokenID == "" {
+		return
+	}
+
+	h.usage.addUnlogged(entry.tokenID, entry.time)
+	if entry.apiKeyID != "" {
+		h.consumerUsage.addUnlogged(entry.apiKeyID, entry.time)
 	}
 }
 
-func (h *Handler) HandleRatelimitsRequest(e *core.RequestEvent) error {
+// reconcileUsage reconciles the token and API key usage with the requests table
+// while no log entries are written.
+func (h *Handler) reconcileUsage() error {
+	return h.requestLog.paused(func() error {
+		if err := h.usage.reconcile(); err != nil {
+			return fmt.Errorf("token usage: %w", err)
+		}
+		if err := h.consumerUsage.reconcile(); err != nil {
+			return fmt.Errorf("API key usage: %w", err)
+		}
+		return nil
+	})
+}
+
+// tokenUsage contains the rate limit usage of a token since the last reset.
+type tokenUsage struct {
+	token  *core.Record
+	client *core.Record
+	used   int
+}
+
+// getTokensUsage returns the rate limit usage of all tokens.
+func (h *Handler) getTokensUsage() ([]tokenUsage, error) {
 	tokenRecords, err := h.app.FindRecordsByFilter(
 		"tokens",
 		"", "used", 0, 0,
 		nil,
 	)
 	if err != nil {
-		return err
-	}
-	if len(tokenRecords) == 0 {
-		return e.JSON(200, nil)
+		return nil, err
 	}
 
-	cutoff, err := tokens.GetRatelimitCutoff()
+	clients, err := h.findClients()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	usage := map[string]any{}
-
+	usages := make([]tokenUsage, 0, len(tokenRecords))
 	for _, token := range tokenRecords {
-		client, err := h.app.FindRecordById("clients", token.GetString("client"))
-		if err != nil {
-			return err
+		client, ok := clients[token.GetString("client")]
+		if !ok {
+			return nil, fmt.Errorf("client %s of token %s not found", token.GetString("client"), token.Id)
 		}
-		var count int
 
-		err = h.app.DB().NewQuery(
-			"SELECT count(*) FROM requests WHERE token = {:tokenID} AND created > {:cutoff}",
-		).Bind(dbx.Params{
-			"tokenID": token.Id,
-			"cutoff":  cutoff,
-		}).Row(&count)
-		if err != nil {
-			return err
-		}
-
-		usage[token.Id] = map[string]any{
-			"used":      count,
-			"limit":     client.GetInt("dailyLimit"),
-			"remaining": client.GetInt("dailyLimit") - count,
-			"status":    token.GetString("status"),
-		}
+		usages = append(usages, tokenUsage{token: token, client: client, used: h.usage.get(token.Id)})
 	}
-	return e.JSON(200, usage)
-}
 
-func (h *Handler) CleanRequestLogs() error {
-	cutoff := time.Now().Add(-7 * 24 * time.Hour)
+	return usages, nil
+}
 
-	records, err := h.app.FindRecordsByFilter(
-		"requests",
-		"created < {:cutoff}",
-		"", 0, 0,
-		dbx.Params{
-			"cutoff": cutoff,
-		},
-	)
+func (h *Handler) HandleRatelimitsRequest(e *core.RequestEvent) error {
+	usages, err := h.getTokensUsage()
 	if err != nil {
 		return err
 	}
+	if len(usages) == 0 {
+		return e.JSON(200, nil)
+	}
 
-	err = h.app.RunInTransaction(func(txApp core.App) error {
-		for _, r := range records {
-			if err := txApp.Delete(r); err != nil {
-				return err
-			}
-		}
-
-		return nil
-	})
+	forecast, err := h.forecast(usages, time.Now())
 	if err != nil {
 		return err
 	}
 
-	return nil
+	usage := map[string]any{}
+
+	for _, u := range usages {
+		tokenForecast := forecast.Tokens[u.token.Id]
+		usage[u.token.Id] = map[string]any{
+			"used":          u.used,
+			"limit":         u.client.GetInt("dailyLimit"),
+			"remaining":     u.client.GetInt("dailyLimit") - u.used,
+			"status":        u.token.GetString("status"),
+			"cooldownUntil": u.token.GetDateTime("cooldownUntil"),
+			"burnRate":      tokenForecast.BurnRate,
+			"projected":     tokenForecast.Projected,
+			"exhaustsAt":    tokenForecast.ExhaustsAt,
+			"exhaustsEarly": tokenForecast.ExhaustsEarly,
+			"reset":         forecast.Reset,
+		}
+	}
+	return e.JSON(200, usage)
 }
 
 func extractHomeID(path string) string {
@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/fake"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/faketest"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+const (
+	testEmail    = "test@example.com"
+	testPassword = "password"
+	testHomeID   = 1
+	zonesPath    = "/api/v2/homes/1/zones"
+)
+
+// testProxy is a handler with a token for each of the web and mobile clients of an account
+// of the fake server.
+type testProxy struct {
+	app     *tests.TestApp
+	server  *fake.Server
+	handler *Handler
+	// tokens by client ID
+	tokens map[string]*core.Record
+}
+
+func newTestProxy(t testing.TB) *testProxy {
+	t.Helper()
+
+	server := faketest.NewServer(t, fake.Account{
+		Email:    testEmail,
+		Password: testPassword,
+		Homes: []fake.Home{
+			{ID: testHomeID, Name: "Home", Zones: []fake.Zone{{ID: 1, Name: "Living Room"}}},
+			{ID: 2, Name: "Other"},
+		},
+	})
+
+	app := faketest.NewApp(t)
+	auth := tado.NewAuth()
+
+	home := faketest.NewRecord(t, app, "homes", map[string]any{"tadoID": "1", "name": "Home"})
+	account := faketest.NewRecord(t, app, "accounts", map[string]any{
+		"email":    testEmail,
+		"password": testPassword,
+		"homes":    []string{home.Id},
+	})
+
+	p := &testProxy{
+		app:     app,
+		server:  server,
+		handler: NewHandler(app, tokens.NewManager(app, auth, nil), nil),
+		tokens:  map[string]*core.Record{},
+	}
+
+	for _, platform := range []string{"web", "mobile"} {
+		client := faketest.NewClient(t, app, platform, server.DailyLimit)
+		p.tokens[client.GetString("clientID")] = faketest.NewToken(t, app, auth, account, client, testPassword, time.Now().Add(time.Hour))
+	}
+
+	return p
+}
+
+// register binds the routes and hooks of the handler, like serving the app.
+func (p *testProxy) register(t testing.TB) {
+	t.Helper()
+
+	p.handler.Register()
+}
+
+// superuserToken returns the auth token of a new superuser.
+func superuserToken(t testing.TB, app core.App) string {
+	t.Helper()
+
+	superuser := faketest.NewRecord(t, app, core.CollectionNameSuperusers, map[string]any{
+		"email":    "admin@example.com",
+		"password": "password123",
+	})
+	token, err := superuser.NewAuthToken()
+	if err != nil {
+		t.Fatalf("NewAuthToken() error = %v", err)
+	}
+	return token
+}
+
+// newRouteScenario returns a GET request to the routes of a new test proxy, which is authenticated
+// as a superuser if asked. setup can add data to the proxy before the request.
+func newRouteScenario(name, url string, superuser bool, setup func(t testing.TB, p *testProxy)) *tests.ApiScenario {
+	scenario := &tests.ApiScenario{
+		Name:                  name,
+		Method:                http.MethodGet,
+		URL:                   url,
+		DisableTestAppCleanup: true,
+	}
+	scenario.TestAppFactory = func(t testing.TB) *tests.TestApp {
+		p := newTestProxy(t)
+		p.register(t)
+		if superuser {
+			scenario.Headers = map[string]string{"Authorization": superuserToken(t, p.app)}
+		}
+		if setup != nil {
+			setup(t, p)
+		}
+		return p.app
+	}
+	return scenario
+}
+
+// upstreamRequests returns the requests the fake server received for the path.
+func (p *testProxy) upstreamRequests(path string) []fake.Request {
+	var requests []fake.Request
+	for _, r := range p.server.Requests() {
+		if r.Path == path {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+func TestDoCountsUpstreamRequests(t *testing.T) {
+	p := newTestProxy(t)
+
+	for range 5 {
+		response, err := p.handler.Do(context.Background(), http.MethodGet, zonesPath, nil)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		if response.Status != http.StatusOK {
+			t.Fatalf("Do() status = %d, want 200", response.Status)
+		}
+	}
+
+	// the proxy counts exactly the requests tado counted for each client
+	total := 0
+	for clientID, token := range p.tokens {
+		if got, want := p.handler.usage.get(token.Id), p.server.Usage(clientID); got != want {
+			t.Errorf("usage of the %s token = %d, want %d like upstream", clientID, got, want)
+		}
+		total += p.handler.usage.get(token.Id)
+	}
+	if total != 5 {
+		t.Errorf("total usage = %d, want 5", total)
+	}
+
+	limit, used, err := p.handler.Quota()
+	if err != nil {
+		t.Fatalf("Quota() error = %v", err)
+	}
+	if limit != 2*p.server.DailyLimit || used != 5 {
+		t.Errorf("Quota() = %d, %d, want %d, 5", limit, used, 2*p.server.DailyLimit)
+	}
+}
+
+func TestDoFailsOverOnRateLimit(t *testing.T) {
+	p := newTestProxy(t)
+	p.server.FailNext(http.MethodGet, `^`+zonesPath+`$`, http.StatusTooManyRequests, 1, http.Header{"Retry-After": {"60"}})
+
+	response, err := p.handler.Do(context.Background(), http.MethodGet, zonesPath, nil)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if response.Status != http.StatusOK {
+		t.Fatalf("Do() status = %d, want 200 from the next token", response.Status)
+	}
+
+	upstream := p.upstreamRequests(zonesPath)
+	if len(upstream) != 2 || upstream[0].Status != http.StatusTooManyRequests || upstream[1].Status != http.StatusOK {
+		t.Fatalf("upstream requests = %+v, want a 429 and a 200", upstream)
+	}
+
+	// the rate limited token cools down, the other one answered. Both requests reached tado,
+	// so each token counts one.
+	coolingDown := 0
+	for clientID, token := range p.tokens {
+		token, err := p.app.FindRecordById("tokens", token.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.GetDateTime("cooldownUntil").Time().After(time.Now()) {
+			coolingDown++
+		}
+		if got := p.handler.usage.get(token.Id); got != 1 {
+			t.Errorf("usage of the %s token = %d, want 1", clientID, got)
+		}
+	}
+	if coolingDown != 1 {
+		t.Errorf("%d tokens are cooling down, want 1", coolingDown)
+	}
+
+	// the next request only uses the token that isn't cooling down
+	if _, err := p.handler.Do(context.Background(), http.MethodGet, zonesPath, nil); err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	upstream = p.upstreamRequests(zonesPath)
+	if len(upstream) != 3 || upstream[2].ClientID != upstream[1].ClientID {
+		t.Errorf("upstream requests = %+v, want the third one from the token that answered before", upstream)
+	}
+}
+
+func TestDoFailsOverOnServerErrorOnlyForReads(t *testing.T) {
+	p := newTestProxy(t)
+	settings, err := p.handler.ensureSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings.Set("retryOnServerError", true)
+	p.handler.loadSettings(settings)
+
+	p.server.FailNext(http.MethodGet, `^`+zonesPath+`$`, http.StatusBadGateway, 1, nil)
+	response, err := p.handler.Do(context.Background(), http.MethodGet, zonesPath, nil)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if response.Status != http.StatusOK {
+		t.Errorf("GET status = %d, want 200 from the next token", response.Status)
+	}
+
+	// tado may have applied the write before it failed, so it isn't sent again with another token
+	overlayPath := "/api/v2/homes/1/zones/1/overlay"
+	p.server.FailNext(http.MethodPut, `^`+overlayPath+`$`, http.StatusGatewayTimeout, 1, nil)
+	body := []byte(`{"setting":{"type":"HEATING","power":"ON","temperature":{"celsius":21}}}`)
+	response, err = p.handler.Do(context.Background(), http.MethodPut, overlayPath, body)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if response.Status != http.StatusGatewayTimeout {
+		t.Errorf("PUT status = %d, want the 504 from tado", response.Status)
+	}
+	if n := len(p.upstreamRequests(overlayPath)); n != 1 {
+		t.Errorf("%d upstream PUT requests, want 1", n)
+	}
+}
+
+func TestDoFailsOverOnRevokedToken(t *testing.T) {
+	p := newTestProxy(t)
+	p.server.FailNext(http.MethodGet, `^`+zonesPath+`$`, http.StatusUnauthorized, 1, nil)
+
+	response, err := p.handler.Do(context.Background(), http.MethodGet, zonesPath, nil)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if response.Status != http.StatusOK {
+		t.Fatalf("Do() status = %d, want 200 from the next token", response.Status)
+	}
+
+	invalid := 0
+	for _, token := range p.tokens {
+		token, err := p.app.FindRecordById("tokens", token.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.GetString("status") == "invalid" {
+			invalid++
+		}
+	}
+	if invalid != 1 {
+		t.Errorf("%d tokens are invalid, want the rejected one", invalid)
+	}
+}
+
+func TestDoAnswersFromCache(t *testing.T) {
+	p := newTestProxy(t)
+	path := "/api/v2/homes/1/zoneStates"
+
+	for range 3 {
+		response, err := p.handler.Do(context.Background(), http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		if response.Status != http.StatusOK {
+			t.Fatalf("Do() status = %d, want 200", response.Status)
+		}
+	}
+
+	if n := len(p.upstreamRequests(path)); n != 1 {
+		t.Errorf("%d upstream requests, want 1 and the others from the cache", n)
+	}
+}
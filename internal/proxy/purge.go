@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/s1adem4n/tado-api-proxy/internal/metrics"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// defaultRequestRetention is how long requests are kept if the settings don't configure it.
+	defaultRequestRetention = 7 * 24 * time.Hour
+	// purgeChunkSize is the maximum number of requests deleted by one statement, so a large
+	// backlog doesn't lock the database for long.
+	purgeChunkSize = 1000
+)
+
+// PurgeReport describes the requests deleted by CleanRequestLogs.
+type PurgeReport struct {
+	// Cutoff is the time before which requests expired
+	Cutoff time.Time
+	// Expired is the number of requests deleted because they were older than the retention
+	Expired int
+	// OverLimit is the number of requests deleted because there were more than the maximum
+	OverLimit int
+}
+
+// Total returns the number of deleted requests.
+func (r PurgeReport) Total() int {
+	return r.Expired + r.OverLimit
+}
+
+// CleanRequestLogs rolls up the requests and deletes the ones older than the retention from the
+// settings. If a maximum row count is configured, the oldest requests above it are deleted too.
+// Requests that weren't rolled up yet are kept, and so are the requests since the last rate limit
+// reset, which the token usage and the API key budgets are counted from. So the table never
+// shrinks below the requests of the current quota day, whatever the maximum row count is.
+func (h *Handler) CleanRequestLogs() (PurgeReport, error) {
+	var report PurgeReport
+
+	settings, err := h.app.FindFirstRecordByFilter("settings", "")
+	if err != nil {
+		return report, err
+	}
+
+	retention := defaultRequestRetention
+	if days := settings.GetInt("requestRetention"); days > 0 {
+		retention = time.Duration(days) * 24 * time.Hour
+	}
+	maxRows := settings.GetInt("requestMaxRows")
+
+	// roll up the requests first, so the deleted ones are part of the history
+	h.rollups.loadSettings(settings)
+	if err := h.rollups.run(); err != nil {
+		return report, err
+	}
+
+	watermark, err := h.rollups.watermark()
+	if err != nil {
+		return report, err
+	}
+
+	ratelimitCutoff, err := tokens.GetRatelimitCutoff()
+	if err != nil {
+		return report, err
+	}
+
+	// nothing the next rollup or the quota of the current day needs may be deleted
+	keepFrom := watermark
+	if ratelimitCutoff.Before(keepFrom) {
+		keepFrom = ratelimitCutoff
+	}
+
+	report.Cutoff = time.Now().Add(-retention)
+	if keepFrom.Before(report.Cutoff) {
+		report.Cutoff = keepFrom
+	}
+
+	report.Expired, err = h.purgeRequests(report.Cutoff, -1)
+	if err != nil {
+		return report, fmt.Errorf("failed to purge expired requests: %w", err)
+	}
+
+	if maxRows > 0 {
+		var count int
+		if err := h.app.DB().NewQuery("SELECT count(*) FROM requests").Row(&count); err != nil {
+			return report, err
+		}
+
+		if count > maxRows {
+			report.OverLimit, err = h.purgeRequests(keepFrom, count-maxRows)
+			if err != nil {
+				return report, fmt.Errorf("failed to purge requests over the limit: %w", err)
+			}
+		}
+	}
+
+	metrics.RequestsPurged.WithLabelValues("expired").Add(float64(report.Expired))
+	metrics.RequestsPurged.WithLabelValues("overLimit").Add(float64(report.OverLimit))
+	h.app.Logger().Info(
+		"purged request logs",
+		"expired", report.Expired,
+		"overLimit", report.OverLimit,
+		"cutoff", report.Cutoff,
+	)
+
+	return report, nil
+}
+
+// purgeRequests deletes the oldest requests created before the given time in chunks, at most
+// limit of them unless it is negative. It returns the number of deleted requests.
+func (h *Handler) purgeRequests(before time.Time, limit int) (int, error) {
+	deleted := 0
+
+	for limit < 0 || deleted < limit {
+		chunk := purgeChunkSize
+		if limit >= 0 {
+			chunk = min(chunk, limit-deleted)
+		}
+
+		// each chunk is its own statement, so proxied requests can be logged in between
+		result, err := h.app.DB().NewQuery(`
+			DELETE FROM requests WHERE id IN (
+				SELECT id FROM requests WHERE created < {:before} ORDER BY created LIMIT {:chunk}
+			)
+		`).Bind(dbx.Params{
+			"before": formatBucket(before),
+			"chunk":  chunk,
+		}).Execute()
+		if err != nil {
+			return deleted, err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+
+		deleted += int(affected)
+		if affected < int64(chunk) {
+			break
+		}
+	}
+
+	return deleted, nil
+}
+
+// NewPurgeCommand creates the "purge-requests" command to clean the request log on demand.
+func NewPurgeCommand(h *Handler) *cobra.Command {
+	return &cobra.Command{
+		Use:   "purge-requests",
+		Short: "Rolls up and deletes the expired request logs",
+		Long: "Rolls up the request logs into the usage history and deletes the ones older than the " +
+			"retention configured in the web UI, and the oldest ones above the maximum row count.",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := h.app.RunAllMigrations(); err != nil {
+				return err
+			}
+
+			report, err := h.CleanRequestLogs()
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf(
+				"Purged %d requests: %d created before %s, %d over the maximum row count.\n",
+				report.Total(), report.Expired, report.Cutoff.Local().Format(time.DateTime), report.OverLimit,
+			)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// setRequestRetention configures the retention of the request log.
+func (p *testProxy) setRequestRetention(t *testing.T, days, maxRows int) {
+	t.Helper()
+
+	settings, err := p.handler.ensureSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings.Set("requestRetention", days)
+	settings.Set("requestMaxRows", maxRows)
+	if err := p.app.Save(settings); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (p *testProxy) countRequests(t *testing.T) int {
+	t.Helper()
+
+	count, err := p.app.CountRecords("requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(count)
+}
+
+func TestCleanRequestLogsDeletesExpiredRequests(t *testing.T) {
+	p := newTestProxy(t)
+	p.setRequestRetention(t, 1, 0)
+	base := rollupBase()
+
+	for i := range 3 {
+		p.newRequest(t, base.Add(time.Duration(i)*time.Minute), nil)
+	}
+	for range 2 {
+		p.newRequest(t, time.Now(), nil)
+	}
+
+	report, err := p.handler.CleanRequestLogs()
+	if err != nil {
+		t.Fatalf("CleanRequestLogs() error = %v", err)
+	}
+	if report.Expired != 3 || report.OverLimit != 0 {
+		t.Errorf("report = %+v, want the 3 expired requests", report)
+	}
+	if n := p.countRequests(t); n != 2 {
+		t.Errorf("%d requests left, want 2", n)
+	}
+
+	// the deleted requests are part of the history
+	if got := totalCount(p.rollups(t, rollupHour)); got != 5 {
+		t.Errorf("hourly rollups count %d requests, want 5", got)
+	}
+}
+
+func TestCleanRequestLogsKeepsCurrentQuotaDay(t *testing.T) {
+	p := newTestProxy(t)
+	p.setRequestRetention(t, 0, 1)
+	base := rollupBase()
+
+	for i := range 3 {
+		p.newRequest(t, base.Add(time.Duration(i)*time.Minute), nil)
+	}
+	for range 3 {
+		p.newRequest(t, time.Now(), nil)
+	}
+
+	report, err := p.handler.CleanRequestLogs()
+	if err != nil {
+		t.Fatalf("CleanRequestLogs() error = %v", err)
+	}
+	if report.Expired != 0 || report.OverLimit != 3 {
+		t.Errorf("report = %+v, want the 3 requests before the quota day over the limit", report)
+	}
+	// the requests the token usage is counted from are kept above the maximum row count
+	if n := p.countRequests(t); n != 3 {
+		t.Errorf("%d requests left, want the 3 of the current quota day", n)
+	}
+}
+
+func TestCleanRequestLogsKeepsRequestsThatWerentRolledUp(t *testing.T) {
+	p := newTestProxy(t)
+	p.setRequestRetention(t, 1, 0)
+
+	// the latest hours are rolled up again by the next run, so their requests are kept
+	p.newRequest(t, time.Now().Add(-3*24*time.Hour), nil)
+
+	report, err := p.handler.CleanRequestLogs()
+	if err != nil {
+		t.Fatalf("CleanRequestLogs() error = %v", err)
+	}
+	if report.Total() != 0 || p.countRequests(t) != 1 {
+		t.Errorf("report = %+v, want the request of the latest rolled up hour kept", report)
+	}
+}
+
+func TestPurgeRequestsDeletesOldestFirst(t *testing.T) {
+	p := newTestProxy(t)
+	base := rollupBase()
+
+	oldest := p.newRequest(t, base, nil)
+	p.newRequest(t, base.Add(time.Minute), nil)
+	newest := p.newRequest(t, base.Add(2*time.Minute), nil)
+
+	deleted, err := p.handler.purgeRequests(time.Now(), 2)
+	if err != nil {
+		t.Fatalf("purgeRequests() error = %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("purgeRequests() = %d, want 2", deleted)
+	}
+
+	if _, err := p.app.FindRecordById("requests", oldest.Id); err == nil {
+		t.Error("the oldest request was kept")
+	}
+	if _, err := p.app.FindRecordById("requests", newest.Id); err != nil {
+		t.Error("the newest request was deleted")
+	}
+}
@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/s1adem4n/tado-api-proxy/internal/metrics"
+)
+
+const (
+	// requestLogQueueSize bounds the entries waiting to be written. Further entries are dropped.
+	requestLogQueueSize = 4096
+	// requestLogBatchSize is the maximum number of entries written in one transaction.
+	requestLogBatchSize = 256
+	// requestLogFlushInterval is how long an entry waits at most until it is written.
+	requestLogFlushInterval = time.Second
+)
+
+// requestLogWriter writes the request log in batches from a background goroutine, so proxied
+// requests don't wait for SQLite. Each batch inserts the requests and updates the last use
+// of their tokens in a single transaction.
+type requestLogWriter struct {
+	app   core.App
+	queue chan requestLog
+	done  chan struct{}
+	// logged is called with each entry that was written
+	logged func(entry requestLog)
+	// dropped is called with each entry that couldn't be written
+	dropped func(entry requestLog)
+
+	// mu is held while a batch is written and reported
+	mu sync.Mutex
+}
+
+func newRequestLogWriter(app core.App, logged, dropped func(entry requestLog)) *requestLogWriter {
+	return &requestLogWriter{
+		app:     app,
+		queue:   make(chan requestLog, requestLogQueueSize),
+		done:    make(chan struct{}),
+		logged:  logged,
+		dropped: dropped,
+	}
+}
+
+// paused runs fn while no batch is written, so each entry is either queued or was reported as written or dropped.
+func (w *requestLogWriter) paused(fn func() error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return fn()
+}
+
+// enqueue queues an entry without blocking. The entry is dropped if the queue is full.
+func (w *requestLogWriter) enqueue(entry requestLog) {
+	select {
+	case w.queue <- entry:
+	default:
+		w.app.Logger().Warn("request log queue is full, dropped entry", "url", entry.url)
+		w.drop(entry)
+	}
+}
+
+// drop reports an entry that isn't in the requests table.
+func (w *requestLogWriter) drop(entry requestLog) {
+	metrics.RequestLogDropped.Inc()
+	w.dropped(entry)
+}
+
+// run writes the queued entries until the context is canceled, then writes the remaining ones.
+func (w *requestLogWriter) run(ctx context.Context) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(requestLogFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]requestLog, 0, requestLogBatchSize)
+	add := func(entry requestLog) {
+		batch = append(batch, entry)
+		if len(batch) >= requestLogBatchSize {
+			w.write(batch)
+			batch = batch[:0]
+		}
+	}
+
+	for {
+		select {
+		case entry := <-w.queue:
+			add(entry)
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.write(batch)
+				batch = batch[:0]
+			}
+		case <-ctx.Done():
+			for {
+				select {
+				case entry := <-w.queue:
+					add(entry)
+				default:
+					if len(batch) > 0 {
+						w.write(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// wait blocks until run wrote the remaining entries after its context was canceled.
+func (w *requestLogWriter) wait() {
+	<-w.done
+}
+
+// write inserts the entries and updates the last use of their tokens.
+// Entries that weren't inserted are dropped.
+func (w *requestLogWriter) write(batch []requestLog) {
+	defer func() {
+		now := time.Now()
+		for _, entry := range batch {
+			metrics.RequestLogDelay.Observe(now.Sub(entry.time).Seconds())
+		}
+	}()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	collection, err := w.app.FindCachedCollectionByNameOrId("requests")
+	if err != nil {
+		w.app.Logger().Error("failed to find requests collection", "error", err)
+		for _, entry := range batch {
+			w.drop(entry)
+		}
+		return
+	}
+
+	used := map[string]time.Time{}
+	var written, skipped []requestLog
+
+	err = w.app.RunInTransaction(func(txApp core.App) error {
+		for _, entry := range batch {
+			created, _ := types.ParseDateTime(entry.time)
+
+			requestRecord := core.NewRecord(collection)
+			requestRecord.Set("token", entry.tokenID)
+			requestRecord.Set("apiKey", entry.apiKeyID)
+			requestRecord.Set("method", entry.method)
+			requestRecord.Set("url", entry.url)
+			requestRecord.Set("endpoint", entry.endpoint)
+			requestRecord.Set("status", entry.status)
+			requestRecord.Set("coalesced", entry.coalesced)
+			requestRecord.Set("strategy", string(entry.strategy))
+			requestRecord.Set("duration", entry.duration.Milliseconds())
+			requestRecord.Set("bytes", entry.bytes)
+			requestRecord.Set("attempt", entry.attempt)
+			requestRecord.Set("tokensTried", entry.tokensTried)
+			requestRecord.Set("host", entry.host)
+			requestRecord.Set("errorClass", string(entry.errorClass))
+			// keep the time of the request instead of the time of the batch
+			requestRecord.SetRaw("created", created)
+
+			// an invalid entry, e.g. of a token deleted in the meantime, doesn't fail the others
+			if err := txApp.Save(requestRecord); err != nil {
+				w.app.Logger().Error("failed to log request", "error", err)
+				skipped = append(skipped, entry)
+				continue
+			}
+			written = append(written, entry)
+
+			if entry.tokenID != "" && !entry.coalesced && entry.time.After(used[entry.tokenID]) {
+				used[entry.tokenID] = entry.time
+			}
+		}
+
+		for tokenID, usedAt := range used {
+			tokenRecord, err := txApp.FindRecordById("tokens", tokenID)
+			if err != nil {
+				continue
+			}
+
+			// the last use is informational, the logged requests matter more. It's written directly,
+			// since saving the token would run its update hooks for every batch, e.g. dropping the pooled limit.
+			if usedAt.After(tokenRecord.GetDateTime("used").Time()) {
+				lastUse, _ := types.ParseDateTime(usedAt)
+				_, err := txApp.DB().Update("tokens", dbx.Params{"used": lastUse.String()}, dbx.HashExp{"id": tokenID}).Execute()
+				if err != nil {
+					w.app.Logger().Error("failed to update token last use", "id", tokenID, "error", err)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		// the rollback discarded the whole batch
+		w.app.Logger().Error("failed to write request log", "entries", len(batch), "error", err)
+		written, skipped = nil, batch
+	}
+
+	for _, entry := range written {
+		w.logged(entry)
+	}
+	for _, entry := range skipped {
+		w.drop(entry)
+	}
+}
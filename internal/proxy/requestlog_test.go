@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/faketest"
+)
+
+func TestRequestLogWriterReportsDroppedEntries(t *testing.T) {
+	app := faketest.NewApp(t)
+
+	var logged, dropped []requestLog
+	w := newRequestLogWriter(app, func(entry requestLog) {
+		logged = append(logged, entry)
+	}, func(entry requestLog) {
+		dropped = append(dropped, entry)
+	})
+
+	valid := requestLog{method: "GET", url: "https://my.tado.com/api/v2/me", status: 200, time: time.Now()}
+	// without a URL the requests collection rejects the entry
+	invalid := requestLog{method: "GET", status: 200, time: time.Now()}
+
+	w.write([]requestLog{valid, invalid, valid})
+
+	total, err := app.CountRecords("requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 {
+		t.Errorf("%d requests logged, want the 2 valid ones", total)
+	}
+	if len(logged) != 2 {
+		t.Errorf("logged = %+v, want the valid entries", logged)
+	}
+	if len(dropped) != 1 || dropped[0].url != "" {
+		t.Errorf("dropped = %+v, want the invalid entry", dropped)
+	}
+}
+
+func TestRequestLogWriterDropsWhenQueueIsFull(t *testing.T) {
+	app := faketest.NewApp(t)
+
+	dropped := 0
+	w := newRequestLogWriter(app, func(entry requestLog) {}, func(entry requestLog) {
+		dropped++
+	})
+
+	for range requestLogQueueSize + 3 {
+		w.enqueue(requestLog{method: "GET", url: "https://my.tado.com/api/v2/me", time: time.Now()})
+	}
+	if dropped != 3 {
+		t.Errorf("%d entries dropped, want the 3 beyond the queue size", dropped)
+	}
+
+	// the queued entries are written once the writer stops
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w.run(ctx)
+
+	total, err := app.CountRecords("requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != requestLogQueueSize {
+		t.Errorf("%d requests logged, want %d", total, requestLogQueueSize)
+	}
+}
+
+func TestLogRequestCountsDroppedEntriesAsUnlogged(t *testing.T) {
+	p := newTestProxy(t)
+	token := p.tokens["web-client"]
+
+	// a batch that can't be written is still counted by the next reconciliation
+	p.handler.logRequest(requestLog{tokenID: token.Id, apiKeyID: "key", method: "GET"})
+	p.handler.requestLog.write([]requestLog{<-p.handler.requestLog.queue})
+
+	if err := p.handler.reconcileUsage(); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.handler.usage.get(token.Id); got != 1 {
+		t.Errorf("usage of the token = %d, want 1 including the dropped entry", got)
+	}
+	if got := p.handler.consumerUsage.get("key"); got != 1 {
+		t.Errorf("usage of the API key = %d, want 1 including the dropped entry", got)
+	}
+}
+
+func TestReconcileUsageKeepsQueuedEntries(t *testing.T) {
+	p := newTestProxy(t)
+	token := p.tokens["web-client"]
+	entry := requestLog{tokenID: token.Id, apiKeyID: "key", method: "GET", url: "https://my.tado.com/api/v2/me"}
+
+	p.handler.logRequest(entry)
+	p.handler.logRequest(entry)
+
+	// both entries are still queued
+	if err := p.handler.reconcileUsage(); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.handler.usage.get(token.Id); got != 2 {
+		t.Errorf("usage of the token = %d, want 2 including the queued entries", got)
+	}
+	if got := p.handler.consumerUsage.get("key"); got != 2 {
+		t.Errorf("usage of the API key = %d, want 2 including the queued entries", got)
+	}
+
+	// one was written, the other one is still queued
+	p.handler.requestLog.write([]requestLog{<-p.handler.requestLog.queue})
+
+	if err := p.handler.reconcileUsage(); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.handler.usage.get(token.Id); got != 2 {
+		t.Errorf("usage of the token = %d, want 2", got)
+	}
+}
+
+func TestRequestLogWriterUpdatesLastUseWithoutHooks(t *testing.T) {
+	p := newTestProxy(t)
+	p.register(t)
+	token := p.tokens["web-client"]
+
+	if _, err := p.handler.pooledLimit(); err != nil {
+		t.Fatal(err)
+	}
+
+	usedAt := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	p.handler.requestLog.write([]requestLog{{
+		tokenID: token.Id,
+		method:  "GET",
+		url:     "https://my.tado.com" + zonesPath,
+		status:  200,
+		time:    usedAt,
+	}})
+
+	token, err := p.app.FindRecordById("tokens", token.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := token.GetDateTime("used").Time(); !got.Equal(usedAt) {
+		t.Errorf("used = %v, want %v", got, usedAt)
+	}
+
+	// the last use doesn't change the pooled limit, so it stays cached
+	p.handler.pooled.mu.Lock()
+	valid := p.handler.pooled.valid
+	p.handler.pooled.mu.Unlock()
+	if !valid {
+		t.Error("writing the request log dropped the cached pooled limit")
+	}
+}
@@ -0,0 +1,394 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// defaultRollupRetention is how long rollups are kept if the settings don't configure it.
+const defaultRollupRetention = 365 * 24 * time.Hour
+
+// The strftime formats that truncate a date to the start of its bucket, like the date fields are stored.
+const (
+	minuteFormat = "%Y-%m-%d %H:%M:00.000Z"
+	hourFormat   = "%Y-%m-%d %H:00:00.000Z"
+	dayFormat    = "%Y-%m-%d 00:00:00.000Z"
+)
+
+// rollupPeriod is the length of the bucket of a rollup.
+type rollupPeriod string
+
+const (
+	rollupHour rollupPeriod = "hour"
+	rollupDay  rollupPeriod = "day"
+)
+
+// rollupKey is a bucket of a rollup and the dimensions its requests are counted by.
+type rollupKey struct {
+	bucket      string
+	token       string
+	client      string
+	account     string
+	endpoint    string
+	statusClass string
+}
+
+// rollupCounts are the requests of a rollup.
+type rollupCounts struct {
+	Count     int `db:"count"`
+	Coalesced int `db:"coalesced"`
+}
+
+// requestRollups aggregates the request log into hourly and daily buckets, so the usage history
+// outlives the raw requests. Each run recomputes the buckets from the one before the latest on,
+// which includes the current hour and day, so the rollups are complete up to the last run.
+// Recomputing the previous bucket too picks up requests that were logged after their hour ended.
+type requestRollups struct {
+	app       core.App
+	endpoints *endpointMatcher
+
+	// mu serializes the runs, which delete and recreate the latest buckets
+	mu        sync.Mutex
+	retention time.Duration
+}
+
+func newRequestRollups(app core.App, endpoints *endpointMatcher) *requestRollups {
+	return &requestRollups{
+		app:       app,
+		endpoints: endpoints,
+		retention: defaultRollupRetention,
+	}
+}
+
+// loadSettings reads the retention in days from the settings record.
+func (r *requestRollups) loadSettings(settings *core.Record) {
+	retention := defaultRollupRetention
+	if days := settings.GetInt("rollupRetention"); days > 0 {
+		retention = time.Duration(days) * 24 * time.Hour
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.retention = retention
+}
+
+// run rolls up the new requests and deletes the rollups older than the retention.
+func (r *requestRollups) run() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.rollUpHours(); err != nil {
+		return fmt.Errorf("failed to roll up hours: %w", err)
+	}
+	if err := r.rollUpDays(); err != nil {
+		return fmt.Errorf("failed to roll up days: %w", err)
+	}
+
+	_, err := r.app.DB().NewQuery(
+		"DELETE FROM requestRollups WHERE bucket < {:cutoff}",
+	).Bind(dbx.Params{
+		"cutoff": formatBucket(time.Now().Add(-r.retention)),
+	}).Execute()
+	return err
+}
+
+// watermark returns the start of the hourly bucket before the latest one. The requests from then
+// on are rolled up again by the next run, so they must not be deleted. It is zero if nothing was rolled up.
+func (r *requestRollups) watermark() (time.Time, error) {
+	from, err := r.recomputeFrom(rollupHour)
+	if err != nil || from == "" {
+		return time.Time{}, err
+	}
+
+	parsed, err := types.ParseDateTime(from)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parsed.Time(), nil
+}
+
+// recomputeFrom returns the start of the bucket of the period before the latest one,
+// or an empty string if nothing was rolled up.
+func (r *requestRollups) recomputeFrom(period rollupPeriod) (string, error) {
+	latest, err := r.latestBucket(period)
+	if err != nil || latest == "" {
+		return "", err
+	}
+
+	parsed, err := types.ParseDateTime(latest)
+	if err != nil {
+		return "", err
+	}
+
+	size := time.Hour
+	if period == rollupDay {
+		size = 24 * time.Hour
+	}
+	return formatBucket(parsed.Time().Add(-size)), nil
+}
+
+// rollUpHours recomputes the hourly buckets from the one before the latest on from the requests.
+func (r *requestRollups) rollUpHours() error {
+	from, err := r.recomputeFrom(rollupHour)
+	if err != nil {
+		return err
+	}
+	if from == "" {
+		// nothing was rolled up yet, so start with the oldest request
+		err := r.app.DB().NewQuery(
+			"SELECT coalesce(strftime({:format}, min(created)), '') FROM requests",
+		).Bind(dbx.Params{
+			"format": hourFormat,
+		}).Row(&from)
+		if err != nil || from == "" {
+			return err
+		}
+	}
+
+	buckets, err := r.aggregateRequests(hourFormat, from, "")
+	if err != nil {
+		return err
+	}
+
+	return r.replace(rollupHour, from, buckets)
+}
+
+// rollUpDays recomputes the daily buckets from the one before the latest on from the hourly buckets.
+func (r *requestRollups) rollUpDays() error {
+	from, err := r.recomputeFrom(rollupDay)
+	if err != nil {
+		return err
+	}
+	if from == "" {
+		err := r.app.DB().NewQuery(
+			"SELECT coalesce(strftime({:format}, min(bucket)), '') FROM requestRollups WHERE period = 'hour'",
+		).Bind(dbx.Params{
+			"format": dayFormat,
+		}).Row(&from)
+		if err != nil || from == "" {
+			return err
+		}
+	}
+
+	buckets, err := r.aggregateRollups(dayFormat, from, "")
+	if err != nil {
+		return err
+	}
+
+	return r.replace(rollupDay, from, buckets)
+}
+
+// aggregateRequests counts the requests created from the given time on, and before the other
+// one unless it is empty, by the buckets of the strftime format and the rollup dimensions.
+func (r *requestRollups) aggregateRequests(format, from, to string) (map[rollupKey]rollupCounts, error) {
+	var rows []struct {
+		Bucket   string `db:"bucket"`
+		Token    string `db:"token"`
+		Client   string `db:"client"`
+		Account  string `db:"account"`
+		Endpoint string `db:"endpoint"`
+		URL      string `db:"url"`
+		Status   int    `db:"status"`
+		rollupCounts
+	}
+	err := r.app.DB().NewQuery(`
+		SELECT
+			strftime({:format}, requests.created) AS bucket,
+			requests.token AS token,
+			coalesce(tokens.client, '') AS client,
+			coalesce(tokens.account, '') AS account,
+			requests.endpoint AS endpoint,
+			CASE WHEN requests.endpoint = '' THEN requests.url ELSE '' END AS url,
+			requests.status AS status,
+			count(*) AS count,
+			sum(requests.coalesced) AS coalesced
+		FROM requests
+		LEFT JOIN tokens ON tokens.id = requests.token
+		WHERE requests.created >= {:from} AND ({:to} = '' OR requests.created < {:to})
+		GROUP BY bucket, requests.token, requests.endpoint,
+			CASE WHEN requests.endpoint = '' THEN requests.url ELSE '' END, requests.status
+	`).Bind(dbx.Params{
+		"format": format,
+		"from":   from,
+		"to":     to,
+	}).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// requests logged before the endpoint was stored are grouped by their URL,
+	// and URLs with different IDs and queries share an endpoint
+	buckets := map[rollupKey]rollupCounts{}
+	for _, row := range rows {
+		// requests logged before the endpoint was stored only have their URL
+		if row.Endpoint == "" {
+			row.Endpoint = r.urlEndpoint(row.URL)
+		}
+
+		key := rollupKey{
+			bucket:      row.Bucket,
+			token:       row.Token,
+			client:      row.Client,
+			account:     row.Account,
+			endpoint:    row.Endpoint,
+			statusClass: fmt.Sprintf("%dxx", row.Status/100),
+		}
+		counts := buckets[key]
+		counts.Count += row.Count
+		counts.Coalesced += row.Coalesced
+		buckets[key] = counts
+	}
+
+	return buckets, nil
+}
+
+// aggregateRollups sums the hourly buckets from the given one on, and before the other one
+// unless it is empty, by the buckets of the strftime format and the rollup dimensions.
+func (r *requestRollups) aggregateRollups(format, from, to string) (map[rollupKey]rollupCounts, error) {
+	var rows []struct {
+		Bucket      string `db:"bucket"`
+		Token       string `db:"token"`
+		Client      string `db:"client"`
+		Account     string `db:"account"`
+		Endpoint    string `db:"endpoint"`
+		StatusClass string `db:"statusClass"`
+		rollupCounts
+	}
+	err := r.app.DB().NewQuery(`
+		SELECT
+			strftime({:format}, bucket) AS bucket,
+			token, client, account, endpoint, statusClass,
+			sum(count) AS count,
+			sum(coalesced) AS coalesced
+		FROM requestRollups
+		WHERE period = 'hour' AND bucket >= {:from} AND ({:to} = '' OR bucket < {:to})
+		GROUP BY 1, token, client, account, endpoint, statusClass
+	`).Bind(dbx.Params{
+		"format": format,
+		"from":   from,
+		"to":     to,
+	}).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make(map[rollupKey]rollupCounts, len(rows))
+	for _, row := range rows {
+		buckets[rollupKey{
+			bucket:      row.Bucket,
+			token:       row.Token,
+			client:      row.Client,
+			account:     row.Account,
+			endpoint:    row.Endpoint,
+			statusClass: row.StatusClass,
+		}] = row.rollupCounts
+	}
+
+	return buckets, nil
+}
+
+// replace deletes the buckets of the period from the given one on and saves the new ones.
+func (r *requestRollups) replace(period rollupPeriod, from string, buckets map[rollupKey]rollupCounts) error {
+	collection, err := r.app.FindCachedCollectionByNameOrId("requestRollups")
+	if err != nil {
+		return err
+	}
+
+	return r.app.RunInTransaction(func(txApp core.App) error {
+		_, err := txApp.DB().NewQuery(
+			"DELETE FROM requestRollups WHERE period = {:period} AND bucket >= {:from}",
+		).Bind(dbx.Params{
+			"period": string(period),
+			"from":   from,
+		}).Execute()
+		if err != nil {
+			return err
+		}
+
+		for key, counts := range buckets {
+			record := core.NewRecord(collection)
+			record.Set("period", string(period))
+			record.Set("bucket", key.bucket)
+			record.Set("token", key.token)
+			record.Set("client", key.client)
+			record.Set("account", key.account)
+			record.Set("endpoint", key.endpoint)
+			record.Set("statusClass", key.statusClass)
+			record.Set("count", counts.Count)
+			record.Set("coalesced", counts.Coalesced)
+			if err := txApp.Save(record); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// latestBucket returns the start of the latest bucket of the period, or an empty string if there is none.
+func (r *requestRollups) latestBucket(period rollupPeriod) (string, error) {
+	var latest string
+	err := r.app.DB().NewQuery(
+		"SELECT coalesce(max(bucket), '') FROM requestRollups WHERE period = {:period}",
+	).Bind(dbx.Params{
+		"period": string(period),
+	}).Row(&latest)
+	return latest, err
+}
+
+// countSince returns the number of requests since the given time, from the hourly rollups
+// for the time that was rolled up and from the requests after it. The rolled up time is
+// counted in full hours.
+func (r *requestRollups) countSince(from time.Time) (int, error) {
+	watermark, err := r.watermark()
+	if err != nil {
+		return 0, err
+	}
+
+	var rolledUp int
+	if watermark.After(from) {
+		err := r.app.DB().NewQuery(
+			"SELECT coalesce(sum(count), 0) FROM requestRollups WHERE period = 'hour' AND bucket >= {:from} AND bucket < {:to}",
+		).Bind(dbx.Params{
+			"from": formatBucket(from.Truncate(time.Hour)),
+			"to":   formatBucket(watermark),
+		}).Row(&rolledUp)
+		if err != nil {
+			return 0, err
+		}
+		from = watermark
+	}
+
+	var recent int
+	err = r.app.DB().NewQuery(
+		"SELECT count(*) FROM requests WHERE created >= {:from}",
+	).Bind(dbx.Params{
+		"from": formatBucket(from),
+	}).Row(&recent)
+	if err != nil {
+		return 0, err
+	}
+
+	return rolledUp + recent, nil
+}
+
+// formatBucket formats the time like the date fields are stored.
+func formatBucket(t time.Time) string {
+	return t.UTC().Format(types.DefaultDateLayout)
+}
+
+// urlEndpoint returns the endpoint of a logged upstream URL.
+func (r *requestRollups) urlEndpoint(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return r.endpoints.match(parsed.Path)
+}
@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/faketest"
+)
+
+// newRequest logs a request created at the given time. The data defaults to a successful
+// request to the zones of the test home.
+func (p *testProxy) newRequest(t testing.TB, created time.Time, data map[string]any) *core.Record {
+	t.Helper()
+
+	fields := map[string]any{
+		"method":   "GET",
+		"url":      "https://my.tado.com" + zonesPath,
+		"endpoint": "/homes/{homeId}/zones",
+		"status":   200,
+	}
+	for key, value := range data {
+		fields[key] = value
+	}
+
+	record := faketest.NewRecord(t, p.app, "requests", fields)
+	_, err := p.app.DB().NewQuery("UPDATE requests SET created = {:created} WHERE id = {:id}").Bind(dbx.Params{
+		"created": formatBucket(created),
+		"id":      record.Id,
+	}).Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+// rollups returns the stored rollups of the period by bucket and dimensions.
+func (p *testProxy) rollups(t *testing.T, period rollupPeriod) map[rollupKey]rollupCounts {
+	t.Helper()
+
+	records, err := p.app.FindRecordsByFilter("requestRollups", "period = {:period}", "", 0, 0, dbx.Params{"period": string(period)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rollups := make(map[rollupKey]rollupCounts, len(records))
+	for _, r := range records {
+		rollups[rollupKey{
+			bucket:      r.GetString("bucket"),
+			token:       r.GetString("token"),
+			client:      r.GetString("client"),
+			account:     r.GetString("account"),
+			endpoint:    r.GetString("endpoint"),
+			statusClass: r.GetString("statusClass"),
+		}] = rollupCounts{Count: r.GetInt("count"), Coalesced: r.GetInt("coalesced")}
+	}
+	return rollups
+}
+
+// totalCount returns the sum of the requests of the rollups.
+func totalCount(rollups map[rollupKey]rollupCounts) int {
+	total := 0
+	for _, counts := range rollups {
+		total += counts.Count
+	}
+	return total
+}
+
+// rollupBase returns 10:00 UTC two days ago, so all test buckets are complete.
+func rollupBase() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour).Add(-48*time.Hour + 10*time.Hour)
+}
+
+func TestRollupsAggregateRequests(t *testing.T) {
+	p := newTestProxy(t)
+	web, mobile := p.tokens["web-client"], p.tokens["mobile-client"]
+	base := rollupBase()
+
+	p.newRequest(t, base.Add(5*time.Minute), map[string]any{"token": web.Id})
+	p.newRequest(t, base.Add(6*time.Minute), map[string]any{"token": web.Id, "coalesced": true})
+	p.newRequest(t, base.Add(10*time.Minute), map[string]any{"token": web.Id, "status": 429})
+	// requests logged before the endpoint was stored are matched by their URL
+	p.newRequest(t, base.Add(65*time.Minute), map[string]any{
+		"token":    mobile.Id,
+		"url":      "https://my.tado.com/api/v2/homes/1/zoneStates",
+		"endpoint": "",
+	})
+
+	if err := p.handler.rollups.run(); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	webKey := func(bucket time.Time, statusClass string) rollupKey {
+		return rollupKey{
+			bucket:      formatBucket(bucket),
+			token:       web.Id,
+			client:      web.GetString("client"),
+			account:     web.GetString("account"),
+			endpoint:    "/homes/{homeId}/zones",
+			statusClass: statusClass,
+		}
+	}
+	mobileKey := func(bucket time.Time) rollupKey {
+		return rollupKey{
+			bucket:      formatBucket(bucket),
+			token:       mobile.Id,
+			client:      mobile.GetString("client"),
+			account:     mobile.GetString("account"),
+			endpoint:    "/homes/{homeId}/zoneStates",
+			statusClass: "2xx",
+		}
+	}
+
+	day := base.Truncate(24 * time.Hour)
+	tests := []struct {
+		period rollupPeriod
+		want   map[rollupKey]rollupCounts
+	}{
+		{rollupHour, map[rollupKey]rollupCounts{
+			webKey(base, "2xx"):            {Count: 2, Coalesced: 1},
+			webKey(base, "4xx"):            {Count: 1},
+			mobileKey(base.Add(time.Hour)): {Count: 1},
+		}},
+		{rollupDay, map[rollupKey]rollupCounts{
+			webKey(day, "2xx"): {Count: 2, Coalesced: 1},
+			webKey(day, "4xx"): {Count: 1},
+			mobileKey(day):     {Count: 1},
+		}},
+	}
+
+	for _, tt := range tests {
+		got := p.rollups(t, tt.period)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s rollups = %+v, want %+v", tt.period, got, tt.want)
+			continue
+		}
+		for key, want := range tt.want {
+			if got[key] != want {
+				t.Errorf("%s rollup %+v = %+v, want %+v", tt.period, key, got[key], want)
+			}
+		}
+	}
+}
+
+func TestRollupsRecomputeLatestBuckets(t *testing.T) {
+	p := newTestProxy(t)
+	base := rollupBase()
+
+	p.newRequest(t, base, nil)
+	p.newRequest(t, base.Add(time.Hour), nil)
+	if err := p.handler.rollups.run(); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	// requests logged late for the latest and the previous hour are rolled up by the next run
+	p.newRequest(t, base.Add(30*time.Minute), nil)
+	p.newRequest(t, base.Add(90*time.Minute), nil)
+
+	for range 2 {
+		if err := p.handler.rollups.run(); err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+	}
+
+	if got := totalCount(p.rollups(t, rollupHour)); got != 4 {
+		t.Errorf("hourly rollups count %d requests, want 4", got)
+	}
+	if got := totalCount(p.rollups(t, rollupDay)); got != 4 {
+		t.Errorf("daily rollups count %d requests, want 4", got)
+	}
+}
+
+func TestRollupsDeleteExpiredBuckets(t *testing.T) {
+	p := newTestProxy(t)
+	base := rollupBase()
+
+	p.newRequest(t, base, nil)
+	p.newRequest(t, time.Now(), nil)
+
+	settings, err := p.handler.ensureSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings.Set("rollupRetention", 1)
+	p.handler.rollups.loadSettings(settings)
+
+	if err := p.handler.rollups.run(); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	for _, period := range []rollupPeriod{rollupHour, rollupDay} {
+		for key := range p.rollups(t, period) {
+			if key.bucket < formatBucket(time.Now().Add(-24*time.Hour)) {
+				t.Errorf("%s rollup %s is older than the retention", period, key.bucket)
+			}
+		}
+	}
+	if got := totalCount(p.rollups(t, rollupHour)); got != 1 {
+		t.Errorf("hourly rollups count %d requests, want the recent one", got)
+	}
+}
+
+func TestRollupsCountSince(t *testing.T) {
+	p := newTestProxy(t)
+	base := rollupBase()
+
+	for i := range 5 {
+		p.newRequest(t, base.Add(time.Duration(i)*time.Hour), nil)
+	}
+	p.newRequest(t, time.Now(), nil)
+
+	if err := p.handler.rollups.run(); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	// the requests that were rolled up are counted from the rollups after they are deleted
+	watermark, err := p.handler.rollups.watermark()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.handler.purgeRequests(watermark, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := p.handler.rollups.countSince(base.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("countSince() error = %v", err)
+	}
+	if count != 6 {
+		t.Errorf("countSince() = %d, want 6", count)
+	}
+
+	count, err = p.handler.rollups.countSince(base.Add(2 * time.Hour))
+	if err != nil {
+		t.Fatalf("countSince() error = %v", err)
+	}
+	if count != 4 {
+		t.Errorf("countSince() = %d, want the 4 requests from the third hour on", count)
+	}
+}
@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+const (
+	// defaultPollQuotaShare is the percentage of the remaining quota the scheduler may spend until the reset.
+	defaultPollQuotaShare = 50
+	// minPollInterval keeps the scheduler from polling in a tight loop if the quota is large.
+	minPollInterval = 15 * time.Second
+	// pollJitter varies each interval by up to 20%, so the requests don't follow a fixed pattern.
+	pollJitter = 0.2
+	// warmMargin keeps the polled responses cached a bit longer than the next round takes.
+	warmMargin = 30 * time.Second
+)
+
+// defaultHourlyRates slows polling down during the night: a quarter of the rate
+// from midnight until 6:00 and half of it in the hours around. The hours are in the
+// time zone of the daily rate limit reset.
+var defaultHourlyRates = [24]float64{
+	0.25, 0.25, 0.25, 0.25, 0.25, 0.25, 0.5, 1, 1, 1, 1, 1,
+	1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0.5,
+}
+
+// schedulerConfig is the polling configuration from the settings record.
+type schedulerConfig struct {
+	endpoints   []string
+	quotaShare  float64
+	hourlyRates [24]float64
+}
+
+// loadSchedulerConfig reads the polling configuration from the settings record and applies the defaults.
+func loadSchedulerConfig(settings *core.Record) (schedulerConfig, error) {
+	cfg := schedulerConfig{
+		quotaShare:  settings.GetFloat("pollQuotaShare"),
+		hourlyRates: defaultHourlyRates,
+	}
+	if cfg.quotaShare <= 0 {
+		cfg.quotaShare = defaultPollQuotaShare
+	}
+
+	if settings.GetString("pollEndpoints") != "" {
+		var endpoints []string
+		if err := settings.UnmarshalJSONField("pollEndpoints", &endpoints); err != nil {
+			return cfg, fmt.Errorf("invalid pollEndpoints: %w", err)
+		}
+
+		for _, endpoint := range endpoints {
+			endpoint = strings.TrimSpace(endpoint)
+			if endpoint == "" {
+				continue
+			}
+			if !strings.HasPrefix(endpoint, "/api/v2/") && !strings.HasPrefix(endpoint, "/api/hops/") {
+				return cfg, fmt.Errorf("invalid poll endpoint %q, expected a path starting with /api/v2/ or /api/hops/", endpoint)
+			}
+			cfg.endpoints = append(cfg.endpoints, endpoint)
+		}
+	}
+
+	if settings.GetString("pollHourlyRates") != "" {
+		var rates []float64
+		if err := settings.UnmarshalJSONField("pollHourlyRates", &rates); err != nil {
+			return cfg, fmt.Errorf("invalid pollHourlyRates: %w", err)
+		}
+		if rates != nil {
+			if len(rates) != 24 {
+				return cfg, fmt.Errorf("invalid pollHourlyRates: expected 24 values, got %d", len(rates))
+			}
+			copy(cfg.hourlyRates[:], rates)
+		}
+	}
+
+	return cfg, nil
+}
+
+// rate returns the relative polling rate at the given time, by its hour in its location.
+func (c *schedulerConfig) rate(t time.Time) float64 {
+	return max(c.hourlyRates[t.Hour()], 0)
+}
+
+// weightedDuration returns the time between from and to, with each hour weighted by its polling rate.
+// The hours are in the location of from.
+func (c *schedulerConfig) weightedDuration(from, to time.Time) time.Duration {
+	var weighted float64
+
+	for t := from; t.Before(to); {
+		next := nextHour(t)
+		if next.After(to) {
+			next = to
+		}
+
+		weighted += c.rate(t) * float64(next.Sub(t))
+		t = next
+	}
+
+	return time.Duration(weighted)
+}
+
+// nextHour returns the start of the hour after t in its location.
+func nextHour(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+}
+
+// schedulerStatus is the state of the scheduler returned by /api/scheduler.
+type schedulerStatus struct {
+	Endpoints []string       `json:"endpoints"`
+	Interval  float64        `json:"interval"`
+	LastPoll  types.DateTime `json:"lastPoll"`
+	NextPoll  types.DateTime `json:"nextPoll"`
+	LastError string         `json:"lastError"`
+}
+
+// scheduler polls a list of endpoints, so their responses are always cached for the clients.
+// The interval spreads a share of the remaining quota evenly until the daily reset.
+type scheduler struct {
+	handler *Handler
+	reload  chan struct{}
+
+	mu     sync.Mutex
+	config schedulerConfig
+	status schedulerStatus
+}
+
+func newScheduler(handler *Handler) *scheduler {
+	return &scheduler{
+		handler: handler,
+		reload:  make(chan struct{}, 1),
+		config:  schedulerConfig{quotaShare: defaultPollQuotaShare, hourlyRates: defaultHourlyRates},
+	}
+}
+
+// loadSettings applies the polling configuration. If the endpoints changed, the next round starts right away,
+// other changes apply from the next round.
+func (s *scheduler) loadSettings(settings *core.Record) error {
+	cfg, err := loadSchedulerConfig(settings)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	changed := !slices.Equal(s.config.endpoints, cfg.endpoints)
+	s.config = cfg
+	s.mu.Unlock()
+
+	if changed {
+		select {
+		case s.reload <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// run polls the endpoints until the context is canceled.
+func (s *scheduler) run(ctx context.Context) {
+	for {
+		s.mu.Lock()
+		cfg := s.config
+		s.mu.Unlock()
+
+		var wait <-chan time.Time
+		if len(cfg.endpoints) > 0 {
+			wait = time.After(s.round(ctx, &cfg))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.reload:
+		case <-wait:
+		}
+	}
+}
+
+// round polls the endpoints if the quota and the hourly rate allow it and returns the time until the next round.
+func (s *scheduler) round(ctx context.Context, cfg *schedulerConfig) time.Duration {
+	interval, poll, err := s.interval(cfg, time.Now())
+	if err != nil {
+		s.handler.app.Logger().Error("failed to calculate poll interval", "error", err)
+		return time.Hour
+	}
+
+	if !poll {
+		s.mu.Lock()
+		s.status.Interval = interval.Seconds()
+		s.status.NextPoll = types.NowDateTime().Add(interval)
+		s.mu.Unlock()
+		return interval
+	}
+
+	s.poll(ctx, cfg.endpoints, interval)
+	return interval
+}
+
+// poll fetches all endpoints and keeps their responses cached until after the next round.
+func (s *scheduler) poll(ctx context.Context, endpoints []string, interval time.Duration) {
+	warmUntil := time.Now().Add(interval + warmMargin)
+
+	var errs []string
+	for _, endpoint := range endpoints {
+		resp, err := s.handler.do(ctx, http.MethodGet, endpoint, nil, warmUntil)
+		if err == nil && resp.Status != http.StatusOK {
+			err = fmt.Errorf("tado returned %d", resp.Status)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.handler.app.Logger().Warn("failed to poll endpoint", "endpoint", endpoint, "error", err)
+			errs = append(errs, endpoint+": "+err.Error())
+		}
+	}
+
+	s.mu.Lock()
+	s.status = schedulerStatus{
+		Interval:  interval.Seconds(),
+		LastPoll:  types.NowDateTime(),
+		NextPoll:  types.NowDateTime().Add(interval),
+		LastError: strings.Join(errs, "; "),
+	}
+	s.mu.Unlock()
+}
+
+// interval returns the time until the next round of requests and whether to poll now. A share of the
+// remaining pooled quota is spread over the time until the reset, weighted by the hourly rates, so the
+// quota is never used up before the reset. Each interval gets some jitter. If the quota is too low or
+// polling is paused this hour, it returns the time until polling may resume and false.
+func (s *scheduler) interval(cfg *schedulerConfig, now time.Time) (time.Duration, bool, error) {
+	cutoff, err := tokens.GetRatelimitCutoff()
+	if err != nil {
+		return 0, false, err
+	}
+	reset := cutoff.Add(24 * time.Hour)
+
+	// the hourly rates follow the day of the reset, not the one of the server
+	loc, err := tokens.RatelimitLocation()
+	if err != nil {
+		return 0, false, err
+	}
+	now = now.In(loc)
+
+	limit, used, err := s.handler.Quota()
+	if err != nil {
+		return 0, false, err
+	}
+
+	rounds := float64(limit-used) * cfg.quotaShare / 100 / float64(len(cfg.endpoints))
+	if rounds < 1 {
+		s.handler.app.Logger().Warn("quota too low for polling, waiting for the reset", "reset", reset)
+		return reset.Sub(now) + warmMargin, false, nil
+	}
+
+	rate := cfg.rate(now)
+	if rate == 0 {
+		// polling is paused this hour
+		return nextHour(now).Sub(now), false, nil
+	}
+
+	interval := float64(cfg.weightedDuration(now, reset)) / rounds / rate
+	interval *= 1 + pollJitter*(2*rand.Float64()-1)
+
+	return max(time.Duration(interval), minPollInterval), true, nil
+}
+
+// HandleSchedulerRequest returns the state of the polling scheduler. It requires superuser authentication.
+func (h *Handler) HandleSchedulerRequest(e *core.RequestEvent) error {
+	h.scheduler.mu.Lock()
+	status := h.scheduler.status
+	status.Endpoints = append([]string{}, h.scheduler.config.endpoints...)
+	h.scheduler.mu.Unlock()
+
+	return e.JSON(http.StatusOK, status)
+}
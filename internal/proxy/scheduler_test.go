@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+func newTestSettings(data map[string]any) *core.Record {
+	settings := core.NewRecord(core.NewBaseCollection("settings"))
+	settings.Load(data)
+	return settings
+}
+
+func TestLoadSchedulerConfig(t *testing.T) {
+	cfg, err := loadSchedulerConfig(newTestSettings(nil))
+	if err != nil {
+		t.Fatalf("loadSchedulerConfig() error = %v", err)
+	}
+	if cfg.quotaShare != defaultPollQuotaShare || cfg.hourlyRates != defaultHourlyRates || cfg.endpoints != nil {
+		t.Errorf("loadSchedulerConfig() = %+v, want the defaults", cfg)
+	}
+
+	cfg, err = loadSchedulerConfig(newTestSettings(map[string]any{
+		"pollQuotaShare": 20,
+		"pollEndpoints":  `[" /api/v2/homes/1/zoneStates ", ""]`,
+	}))
+	if err != nil {
+		t.Fatalf("loadSchedulerConfig() error = %v", err)
+	}
+	if cfg.quotaShare != 20 || len(cfg.endpoints) != 1 || cfg.endpoints[0] != "/api/v2/homes/1/zoneStates" {
+		t.Errorf("loadSchedulerConfig() = %+v, want a share of 20 and the trimmed endpoint", cfg)
+	}
+
+	invalid := []map[string]any{
+		{"pollEndpoints": `["/api/v1/me"]`},
+		{"pollHourlyRates": `[1, 1]`},
+		{"pollHourlyRates": `{"0": 1}`},
+	}
+	for _, data := range invalid {
+		if _, err := loadSchedulerConfig(newTestSettings(data)); err == nil {
+			t.Errorf("loadSchedulerConfig(%v) succeeded", data)
+		}
+	}
+}
+
+func TestSchedulerRatesFollowResetTimeZone(t *testing.T) {
+	berlin, err := tokens.RatelimitLocation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := schedulerConfig{hourlyRates: defaultHourlyRates}
+
+	// 23:30 UTC is 0:30 in Berlin in winter, a night hour of the reset's day
+	at := time.Date(2026, 1, 15, 23, 30, 0, 0, time.UTC)
+	if got := cfg.rate(at.In(berlin)); got != 0.25 {
+		t.Errorf("rate() = %v, want the night rate 0.25", got)
+	}
+
+	// 22:00 until 0:00 UTC are the Berlin hours 23 (half the rate) and 0 (a quarter)
+	from := time.Date(2026, 1, 15, 22, 0, 0, 0, time.UTC)
+	to := from.Add(2 * time.Hour)
+	if got, want := cfg.weightedDuration(from.In(berlin), to), 45*time.Minute; got != want {
+		t.Errorf("weightedDuration() = %v, want %v", got, want)
+	}
+}
+
+func TestSchedulerIntervalSpreadsQuota(t *testing.T) {
+	p := newTestProxy(t)
+	cfg := schedulerConfig{
+		endpoints:   []string{"/api/v2/homes/1/zoneStates", "/api/v2/homes/1/weather"},
+		quotaShare:  50,
+		hourlyRates: defaultHourlyRates,
+	}
+
+	berlin, err := tokens.RatelimitLocation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cutoff, err := tokens.GetRatelimitCutoff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().In(berlin)
+
+	interval, poll, err := p.handler.scheduler.interval(&cfg, now)
+	if err != nil {
+		t.Fatalf("interval() error = %v", err)
+	}
+	if !poll {
+		t.Errorf("interval() = false, want to poll")
+	}
+
+	// half of the two tokens' quota spread over the weighted time until the reset
+	rounds := float64(2*p.server.DailyLimit) / 2 / 2
+	want := float64(cfg.weightedDuration(now, cutoff.Add(24*time.Hour))) / rounds / cfg.rate(now)
+	low, high := time.Duration(want*(1-pollJitter)), time.Duration(want*(1+pollJitter))
+	if interval < max(low, minPollInterval) || interval > max(high, minPollInterval) {
+		t.Errorf("interval() = %v, want between %v and %v", interval, low, high)
+	}
+}
+
+func TestSchedulerRoundSkipsPolling(t *testing.T) {
+	p := newTestProxy(t)
+
+	pausedRates := schedulerConfig{
+		endpoints:  []string{zonesPath},
+		quotaShare: 50,
+	}
+	// half a round of the pooled quota
+	lowQuota := schedulerConfig{
+		endpoints:   []string{zonesPath},
+		quotaShare:  100 / float64(2*p.server.DailyLimit) / 2,
+		hourlyRates: defaultHourlyRates,
+	}
+
+	tests := []struct {
+		name string
+		cfg  schedulerConfig
+	}{
+		{"paused hour", pausedRates},
+		{"quota too low", lowQuota},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			interval := p.handler.scheduler.round(context.Background(), &tt.cfg)
+			if interval <= 0 {
+				t.Errorf("round() = %v, want to wait", interval)
+			}
+			if n := len(p.upstreamRequests(zonesPath)); n != 0 {
+				t.Errorf("%d upstream requests, want none", n)
+			}
+		})
+	}
+}
+
+func TestSchedulerRequiresSuperuser(t *testing.T) {
+	anonymous := newRouteScenario("without authentication", "/api/scheduler", false, nil)
+	anonymous.ExpectedStatus = http.StatusUnauthorized
+	anonymous.ExpectedContent = []string{`"data":{}`}
+	anonymous.Test(t)
+
+	superuser := newRouteScenario("as superuser", "/api/scheduler", true, nil)
+	superuser.ExpectedStatus = http.StatusOK
+	superuser.ExpectedContent = []string{`"endpoints":[]`}
+	superuser.Test(t)
+}
+
+func TestPollWarmsCacheWithoutCacheRule(t *testing.T) {
+	p := newTestProxy(t)
+	// the zones of a home have no cache rule
+	if ttl := p.handler.cache.ttl(zonesPath); ttl != 0 {
+		t.Fatalf("ttl(%q) = %v, want no cache rule", zonesPath, ttl)
+	}
+
+	p.handler.scheduler.poll(context.Background(), []string{zonesPath}, time.Minute)
+
+	response, err := p.handler.Do(context.Background(), http.MethodGet, zonesPath, nil)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if response.Status != http.StatusOK || response.Header.Get("X-Cache") != "HIT" {
+		t.Errorf("Do() status = %d, X-Cache = %q, want a 200 from the cache",
+			response.Status, response.Header.Get("X-Cache"))
+	}
+	if n := len(p.upstreamRequests(zonesPath)); n != 1 {
+		t.Errorf("%d upstream requests, want only the poll", n)
+	}
+}
+
+func TestPollWarmsCacheForAccountScopes(t *testing.T) {
+	p := newTestProxy(t)
+	p.handler.scheduler.poll(context.Background(), []string{zonesPath}, time.Minute)
+
+	get := func(email string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, zonesPath, nil)
+		request.Header.Set("X-Tado-Email", email)
+		recorder := httptest.NewRecorder()
+
+		e := &core.RequestEvent{App: p.app}
+		e.Request = request
+		e.Response = recorder
+		if err := p.handler.performProxyRequest(e, zonesPath, nil, time.Time{}); err != nil {
+			t.Errorf("performProxyRequest(%s) error = %v", email, err)
+		}
+		return recorder
+	}
+
+	// the account can access the home, so it reads the polled response
+	if recorder := get(testEmail); recorder.Code != http.StatusOK || recorder.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("status = %d, X-Cache = %q, want a 200 from the cache", recorder.Code, recorder.Header().Get("X-Cache"))
+	}
+	if n := len(p.upstreamRequests(zonesPath)); n != 1 {
+		t.Errorf("%d upstream requests, want only the poll", n)
+	}
+}
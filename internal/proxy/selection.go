@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// selectionStrategy decides in which order the usable tokens are tried for a request.
+type selectionStrategy string
+
+const (
+	// strategyPriority prefers tokens with a higher weight, then device code tokens, then the least recently used ones.
+	strategyPriority selectionStrategy = "priority"
+	// strategyLeastUsed prefers the tokens that used the smallest share of their daily limit, relative to their weight.
+	strategyLeastUsed selectionStrategy = "leastUsed"
+	// strategyWeightedRoundRobin takes turns between the tokens, as often as their weight times their remaining quota.
+	strategyWeightedRoundRobin selectionStrategy = "weightedRoundRobin"
+	// strategyWeightedRandom picks the tokens randomly, as likely as their weight.
+	strategyWeightedRandom selectionStrategy = "weightedRandom"
+	// strategySpreadAccounts prefers the accounts that made the fewest requests, relative to their weight.
+	strategySpreadAccounts selectionStrategy = "spreadAccounts"
+)
+
+// tokenWeights are the selection weights from the tokenWeights settings field, by client and account ID.
+// Missing weights are 1. Tokens with a weight of 0 are a last resort, only tried if all other tokens fail.
+type tokenWeights struct {
+	Clients  map[string]float64 `json:"clients"`
+	Accounts map[string]float64 `json:"accounts"`
+}
+
+// of returns the weight of a token, the product of the weights of its client and account.
+func (w tokenWeights) of(t tokenWithClient) float64 {
+	weight := 1.0
+	if clientWeight, ok := w.Clients[t.client.Id]; ok {
+		weight *= max(clientWeight, 0)
+	}
+	if accountWeight, ok := w.Accounts[t.token.GetString("account")]; ok {
+		weight *= max(accountWeight, 0)
+	}
+	return weight
+}
+
+// tokenSelector orders the usable tokens of a request by the strategy from the settings.
+type tokenSelector struct {
+	mu       sync.Mutex
+	strategy selectionStrategy
+	weights  tokenWeights
+	// current is the state of the weighted round robin by token ID
+	current map[string]float64
+}
+
+func newTokenSelector() *tokenSelector {
+	return &tokenSelector{
+		strategy: strategyPriority,
+		current:  make(map[string]float64),
+	}
+}
+
+// loadSettings reads the strategy and weights from the settings record.
+func (s *tokenSelector) loadSettings(settings *core.Record) error {
+	strategy := selectionStrategy(settings.GetString("tokenStrategy"))
+	if strategy == "" {
+		strategy = strategyPriority
+	}
+
+	var weights tokenWeights
+	if settings.GetString("tokenWeights") != "" {
+		if err := settings.UnmarshalJSONField("tokenWeights", &weights); err != nil {
+			return fmt.Errorf("invalid tokenWeights: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.strategy = strategy
+	s.weights = weights
+	return nil
+}
+
+// order returns the tokens in the order they should be tried and the strategy it used.
+func (s *tokenSelector) order(tokens []tokenWithClient) ([]tokenWithClient, selectionStrategy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// tokens were found ordered by their last use, which breaks all ties
+	tokens = slices.Clone(tokens)
+	slices.SortStableFunc(tokens, comparePriority)
+
+	// last resort tokens go to the end, in priority order
+	weights := make(map[string]float64, len(tokens))
+	var candidates, lastResort []tokenWithClient
+	for _, t := range tokens {
+		weights[t.token.Id] = s.weights.of(t)
+		if weights[t.token.Id] == 0 {
+			lastResort = append(lastResort, t)
+		} else {
+			candidates = append(candidates, t)
+		}
+	}
+
+	switch s.strategy {
+	case strategyLeastUsed:
+		slices.SortStableFunc(candidates, func(a, b tokenWithClient) int {
+			return cmp.Compare(usedRatio(a)/weights[a.token.Id], usedRatio(b)/weights[b.token.Id])
+		})
+	case strategyWeightedRoundRobin:
+		s.roundRobin(candidates, weights)
+	case strategyWeightedRandom:
+		// Efraimidis-Spirakis: sorting by an exponential key with the weight as rate draws without replacement
+		keys := make(map[string]float64, len(candidates))
+		for _, t := range candidates {
+			keys[t.token.Id] = rand.ExpFloat64() / weights[t.token.Id]
+		}
+		slices.SortStableFunc(candidates, func(a, b tokenWithClient) int {
+			return cmp.Compare(keys[a.token.Id], keys[b.token.Id])
+		})
+	case strategySpreadAccounts:
+		accountUsed := map[string]int{}
+		accountWeight := map[string]float64{}
+		for _, t := range candidates {
+			account := t.token.GetString("account")
+			accountUsed[account] += t.used
+			accountWeight[account] = max(accountWeight[account], weights[t.token.Id])
+		}
+		slices.SortStableFunc(candidates, func(a, b tokenWithClient) int {
+			accountA, accountB := a.token.GetString("account"), b.token.GetString("account")
+			return cmp.Compare(
+				float64(accountUsed[accountA])/accountWeight[accountA],
+				float64(accountUsed[accountB])/accountWeight[accountB],
+			)
+		})
+	default:
+		slices.SortStableFunc(candidates, func(a, b tokenWithClient) int {
+			return cmp.Compare(weights[b.token.Id], weights[a.token.Id])
+		})
+	}
+
+	return append(candidates, lastResort...), s.strategy
+}
+
+// roundRobin moves the next token of the smooth weighted round robin to the front and orders the
+// others by their weight. The weight of a token is its configured weight times its remaining quota.
+// The caller must hold the mutex.
+func (s *tokenSelector) roundRobin(candidates []tokenWithClient, weights map[string]float64) {
+	if len(candidates) == 0 {
+		return
+	}
+
+	// drop the state of tokens that were deleted, disabled or can't be used right now
+	ids := make(map[string]bool, len(candidates))
+	for _, t := range candidates {
+		ids[t.token.Id] = true
+	}
+	maps.DeleteFunc(s.current, func(id string, _ float64) bool {
+		return !ids[id]
+	})
+
+	effective := make(map[string]float64, len(candidates))
+	var total float64
+	for _, t := range candidates {
+		remaining := max(t.client.GetInt("dailyLimit")-t.used, 0)
+		effective[t.token.Id] = weights[t.token.Id] * float64(remaining)
+		total += effective[t.token.Id]
+	}
+
+	next := 0
+	for i, t := range candidates {
+		s.current[t.token.Id] += effective[t.token.Id]
+		if s.current[t.token.Id] > s.current[candidates[next].token.Id] {
+			next = i
+		}
+	}
+	s.current[candidates[next].token.Id] -= total
+
+	first := candidates[next]
+	rest := slices.Delete(slices.Clone(candidates), next, next+1)
+	slices.SortStableFunc(rest, func(a, b tokenWithClient) int {
+		return cmp.Compare(effective[b.token.Id], effective[a.token.Id])
+	})
+
+	candidates[0] = first
+	copy(candidates[1:], rest)
+}
+
+// comparePriority orders device code tokens before the others. The official API
+// has its own limit, so it is used before the limit of the app clients.
+func comparePriority(a, b tokenWithClient) int {
+	return cmp.Compare(priorityRank(a), priorityRank(b))
+}
+
+func priorityRank(t tokenWithClient) int {
+	if t.client.GetString("type") == "deviceCode" {
+		return 0
+	}
+	return 1
+}
+
+// usedRatio returns the share of its daily limit the token used.
+func usedRatio(t tokenWithClient) float64 {
+	limit := t.client.GetInt("dailyLimit")
+	if limit <= 0 {
+		return math.Inf(1)
+	}
+	return float64(t.used) / float64(limit)
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// newTestToken returns a token of the account with a client of the type and limit, which used the given requests.
+func newTestToken(id, account, clientType string, limit, used int) tokenWithClient {
+	client := core.NewRecord(core.NewBaseCollection("clients"))
+	client.Id = id + "-client"
+	client.Set("type", clientType)
+	client.Set("dailyLimit", limit)
+
+	token := core.NewRecord(core.NewBaseCollection("tokens"))
+	token.Id = id
+	token.Set("account", account)
+	token.Set("client", client.Id)
+
+	return tokenWithClient{client: client, token: token, used: used}
+}
+
+func newTestSelector(strategy selectionStrategy, weights tokenWeights) *tokenSelector {
+	s := newTokenSelector()
+	s.strategy = strategy
+	s.weights = weights
+	return s
+}
+
+func tokenIDs(tokens []tokenWithClient) []string {
+	ids := make([]string, len(tokens))
+	for i, t := range tokens {
+		ids[i] = t.token.Id
+	}
+	return ids
+}
+
+func TestSelectionStrategies(t *testing.T) {
+	a := newTestToken("a", "account1", "passwordGrant", 1000, 500)
+	b := newTestToken("b", "account1", "passwordGrant", 1000, 100)
+	c := newTestToken("c", "account2", "passwordGrant", 1000, 300)
+	d := newTestToken("d", "account2", "deviceCode", 100, 90)
+
+	tests := []struct {
+		name     string
+		strategy selectionStrategy
+		weights  tokenWeights
+		want     []string
+	}{
+		{
+			name:     "priority keeps the order after device code tokens",
+			strategy: strategyPriority,
+			want:     []string{"d", "a", "b", "c"},
+		},
+		{
+			name:     "priority prefers higher weights",
+			strategy: strategyPriority,
+			weights:  tokenWeights{Accounts: map[string]float64{"account2": 2}},
+			want:     []string{"d", "c", "a", "b"},
+		},
+		{
+			name:     "least used by share of the limit",
+			strategy: strategyLeastUsed,
+			want:     []string{"b", "c", "a", "d"},
+		},
+		{
+			name:     "least used relative to the weight",
+			strategy: strategyLeastUsed,
+			weights:  tokenWeights{Clients: map[string]float64{"a-client": 10}},
+			want:     []string{"a", "b", "c", "d"},
+		},
+		{
+			name:     "spread accounts prefers the account with fewer requests",
+			strategy: strategySpreadAccounts,
+			want:     []string{"d", "c", "a", "b"},
+		},
+		{
+			name:     "tokens with a weight of 0 are tried last in priority order",
+			strategy: strategyLeastUsed,
+			weights:  tokenWeights{Accounts: map[string]float64{"account1": 0}},
+			want:     []string{"c", "d", "a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSelector(tt.strategy, tt.weights)
+
+			got, strategy := s.order([]tokenWithClient{a, b, c, d})
+			if strategy != tt.strategy {
+				t.Errorf("strategy = %q, want %q", strategy, tt.strategy)
+			}
+			if ids := tokenIDs(got); !slices.Equal(ids, tt.want) {
+				t.Errorf("order() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeightedRandomKeepsAllTokens(t *testing.T) {
+	s := newTestSelector(strategyWeightedRandom, tokenWeights{
+		Clients: map[string]float64{"a-client": 0, "b-client": 5},
+	})
+	tokens := []tokenWithClient{
+		newTestToken("a", "account1", "passwordGrant", 1000, 0),
+		newTestToken("b", "account1", "passwordGrant", 1000, 0),
+		newTestToken("c", "account1", "passwordGrant", 1000, 0),
+	}
+
+	first := map[string]int{}
+	for range 1000 {
+		got, _ := s.order(tokens)
+		ids := tokenIDs(got)
+
+		if len(ids) != 3 || ids[2] != "a" {
+			t.Fatalf("order() = %v, want all tokens with the last resort token a at the end", ids)
+		}
+		first[ids[0]]++
+	}
+
+	// b is five times as likely as c, so it must come first far more often
+	if first["b"] < 2*first["c"] {
+		t.Errorf("first picks = %v, want b clearly more often than c", first)
+	}
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	s := newTestSelector(strategyWeightedRoundRobin, tokenWeights{
+		Clients: map[string]float64{"a-client": 3},
+	})
+	tokens := []tokenWithClient{
+		newTestToken("a", "account1", "passwordGrant", 1000, 0),
+		newTestToken("b", "account1", "passwordGrant", 1000, 0),
+	}
+
+	first := map[string]int{}
+	for range 400 {
+		got, _ := s.order(tokens)
+		if len(got) != 2 {
+			t.Fatalf("order() returned %d tokens, want 2", len(got))
+		}
+		first[got[0].token.Id]++
+	}
+
+	// the smooth round robin follows the weights exactly
+	if first["a"] != 300 || first["b"] != 100 {
+		t.Errorf("first picks = %v, want a 300 and b 100 times", first)
+	}
+}
+
+func TestWeightedRoundRobinPrunesRemovedTokens(t *testing.T) {
+	s := newTestSelector(strategyWeightedRoundRobin, tokenWeights{})
+	a := newTestToken("a", "account1", "passwordGrant", 1000, 0)
+	b := newTestToken("b", "account1", "passwordGrant", 1000, 0)
+
+	s.order([]tokenWithClient{a, b})
+	if _, ok := s.current["b"]; !ok {
+		t.Fatal("round robin has no state for b")
+	}
+
+	// b was deleted or disabled
+	s.order([]tokenWithClient{a})
+	if _, ok := s.current["b"]; ok {
+		t.Error("round robin kept the state of the removed token b")
+	}
+	if len(s.current) != 1 {
+		t.Errorf("round robin has state for %d tokens, want 1", len(s.current))
+	}
+}
@@ -1,65 +1,329 @@
 package proxy
 
 import (
+	"cmp"
+	"fmt"
+	"slices"
 	"time"
 
 	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
 )
 
-// StatsResponse represents the JSON response format for the legacy stats endpoint
+// statsBucket is the length of the buckets of the time series.
+type statsBucket string
+
+const (
+	bucketMinute statsBucket = "minute"
+	bucketHour   statsBucket = "hour"
+	bucketDay    statsBucket = "day"
+)
+
+// statsBuckets are the strftime formats and sizes of the buckets. Days are UTC days.
+var statsBuckets = map[statsBucket]struct {
+	format string
+	size   time.Duration
+}{
+	bucketMinute: {minuteFormat, time.Minute},
+	bucketHour:   {hourFormat, time.Hour},
+	bucketDay:    {dayFormat, 24 * time.Hour},
+}
+
+// statsGroups return the group of the requests of a rollup for the groupBy parameter.
+// The groups by record ID reveal the traffic of single accounts, see privateStatsGroups.
+var statsGroups = map[string]func(key rollupKey) string{
+	"account":  func(key rollupKey) string { return key.account },
+	"client":   func(key rollupKey) string { return key.client },
+	"token":    func(key rollupKey) string { return key.token },
+	"status":   func(key rollupKey) string { return key.statusClass },
+	"endpoint": func(key rollupKey) string { return key.endpoint },
+}
+
+// privateStatsGroups are the groupBy values that require superuser authentication.
+var privateStatsGroups = map[string]bool{
+	"account": true,
+	"client":  true,
+	"token":   true,
+}
+
+// maxStatsBuckets limits the length of the time series.
+const maxStatsBuckets = 5000
+
+// StatsResponse represents the JSON response format for the stats endpoint
 type StatsResponse struct {
+	// Today, LastHour and Last24Hours are kept for the legacy stats endpoint. Today starts at local midnight.
 	Today       int `json:"today"`
 	LastHour    int `json:"last_hour"`
 	Last24Hours int `json:"last_24_hours"`
+	// The long ranges are read from the hourly rollups, since the requests are deleted after the request log retention
+	Last30Days  int `json:"last_30_days"`
+	Last365Days int `json:"last_365_days"`
+	// SinceQuotaReset counts the requests that used a token's quota since the last rate limit reset
+	SinceQuotaReset int       `json:"since_quota_reset"`
+	QuotaReset      time.Time `json:"quota_reset"`
+	// Endpoints break down the requests since the last rate limit reset by endpoint template
+	Endpoints []EndpointStats `json:"endpoints"`
+
+	// The time series of the from, to, bucket and groupBy parameters
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Bucket  statsBucket   `json:"bucket"`
+	GroupBy string        `json:"group_by,omitempty"`
+	Buckets []time.Time   `json:"buckets"`
+	Series  []StatsSeries `json:"series"`
+}
+
+// StatsSeries are the requests of a group in each bucket of the time series.
+type StatsSeries struct {
+	Group  string `json:"group"`
+	Total  int    `json:"total"`
+	Counts []int  `json:"counts"`
 }
 
-// HandleStatsRequest handles GET /api/stats without authentication
+// EndpointStats are the requests of an endpoint template.
+type EndpointStats struct {
+	Endpoint  string  `json:"endpoint"`
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	// QuotaUsed is the number of requests that counted towards the token limits,
+	// QuotaShare their share of all requests that did
+	QuotaUsed  int     `json:"quota_used"`
+	QuotaShare float64 `json:"quota_share"`
+}
+
+// HandleStatsRequest handles GET /api/stats without authentication,
+// except for grouping by account, client or token
 func (h *Handler) HandleStatsRequest(e *core.RequestEvent) error {
 	now := time.Now()
+	query := e.Request.URL.Query()
+
+	response := StatsResponse{
+		To:      now,
+		Bucket:  statsBucket(cmp.Or(query.Get("bucket"), string(bucketHour))),
+		GroupBy: query.Get("groupBy"),
+	}
+
+	bucket, ok := statsBuckets[response.Bucket]
+	if !ok {
+		return e.BadRequestError("bucket must be minute, hour or day", nil)
+	}
+	if _, ok := statsGroups[response.GroupBy]; !ok && response.GroupBy != "" {
+		return e.BadRequestError("groupBy must be account, client, token, status or endpoint", nil)
+	}
+	if privateStatsGroups[response.GroupBy] && !e.HasSuperuserAuth() {
+		return e.UnauthorizedError("grouping by "+response.GroupBy+" requires superuser authentication", nil)
+	}
+	if to := query.Get("to"); to != "" {
+		parsed, err := types.ParseDateTime(to)
+		if err != nil || parsed.IsZero() {
+			return e.BadRequestError("invalid to", err)
+		}
+		response.To = parsed.Time()
+	}
+	response.From = response.To.Add(-24 * time.Hour)
+	if from := query.Get("from"); from != "" {
+		parsed, err := types.ParseDateTime(from)
+		if err != nil || parsed.IsZero() {
+			return e.BadRequestError("invalid from", err)
+		}
+		response.From = parsed.Time()
+	}
+	if !response.From.Before(response.To) {
+		return e.BadRequestError("from must be before to", nil)
+	}
+
+	// the time is truncated since the zero time, so days start at UTC midnight
+	response.From = response.From.Truncate(bucket.size).UTC()
+	response.To = response.To.UTC()
+	if response.To.Sub(response.From)/bucket.size >= maxStatsBuckets {
+		return e.BadRequestError(fmt.Sprintf("too many buckets, the maximum is %d", maxStatsBuckets), nil)
+	}
+
+	cutoff, err := tokens.GetRatelimitCutoff()
+	if err != nil {
+		return err
+	}
+	response.QuotaReset = cutoff
 
 	// Calculate start of today in local time
 	y, m, d := now.Local().Date()
 	todayStart := time.Date(y, m, d, 0, 0, 0, 0, now.Local().Location())
 
-	lastHour := now.Add(-time.Hour)
-	last24Hours := now.Add(-24 * time.Hour)
+	// today and the last quota reset are within the last 24 hours
+	err = h.app.DB().NewQuery(`
+		SELECT
+			coalesce(sum(created > {:today}), 0) AS today,
+			coalesce(sum(created > {:lastHour}), 0) AS lastHour,
+			count(*) AS last24Hours,
+			coalesce(sum(created > {:quotaReset} AND coalesced = false AND token != ''), 0) AS sinceQuotaReset
+		FROM requests
+		WHERE created > {:last24Hours}
+	`).Bind(dbx.Params{
+		"today":       todayStart.UTC(),
+		"lastHour":    now.Add(-time.Hour).UTC(),
+		"last24Hours": now.Add(-24 * time.Hour).UTC(),
+		"quotaReset":  cutoff,
+	}).Row(&response.Today, &response.LastHour, &response.Last24Hours, &response.SinceQuotaReset)
+	if err != nil {
+		return err
+	}
 
-	var today, hourCount, dayCount int
+	response.Last30Days, err = h.rollups.countSince(now.Add(-30 * 24 * time.Hour))
+	if err != nil {
+		return err
+	}
 
-	// Count requests from today
-	err := h.app.DB().NewQuery(
-		"SELECT count(*) FROM requests WHERE created > {:cutoff}",
-	).Bind(dbx.Params{
-		"cutoff": todayStart.UTC(),
-	}).Row(&today)
+	response.Last365Days, err = h.rollups.countSince(now.Add(-365 * 24 * time.Hour))
 	if err != nil {
 		return err
 	}
 
-	// Count requests from the last hour
-	err = h.app.DB().NewQuery(
-		"SELECT count(*) FROM requests WHERE created > {:cutoff}",
-	).Bind(dbx.Params{
-		"cutoff": lastHour.UTC(),
-	}).Row(&hourCount)
+	response.Endpoints, err = h.endpointStats(cutoff)
 	if err != nil {
 		return err
 	}
 
-	// Count requests from the last 24 hours
-	err = h.app.DB().NewQuery(
-		"SELECT count(*) FROM requests WHERE created > {:cutoff}",
-	).Bind(dbx.Params{
-		"cutoff": last24Hours.UTC(),
-	}).Row(&dayCount)
+	response.Buckets, response.Series, err = h.statsSeries(response.From, response.To, response.Bucket, response.GroupBy)
 	if err != nil {
 		return err
 	}
 
-	return e.JSON(200, StatsResponse{
-		Today:       today,
-		LastHour:    hourCount,
-		Last24Hours: dayCount,
+	return e.JSON(200, response)
+}
+
+// statsSeries counts the requests in each bucket from the start of a bucket until the given time,
+// by group if groupBy is set. The time that was rolled up is read from the hourly rollups,
+// unless the buckets are shorter than an hour.
+func (h *Handler) statsSeries(from, to time.Time, bucket statsBucket, groupBy string) ([]time.Time, []StatsSeries, error) {
+	format, size := statsBuckets[bucket].format, statsBuckets[bucket].size
+
+	var buckets []time.Time
+	index := map[string]int{}
+	for t := from; t.Before(to); t = t.Add(size) {
+		index[formatBucket(t)] = len(buckets)
+		buckets = append(buckets, t)
+	}
+
+	watermark, err := h.rollups.watermark()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recentFrom := from
+	rolledUp := map[rollupKey]rollupCounts{}
+	if bucket != bucketMinute && watermark.After(from) {
+		recentFrom = watermark
+		rolledUntil := watermark
+		if to.Before(rolledUntil) {
+			rolledUntil = to
+		}
+		rolledUp, err = h.rollups.aggregateRollups(format, formatBucket(from), formatBucket(rolledUntil))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	recent := map[rollupKey]rollupCounts{}
+	if recentFrom.Before(to) {
+		recent, err = h.rollups.aggregateRequests(format, formatBucket(recentFrom), formatBucket(to))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	group := statsGroups[groupBy]
+	byGroup := map[string]*StatsSeries{}
+	for _, aggregated := range []map[rollupKey]rollupCounts{rolledUp, recent} {
+		for key, counts := range aggregated {
+			i, ok := index[key.bucket]
+			if !ok {
+				continue
+			}
+
+			name := ""
+			if group != nil {
+				name = group(key)
+			}
+			series, ok := byGroup[name]
+			if !ok {
+				series = &StatsSeries{Group: name, Counts: make([]int, len(buckets))}
+				byGroup[name] = series
+			}
+			series.Counts[i] += counts.Count
+			series.Total += counts.Count
+		}
+	}
+
+	series := make([]StatsSeries, 0, len(byGroup))
+	for _, s := range byGroup {
+		series = append(series, *s)
+	}
+	slices.SortFunc(series, func(a, b StatsSeries) int {
+		return cmp.Or(cmp.Compare(b.Total, a.Total), cmp.Compare(a.Group, b.Group))
+	})
+
+	return buckets, series, nil
+}
+
+// endpointStats counts the requests since the given time by endpoint template, most requested first.
+func (h *Handler) endpointStats(since time.Time) ([]EndpointStats, error) {
+	var rows []struct {
+		Endpoint  string `db:"endpoint"`
+		URL       string `db:"url"`
+		Requests  int    `db:"requests"`
+		Errors    int    `db:"errors"`
+		QuotaUsed int    `db:"quotaUsed"`
+	}
+	// requests logged before the endpoint was stored are grouped by their URL
+	err := h.app.DB().NewQuery(`
+		SELECT
+			endpoint,
+			CASE WHEN endpoint = '' THEN url ELSE '' END AS url,
+			count(*) AS requests,
+			sum(status >= 400) AS errors,
+			sum(coalesced = false AND token != '') AS quotaUsed
+		FROM requests
+		WHERE created > {:cutoff}
+		GROUP BY 1, 2
+	`).Bind(dbx.Params{
+		"cutoff": since,
+	}).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	byEndpoint := map[string]*EndpointStats{}
+	totalQuotaUsed := 0
+	for _, row := range rows {
+		if row.Endpoint == "" {
+			row.Endpoint = h.rollups.urlEndpoint(row.URL)
+		}
+
+		stats, ok := byEndpoint[row.Endpoint]
+		if !ok {
+			stats = &EndpointStats{Endpoint: row.Endpoint}
+			byEndpoint[row.Endpoint] = stats
+		}
+		stats.Requests += row.Requests
+		stats.Errors += row.Errors
+		stats.QuotaUsed += row.QuotaUsed
+		totalQuotaUsed += row.QuotaUsed
+	}
+
+	endpoints := make([]EndpointStats, 0, len(byEndpoint))
+	for _, stats := range byEndpoint {
+		stats.ErrorRate = float64(stats.Errors) / float64(stats.Requests)
+		if totalQuotaUsed > 0 {
+			stats.QuotaShare = float64(stats.QuotaUsed) / float64(totalQuotaUsed)
+		}
+		endpoints = append(endpoints, *stats)
+	}
+	slices.SortFunc(endpoints, func(a, b EndpointStats) int {
+		return cmp.Or(cmp.Compare(b.Requests, a.Requests), cmp.Compare(a.Endpoint, b.Endpoint))
 	})
+
+	return endpoints, nil
 }
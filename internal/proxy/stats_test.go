@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStatsSeriesCombinesRollupsAndRecentRequests(t *testing.T) {
+	p := newTestProxy(t)
+	base := rollupBase()
+
+	p.newRequest(t, base, nil)
+	p.newRequest(t, base.Add(time.Minute), nil)
+	p.newRequest(t, base.Add(time.Hour), map[string]any{"status": 500})
+	if err := p.handler.rollups.run(); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	// the rolled up requests are counted from the rollups after they are deleted
+	watermark, err := p.handler.rollups.watermark()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.handler.purgeRequests(watermark, -1); err != nil {
+		t.Fatal(err)
+	}
+	p.newRequest(t, time.Now(), nil)
+
+	// the end of the range is exclusive
+	from, to := base.Truncate(24*time.Hour), time.Now().Add(time.Second)
+	buckets, series, err := p.handler.statsSeries(from, to, bucketDay, "status")
+	if err != nil {
+		t.Fatalf("statsSeries() error = %v", err)
+	}
+	if len(buckets) != 3 || !buckets[0].Equal(from) {
+		t.Fatalf("buckets = %v, want the 3 days since %v", buckets, from)
+	}
+
+	want := []StatsSeries{
+		{Group: "2xx", Total: 3, Counts: []int{2, 0, 1}},
+		{Group: "5xx", Total: 1, Counts: []int{1, 0, 0}},
+	}
+	if !slices.EqualFunc(series, want, func(a, b StatsSeries) bool {
+		return a.Group == b.Group && a.Total == b.Total && slices.Equal(a.Counts, b.Counts)
+	}) {
+		t.Errorf("series = %+v, want %+v", series, want)
+	}
+}
+
+func TestStatsSeriesMinuteBuckets(t *testing.T) {
+	p := newTestProxy(t)
+	to := time.Now().UTC()
+	from := to.Add(-10 * time.Minute).Truncate(time.Minute)
+
+	p.newRequest(t, from, nil)
+	p.newRequest(t, from.Add(30*time.Second), nil)
+	p.newRequest(t, from.Add(5*time.Minute), map[string]any{"endpoint": "/homes/{homeId}/zoneStates"})
+	// requests before the range aren't counted
+	p.newRequest(t, from.Add(-time.Minute), nil)
+
+	buckets, series, err := p.handler.statsSeries(from, to, bucketMinute, "endpoint")
+	if err != nil {
+		t.Fatalf("statsSeries() error = %v", err)
+	}
+	if len(buckets) != 11 {
+		t.Fatalf("%d buckets, want 11", len(buckets))
+	}
+	if len(series) != 2 {
+		t.Fatalf("series = %+v, want 2 endpoints", series)
+	}
+	if s := series[0]; s.Group != "/homes/{homeId}/zones" || s.Total != 2 || s.Counts[0] != 2 {
+		t.Errorf("series[0] = %+v, want the 2 requests to the zones in the first minute", s)
+	}
+	if s := series[1]; s.Group != "/homes/{homeId}/zoneStates" || s.Total != 1 || s.Counts[5] != 1 {
+		t.Errorf("series[1] = %+v, want the request to the zone states in the sixth minute", s)
+	}
+}
+
+func TestStatsRequest(t *testing.T) {
+	from := rollupBase().Truncate(24 * time.Hour)
+	query := url.Values{"from": {formatBucket(from)}, "bucket": {"day"}, "groupBy": {"status"}}
+
+	scenario := newRouteScenario("daily series", "/api/stats?"+query.Encode(), false, func(t testing.TB, p *testProxy) {
+		p.newRequest(t, rollupBase(), nil)
+		p.newRequest(t, rollupBase(), nil)
+		if err := p.handler.rollups.run(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	scenario.ExpectedStatus = http.StatusOK
+	scenario.ExpectedContent = []string{
+		`"last_30_days":2`,
+		`"bucket":"day"`,
+		`"group_by":"status"`,
+		`"series":[{"group":"2xx","total":2,"counts":[2,0,0]}]`,
+	}
+	scenario.Test(t)
+}
+
+func TestStatsRequestValidatesParameters(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{"invalid bucket", url.Values{"bucket": {"week"}}},
+		{"invalid groupBy", url.Values{"groupBy": {"home"}}},
+		{"invalid from", url.Values{"from": {"yesterday"}}},
+		{"from after to", url.Values{"from": {formatBucket(now)}, "to": {formatBucket(now.Add(-time.Hour))}}},
+		{"too many buckets", url.Values{"from": {formatBucket(now.Add(-365 * 24 * time.Hour))}, "bucket": {"minute"}}},
+	}
+
+	for _, tt := range tests {
+		scenario := newRouteScenario(tt.name, "/api/stats?"+tt.query.Encode(), false, nil)
+		scenario.ExpectedStatus = http.StatusBadRequest
+		scenario.ExpectedContent = []string{`"status":400`}
+		scenario.Test(t)
+	}
+}
+
+func TestStatsPrivateGroupsRequireSuperuser(t *testing.T) {
+	for _, groupBy := range []string{"account", "client", "token"} {
+		anonymous := newRouteScenario("grouping by "+groupBy, "/api/stats?groupBy="+groupBy, false, nil)
+		anonymous.ExpectedStatus = http.StatusUnauthorized
+		anonymous.ExpectedContent = []string{`"data":{}`}
+		anonymous.Test(t)
+
+		superuser := newRouteScenario("grouping by "+groupBy+" as superuser", "/api/stats?groupBy="+groupBy, true, nil)
+		superuser.ExpectedStatus = http.StatusOK
+		superuser.ExpectedContent = []string{`"group_by":"` + groupBy + `"`}
+		superuser.Test(t)
+	}
+
+	public := newRouteScenario("grouping by endpoint", "/api/stats?groupBy=endpoint", false, nil)
+	public.ExpectedStatus = http.StatusOK
+	public.ExpectedContent = []string{`"group_by":"endpoint"`}
+	public.Test(t)
+}
@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+// usageCounter counts the requests of each token or API key since the last rate limit reset in memory,
+// so checking the quota doesn't count the requests table. It is seeded from the table at startup and
+// reconciled with it periodically, which also corrects requests that were counted twice or not at all.
+type usageCounter struct {
+	app core.App
+	// column is the field of the requests collection the requests are counted by
+	column string
+
+	mu sync.Mutex
+	// cutoff is the rate limit reset the counts belong to
+	cutoff time.Time
+	// reset is when the counts are checked for the next reset
+	reset  time.Time
+	counts map[string]int
+	// queued counts the requests whose log entry wasn't written yet, which reconcile can't find in the table
+	queued map[string]int
+	// unlogged counts the requests whose log entry was dropped, which reconcile can't find in the table
+	unlogged map[string]int
+}
+
+func newUsageCounter(app core.App, column string) *usageCounter {
+	return &usageCounter{
+		app:      app,
+		column:   column,
+		counts:   make(map[string]int),
+		queued:   make(map[string]int),
+		unlogged: make(map[string]int),
+	}
+}
+
+// reconcile replaces the counts with the requests logged since the last reset and the ones
+// that aren't in the table. No log entries may be written meanwhile, otherwise an entry
+// that is written during the reconciliation is counted twice or not at all.
+func (c *usageCounter) reconcile() error {
+	cutoff, err := tokens.GetRatelimitCutoff()
+	if err != nil {
+		return err
+	}
+
+	var rows []struct {
+		ID    string `db:"id"`
+		Count int    `db:"count"`
+	}
+	err = c.app.DB().NewQuery(
+		"SELECT [[" + c.column + "]] AS id, count(*) AS count FROM requests" +
+			" WHERE created > {:cutoff} AND coalesced = false AND token != '' AND [[" + c.column + "]] != ''" +
+			" GROUP BY [[" + c.column + "]]",
+	).Bind(dbx.Params{
+		"cutoff": cutoff,
+	}).All(&rows)
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.ID] = row.Count
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cutoff.After(c.cutoff) {
+		clear(c.queued)
+		clear(c.unlogged)
+	}
+	for id, n := range c.queued {
+		counts[id] += n
+	}
+	for id, n := range c.unlogged {
+		counts[id] += n
+	}
+
+	c.cutoff = cutoff
+	c.reset = cutoff.Add(24 * time.Hour)
+	c.counts = counts
+	return nil
+}
+
+// add counts a request of the token or API key, whose log entry is queued.
+func (c *usageCounter) add(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rollover()
+	c.counts[id]++
+	c.queued[id]++
+}
+
+// logged remembers that the queued entry of a request made at the time was written to the requests table.
+func (c *usageCounter) logged(id string, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rollover()
+	c.dequeue(id, at)
+}
+
+// addUnlogged remembers that the queued entry of a request made at the time was dropped,
+// so the request is missing in the requests table.
+func (c *usageCounter) addUnlogged(id string, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rollover()
+	if c.dequeue(id, at) {
+		c.unlogged[id]++
+	}
+}
+
+// dequeue removes a request made at the time from the queued ones. Requests before the
+// last reset were already cleared, which is reported by returning false.
+// The caller must hold the mutex.
+func (c *usageCounter) dequeue(id string, at time.Time) bool {
+	if at.Before(c.cutoff) {
+		return false
+	}
+
+	if c.queued[id] > 0 {
+		c.queued[id]--
+	}
+	return true
+}
+
+// get returns the number of requests of the token or API key since the last reset.
+func (c *usageCounter) get(id string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rollover()
+	return c.counts[id]
+}
+
+// rollover resets the counts once the rate limits reset. The caller must hold the mutex.
+func (c *usageCounter) rollover() {
+	now := time.Now()
+	if now.Before(c.reset) {
+		return
+	}
+
+	cutoff, err := tokens.GetRatelimitCutoff()
+	if err != nil || !cutoff.After(c.cutoff) {
+		// a day with a daylight saving change is an hour longer
+		c.reset = now.Add(time.Minute)
+		return
+	}
+
+	c.cutoff = cutoff
+	c.reset = cutoff.Add(24 * time.Hour)
+	clear(c.counts)
+	clear(c.queued)
+	clear(c.unlogged)
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/faketest"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+// insertRequest logs a request directly, like the request log writer.
+func insertRequest(t *testing.T, app core.App, tokenID, apiKeyID string, coalesced bool, created time.Time) {
+	t.Helper()
+
+	_, err := app.DB().Insert("requests", dbx.Params{
+		"id":        security.RandomString(15),
+		"token":     tokenID,
+		"apiKey":    apiKeyID,
+		"method":    "GET",
+		"url":       "https://my.tado.com/api/v2/me",
+		"coalesced": coalesced,
+		"created":   formatBucket(created),
+		"updated":   formatBucket(created),
+	}).Execute()
+	if err != nil {
+		t.Fatalf("failed to insert request: %v", err)
+	}
+}
+
+func TestUsageCounterReconcile(t *testing.T) {
+	app := faketest.NewApp(t)
+
+	cutoff, err := tokens.GetRatelimitCutoff()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insertRequest(t, app, "a", "key", false, cutoff.Add(time.Minute))
+	insertRequest(t, app, "a", "key", false, cutoff.Add(2*time.Minute))
+	insertRequest(t, app, "b", "key", false, cutoff.Add(time.Minute))
+	insertRequest(t, app, "b", "", false, cutoff.Add(time.Minute))
+	// answered by a shared upstream request, before the reset and without a token
+	insertRequest(t, app, "a", "key", true, cutoff.Add(time.Minute))
+	insertRequest(t, app, "a", "key", false, cutoff.Add(-time.Minute))
+	insertRequest(t, app, "", "key", false, cutoff.Add(time.Minute))
+
+	byToken := newUsageCounter(app, "token")
+	if err := byToken.reconcile(); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	if got := byToken.get("a"); got != 2 {
+		t.Errorf("usage of a = %d, want 2", got)
+	}
+	if got := byToken.get("b"); got != 2 {
+		t.Errorf("usage of b = %d, want 2", got)
+	}
+	if got := byToken.get(""); got != 0 {
+		t.Errorf("usage without a token = %d, want 0", got)
+	}
+
+	byAPIKey := newUsageCounter(app, "apiKey")
+	if err := byAPIKey.reconcile(); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	if got := byAPIKey.get("key"); got != 3 {
+		t.Errorf("usage of the API key = %d, want 3", got)
+	}
+}
+
+func TestUsageCounterReconcileKeepsUnloggedRequests(t *testing.T) {
+	app := faketest.NewApp(t)
+
+	cutoff, err := tokens.GetRatelimitCutoff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	insertRequest(t, app, "a", "", false, cutoff.Add(time.Minute))
+
+	c := newUsageCounter(app, "token")
+	if err := c.reconcile(); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+
+	// one request is logged, the entry of the other one was dropped
+	c.add("a")
+	c.add("a")
+	c.addUnlogged("a", time.Now())
+	insertRequest(t, app, "a", "", false, time.Now())
+	c.logged("a", time.Now())
+
+	if err := c.reconcile(); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	if got := c.get("a"); got != 3 {
+		t.Errorf("usage of a = %d, want 3 including the dropped entry", got)
+	}
+}
@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/spf13/cobra"
+)
+
+// NewCommand creates the "encryption" command to manage the encryption key.
+func NewCommand(app core.App) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "encryption",
+		Short: "Manages the encryption of stored passwords and tokens",
+	}
+
+	command.AddCommand(&cobra.Command{
+		Use:          "generate-key",
+		Short:        "Prints a new random encryption key",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := GenerateKey()
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(key)
+			return nil
+		},
+	})
+
+	var newKey, newKeyFile string
+
+	rotate := &cobra.Command{
+		Use:   "rotate",
+		Short: "Re-encrypts all stored secrets with a new key",
+		Long: "Re-encrypts all stored passwords and tokens with a new key. The current key is read from " +
+			KeyEnv + " or " + KeyFileEnv + ". If no key is configured yet, the plaintext values are encrypted.\n" +
+			"Update the environment with the new key afterwards.",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var (
+				key []byte
+				err error
+			)
+
+			switch {
+			case newKey != "":
+				key, err = ParseKey(newKey)
+			case newKeyFile != "":
+				key, err = ReadKeyFile(newKeyFile)
+			default:
+				return errors.New("either --new-key or --new-key-file is required")
+			}
+			if err != nil {
+				return err
+			}
+
+			target, err := NewBox(key)
+			if err != nil {
+				return err
+			}
+
+			current, err := Default()
+			if err != nil {
+				return err
+			}
+
+			if err := app.RunAllMigrations(); err != nil {
+				return err
+			}
+
+			count, err := RewrapAll(app, current, target)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Re-encrypted %d records. Update %s or %s before starting the server.\n", count, KeyEnv, KeyFileEnv)
+			return nil
+		},
+	}
+	rotate.Flags().StringVar(&newKey, "new-key", "", "the new base64 encoded key")
+	rotate.Flags().StringVar(&newKeyFile, "new-key-file", "", "path to a file with the new base64 encoded key")
+
+	command.AddCommand(rotate)
+
+	return command
+}
@@ -0,0 +1,98 @@
+package secrets_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/s1adem4n/tado-api-proxy/internal/secrets"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/faketest"
+)
+
+func newTestKey(t *testing.T) (string, *secrets.Box) {
+	t.Helper()
+
+	encoded, err := secrets.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	box, err := secrets.NewBox(key)
+	if err != nil {
+		t.Fatalf("NewBox() error = %v", err)
+	}
+	return encoded, box
+}
+
+func TestRotateCommand(t *testing.T) {
+	t.Setenv(secrets.KeyEnv, "")
+	t.Setenv(secrets.KeyFileEnv, "")
+
+	app := faketest.NewApp(t)
+
+	accounts, err := app.FindCollectionByNameOrId("accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := core.NewRecord(accounts)
+	account.Set("email", "test@example.com")
+	account.Set("password", "password")
+	if err := app.Save(account); err != nil {
+		t.Fatal(err)
+	}
+
+	// without a configured key, rotating encrypts the plaintext values
+	newKey, newBox := newTestKey(t)
+	command := secrets.NewCommand(app)
+	command.SetArgs([]string{"rotate", "--new-key", newKey})
+	if err := command.Execute(); err != nil {
+		t.Fatalf("encryption rotate error = %v", err)
+	}
+
+	account, err = app.FindRecordById("accounts", account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := account.GetString("password")
+	if !secrets.IsEncrypted(stored) {
+		t.Fatalf("password = %q, want it encrypted", stored)
+	}
+	if got, err := newBox.Decrypt(stored); err != nil || got != "password" {
+		t.Fatalf("Decrypt() = %q, %v, want %q", got, err, "password")
+	}
+
+	// rotating again re-wraps the values for the next key
+	_, nextBox := newTestKey(t)
+	count, err := secrets.RewrapAll(app, newBox, nextBox)
+	if err != nil {
+		t.Fatalf("RewrapAll() error = %v", err)
+	}
+	if count != 1 {
+		t.Errorf("RewrapAll() = %d, want 1 record", count)
+	}
+
+	account, err = app.FindRecordById("accounts", account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := nextBox.Decrypt(account.GetString("password")); err != nil || got != "password" {
+		t.Errorf("Decrypt() with the next key = %q, %v, want %q", got, err, "password")
+	}
+	if _, err := newBox.Decrypt(account.GetString("password")); err == nil {
+		t.Error("the previous key can still decrypt the password")
+	}
+}
+
+func TestRotateCommandRequiresKey(t *testing.T) {
+	app := faketest.NewApp(t)
+
+	command := secrets.NewCommand(app)
+	command.SetArgs([]string{"rotate"})
+	command.SilenceErrors = true
+	if err := command.Execute(); err == nil {
+		t.Error("encryption rotate without a new key succeeded")
+	}
+}
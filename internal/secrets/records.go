@@ -0,0 +1,154 @@
+package secrets
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Fields lists the encrypted fields by collection.
+var Fields = map[string][]string{
+	"accounts":  {"password"},
+	"tokens":    {"accessToken", "refreshToken"},
+	"settings":  {"mqttPassword"},
+	"notifiers": {"token"},
+}
+
+// Get returns the decrypted value of an encrypted record field.
+func Get(record *core.Record, field string) (string, error) {
+	box, err := Default()
+	if err != nil {
+		return "", err
+	}
+
+	return box.Decrypt(record.GetString(field))
+}
+
+// Register encrypts the fields of all saved records, so plaintext values
+// never reach the database if a key is configured.
+func Register(app core.App) {
+	encrypt := func(e *core.RecordEvent) error {
+		box, err := Default()
+		if err != nil {
+			return err
+		}
+
+		if box != nil {
+			if err := encryptFields(e.Record, box); err != nil {
+				return err
+			}
+		}
+
+		return e.Next()
+	}
+
+	collections := make([]string, 0, len(Fields))
+	for collection := range Fields {
+		collections = append(collections, collection)
+	}
+
+	app.OnRecordCreateExecute(collections...).BindFunc(encrypt)
+	app.OnRecordUpdateExecute(collections...).BindFunc(encrypt)
+}
+
+// RewrapAll re-wraps the encrypted fields of all records with the target box.
+// Plaintext values are encrypted. If from is nil, only plaintext values can be handled.
+func RewrapAll(app core.App, from *Box, target *Box) (int, error) {
+	count := 0
+
+	err := app.RunInTransaction(func(txApp core.App) error {
+		for collection, fields := range Fields {
+			// collections created by a later migration don't exist yet
+			if _, err := txApp.FindCollectionByNameOrId(collection); err != nil {
+				continue
+			}
+
+			records, err := txApp.FindAllRecords(collection)
+			if err != nil {
+				return err
+			}
+
+			for _, record := range records {
+				values := dbx.Params{}
+				for _, field := range fields {
+					value, err := from.Rewrap(record.GetString(field), target)
+					if err != nil {
+						return err
+					}
+					if value != record.GetString(field) {
+						values[field] = value
+					}
+				}
+				if len(values) == 0 {
+					continue
+				}
+
+				if err := updateFields(txApp, record, values); err != nil {
+					return err
+				}
+				count++
+			}
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// DecryptAll replaces the encrypted fields of all records with their plaintext.
+func DecryptAll(app core.App, box *Box) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		for collection, fields := range Fields {
+			// collections created by a later migration don't exist yet
+			if _, err := txApp.FindCollectionByNameOrId(collection); err != nil {
+				continue
+			}
+
+			records, err := txApp.FindAllRecords(collection)
+			if err != nil {
+				return err
+			}
+
+			for _, record := range records {
+				values := dbx.Params{}
+				for _, field := range fields {
+					value, err := box.Decrypt(record.GetString(field))
+					if err != nil {
+						return err
+					}
+					if value != record.GetString(field) {
+						values[field] = value
+					}
+				}
+				if len(values) == 0 {
+					continue
+				}
+
+				if err := updateFields(txApp, record, values); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// updateFields writes the changed values directly, so the save hooks don't encrypt them again
+// and the updated date stays untouched.
+func updateFields(app core.App, record *core.Record, values dbx.Params) error {
+	_, err := app.DB().Update(record.Collection().Name, values, dbx.HashExp{"id": record.Id}).Execute()
+	return err
+}
+
+func encryptFields(record *core.Record, box *Box) error {
+	for _, field := range Fields[record.Collection().Name] {
+		value, err := box.Encrypt(record.GetString(field))
+		if err != nil {
+			return err
+		}
+		record.Set(field, value)
+	}
+
+	return nil
+}
@@ -0,0 +1,246 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// KeyEnv contains the base64 encoded encryption key.
+	KeyEnv = "ENCRYPTION_KEY"
+	// KeyFileEnv contains the path to a file with the base64 encoded encryption key.
+	KeyFileEnv = "ENCRYPTION_KEY_FILE"
+
+	prefix  = "enc:v1:"
+	keySize = 32
+)
+
+// Box encrypts values with envelope encryption: every value is encrypted with its own
+// data key, which is wrapped with the key encryption key. Rotating the key only
+// re-wraps the data keys.
+//
+// Encrypted values have the format enc:v1:<key id>:<wrapped data key>:<ciphertext>.
+type Box struct {
+	kek   cipher.AEAD
+	keyID string
+}
+
+// NewBox creates a box from a 32 byte key encryption key.
+func NewBox(key []byte) (*Box, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", keySize, len(key))
+	}
+
+	kek, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(key)
+	return &Box{kek: kek, keyID: hex.EncodeToString(sum[:4])}, nil
+}
+
+// ParseKey decodes a base64 encoded key.
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return key, nil
+}
+
+// ReadKeyFile reads a base64 encoded key from a file.
+func ReadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKey(string(data))
+}
+
+// GenerateKey returns a new random base64 encoded key.
+func GenerateKey() (string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// LoadBox creates a box from the key in the environment.
+// It returns nil if no key is configured.
+func LoadBox() (*Box, error) {
+	var (
+		key []byte
+		err error
+	)
+
+	if encoded := os.Getenv(KeyEnv); encoded != "" {
+		key, err = ParseKey(encoded)
+	} else if path := os.Getenv(KeyFileEnv); path != "" {
+		key, err = ReadKeyFile(path)
+	} else {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBox(key)
+}
+
+var loadDefault = sync.OnceValues(LoadBox)
+
+// Default returns the box for the key in the environment, or nil if encryption is disabled.
+func Default() (*Box, error) {
+	return loadDefault()
+}
+
+// IsEncrypted reports whether the value was encrypted by a box.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Encrypt encrypts the value with a new data key.
+// Empty and already encrypted values are returned unchanged.
+func (b *Box) Encrypt(value string) (string, error) {
+	if value == "" || IsEncrypted(value) {
+		return value, nil
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	dek, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := seal(b.kek, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := seal(dek, []byte(value))
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + b.keyID + ":" + wrappedKey + ":" + ciphertext, nil
+}
+
+// Decrypt decrypts the value. Values that are not encrypted are returned unchanged.
+// A nil box can only decrypt unencrypted values.
+func (b *Box) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	dataKey, ciphertext, err := b.unwrap(value)
+	if err != nil {
+		return "", err
+	}
+
+	dek, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := open(dek, ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// Rewrap re-wraps the data key of the value with the key of the target box.
+// Values that are not encrypted yet are encrypted with the target box.
+func (b *Box) Rewrap(value string, target *Box) (string, error) {
+	if !IsEncrypted(value) {
+		return target.Encrypt(value)
+	}
+
+	dataKey, ciphertext, err := b.unwrap(value)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := seal(target.kek, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + target.keyID + ":" + wrappedKey + ":" + ciphertext, nil
+}
+
+// unwrap returns the data key and the encoded ciphertext of an encrypted value.
+func (b *Box) unwrap(value string) ([]byte, string, error) {
+	if b == nil {
+		return nil, "", errors.New("value is encrypted, but no encryption key is configured")
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return nil, "", errors.New("malformed encrypted value")
+	}
+	if parts[0] != b.keyID {
+		return nil, "", fmt.Errorf("value is encrypted with a different key (%s)", parts[0])
+	}
+
+	dataKey, err := open(b.kek, parts[1])
+	if err != nil {
+		return nil, "", err
+	}
+
+	return dataKey, parts[2], nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext and returns the base64 encoded nonce and ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a value created by seal.
+func open(aead cipher.AEAD, encoded string) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("malformed encrypted value: %w", err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("malformed encrypted value")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,155 @@
+package secrets
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestBox(t *testing.T) *Box {
+	t.Helper()
+
+	encoded, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	key, err := ParseKey(encoded)
+	if err != nil {
+		t.Fatalf("ParseKey() error = %v", err)
+	}
+	box, err := NewBox(key)
+	if err != nil {
+		t.Fatalf("NewBox() error = %v", err)
+	}
+	return box
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	box := newTestBox(t)
+
+	encrypted, err := box.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if !IsEncrypted(encrypted) || strings.Contains(encrypted, "secret") {
+		t.Fatalf("Encrypt() = %q, want an encrypted value", encrypted)
+	}
+
+	decrypted, err := box.Decrypt(encrypted)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if decrypted != "secret" {
+		t.Errorf("Decrypt() = %q, want %q", decrypted, "secret")
+	}
+
+	// every value gets its own data key and nonce
+	again, err := box.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if again == encrypted {
+		t.Error("Encrypt() returned the same ciphertext twice")
+	}
+}
+
+func TestEncryptKeepsEmptyAndEncryptedValues(t *testing.T) {
+	box := newTestBox(t)
+
+	if got, err := box.Encrypt(""); err != nil || got != "" {
+		t.Errorf("Encrypt(\"\") = %q, %v, want an empty value", got, err)
+	}
+
+	encrypted, err := box.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if got, err := box.Encrypt(encrypted); err != nil || got != encrypted {
+		t.Errorf("Encrypt() of an encrypted value = %q, %v, want it unchanged", got, err)
+	}
+}
+
+func TestDecryptPlaintext(t *testing.T) {
+	var box *Box
+
+	if got, err := box.Decrypt("plain"); err != nil || got != "plain" {
+		t.Errorf("Decrypt() of a plaintext value = %q, %v, want it unchanged", got, err)
+	}
+
+	encrypted, err := newTestBox(t).Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if _, err := box.Decrypt(encrypted); err == nil {
+		t.Error("Decrypt() of an encrypted value without a key succeeded")
+	}
+}
+
+func TestDecryptWithOtherKey(t *testing.T) {
+	encrypted, err := newTestBox(t).Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	if _, err := newTestBox(t).Decrypt(encrypted); err == nil {
+		t.Error("Decrypt() with another key succeeded")
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	box := newTestBox(t)
+
+	encrypted, err := box.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	tampered := encrypted[:len(encrypted)-2] + "AA"
+	if tampered == encrypted {
+		tampered = encrypted[:len(encrypted)-2] + "BB"
+	}
+	if _, err := box.Decrypt(tampered); err == nil {
+		t.Error("Decrypt() of a tampered value succeeded")
+	}
+	if _, err := box.Decrypt(prefix + "malformed"); err == nil {
+		t.Error("Decrypt() of a malformed value succeeded")
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	current, target := newTestBox(t), newTestBox(t)
+
+	encrypted, err := current.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	rewrapped, err := current.Rewrap(encrypted, target)
+	if err != nil {
+		t.Fatalf("Rewrap() error = %v", err)
+	}
+	if got, err := target.Decrypt(rewrapped); err != nil || got != "secret" {
+		t.Errorf("Decrypt() with the target key = %q, %v, want %q", got, err, "secret")
+	}
+	if _, err := current.Decrypt(rewrapped); err == nil {
+		t.Error("Decrypt() of the rewrapped value with the old key succeeded")
+	}
+
+	// plaintext values are encrypted with the target key
+	var none *Box
+	rewrapped, err = none.Rewrap("plain", target)
+	if err != nil {
+		t.Fatalf("Rewrap() of a plaintext value error = %v", err)
+	}
+	if got, err := target.Decrypt(rewrapped); err != nil || got != "plain" {
+		t.Errorf("Decrypt() of the rewrapped plaintext = %q, %v, want %q", got, err, "plain")
+	}
+}
+
+func TestNewBoxKeySize(t *testing.T) {
+	if _, err := NewBox(make([]byte, 16)); err == nil {
+		t.Error("NewBox() with a 16 byte key succeeded")
+	}
+	if _, err := ParseKey("not base64!"); err == nil {
+		t.Error("ParseKey() of an invalid key succeeded")
+	}
+}
@@ -8,10 +8,6 @@ import (
 	"github.com/imroc/req/v3"
 )
 
-const (
-	BaseURL = "https://my.tado.com"
-)
-
 type APIError struct {
 	StatusCode int
 	Body       string
@@ -43,7 +39,7 @@ func (c *Client) GetMe(ctx context.Context, accessToken, platform string) (*MeRe
 		SetHeader("authorization", "Bearer "+accessToken).
 		SetQueryParam("ngsw-bypass", "true").
 		SetSuccessResult(&meResp).
-		Get(BaseURL + "/api/v2/me")
+		Get(APIURL + "/api/v2/me")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get /me: %w", err)
 	}
@@ -9,15 +9,14 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 
+	"github.com/imroc/req/v3"
 	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
 )
 
 const (
-	TokenURL      = "https://login.tado.com/oauth2/token"
-	AuthorizeURL  = "https://login.tado.com/oauth2/authorize"
-	DeviceAuthURL = "https://login.tado.com/oauth2/device_authorize"
-	TenantID      = "1d543ad5-a8ac-4704-b9e2-26838b4d6513"
+	TenantID = "1d543ad5-a8ac-4704-b9e2-26838b4d6513"
 )
 
 // DeviceAuthResponse is the device authorization response.
@@ -32,12 +31,18 @@ type DeviceAuthResponse struct {
 
 // Auth handles OAuth authentication with tado's API.
 // It implements tokens.TokenAuthProvider.
-// It is stateless and can be safely shared across goroutines.
-type Auth struct{}
+// It can be safely shared across goroutines. The only state are logins
+// waiting for a two-factor code, which are kept in memory.
+type Auth struct {
+	mfaSessions   map[string]*mfaSession
+	mfaSessionsMu sync.Mutex
+}
 
 // NewAuth creates a new Auth instance.
 func NewAuth() *Auth {
-	return &Auth{}
+	return &Auth{
+		mfaSessions: make(map[string]*mfaSession),
+	}
 }
 
 // Authorize performs the OAuth password grant flow.
@@ -62,7 +67,7 @@ func (a *Auth) Authorize(
 	authClient := NewAuthClient(platform)
 
 	// Build initial authorize URL
-	initURL, err := url.Parse(AuthorizeURL)
+	initURL, err := url.Parse(authorizeURL())
 	if err != nil {
 		return nil, err
 	}
@@ -123,21 +128,52 @@ func (a *Auth) Authorize(
 		SetHeader("origin", "https://login.tado.com").
 		SetHeader("referer", "https://login.tado.com/").
 		SetBodyString(formData.Encode()).
-		Post(AuthorizeURL)
+		Post(authorizeURL())
 	if err != nil {
 		return nil, fmt.Errorf("authorization failed: %w", err)
 	}
 
+	flow := &loginFlow{
+		authClient:  authClient,
+		clientID:    clientID,
+		redirectURI: redirectURI,
+		scope:       scope,
+		verifier:    verifier,
+		platform:    platform,
+	}
+
+	// Accounts with two-factor authentication get the code form instead of a redirect
+	if resp.StatusCode == http.StatusOK {
+		if form := parseTwoFactorForm(resp.String()); form != nil {
+			return nil, a.startMFASession(ctx, flow, form)
+		}
+	}
+
 	if resp.StatusCode != http.StatusFound {
-		return nil, fmt.Errorf("authorization failed (%d): %s", resp.StatusCode, resp.String())
+		return nil, loginError(resp)
 	}
 
+	return a.finishAuthorize(ctx, flow, resp.GetHeader("Location"))
+}
+
+// loginFlow contains the state of a password grant login between its steps.
+type loginFlow struct {
+	authClient  *req.Client
+	clientID    string
+	redirectURI string
+	scope       string
+	verifier    string
+	platform    string
+}
+
+// finishAuthorize follows the redirects of a successful login to the redirect URI
+// and exchanges the code for a token.
+func (a *Auth) finishAuthorize(ctx context.Context, flow *loginFlow, location string) (*tokens.TokenResult, error) {
 	// Step 3: Follow redirects until we reach the redirect URI
-	location := resp.GetHeader("Location")
-	for location != "" && !strings.HasPrefix(location, redirectURI) {
+	for location != "" && !strings.HasPrefix(location, flow.redirectURI) {
 		absLocation := absURL(location)
 
-		resp, err = authClient.R().
+		resp, err := flow.authClient.R().
 			SetContext(ctx).
 			SetHeader("referer", "https://login.tado.com/").
 			Get(absLocation)
@@ -149,6 +185,12 @@ func (a *Auth) Authorize(
 			return nil, fmt.Errorf("redirect failed (%d): %s", resp.StatusCode, resp.String())
 		}
 
+		if resp.StatusCode == http.StatusOK {
+			if form := parseTwoFactorForm(resp.String()); form != nil {
+				return nil, a.startMFASession(ctx, flow, form)
+			}
+		}
+
 		location = resp.GetHeader("Location")
 	}
 
@@ -167,23 +209,23 @@ func (a *Auth) Authorize(
 	}
 
 	// Step 4: Exchange code for token
-	tokenClient := NewTokenClient(platform)
+	tokenClient := NewTokenClient(flow.platform)
 
 	tokenData := url.Values{}
 	tokenData.Set("code", code)
-	tokenData.Set("code_verifier", verifier)
-	tokenData.Set("redirect_uri", redirectURI)
-	tokenData.Set("scope", scope)
+	tokenData.Set("code_verifier", flow.verifier)
+	tokenData.Set("redirect_uri", flow.redirectURI)
+	tokenData.Set("scope", flow.scope)
 	tokenData.Set("grant_type", "authorization_code")
-	tokenData.Set("client_id", clientID)
+	tokenData.Set("client_id", flow.clientID)
 
 	var tokenResp tokens.TokenResult
-	resp, err = tokenClient.R().
+	resp, err := tokenClient.R().
 		SetContext(ctx).
 		SetHeader("content-type", "application/x-www-form-urlencoded").
 		SetBodyString(tokenData.Encode()).
 		SetSuccessResult(&tokenResp).
-		Post(TokenURL)
+		Post(tokenURL())
 	if err != nil {
 		return nil, fmt.Errorf("token exchange failed: %w", err)
 	}
@@ -211,7 +253,7 @@ func (a *Auth) RefreshToken(ctx context.Context, clientID, refreshToken, platfor
 		SetHeader("content-type", "application/x-www-form-urlencoded").
 		SetBodyString(data.Encode()).
 		SetSuccessResult(&tokenResp).
-		Post(TokenURL)
+		Post(tokenURL())
 	if err != nil {
 		return nil, fmt.Errorf("refresh token failed: %w", err)
 	}
@@ -227,7 +269,7 @@ func (a *Auth) RefreshToken(ctx context.Context, clientID, refreshToken, platfor
 func (a *Auth) DeviceAuthorize(ctx context.Context, clientID, scope string) (*DeviceAuthResponse, error) {
 	tokenClient := NewIOSSafariTokenClient()
 
-	authURL, err := url.Parse(DeviceAuthURL)
+	authURL, err := url.Parse(deviceAuthURL())
 	if err != nil {
 		return nil, err
 	}
@@ -268,7 +310,7 @@ func (a *Auth) ExchangeDeviceCode(ctx context.Context, clientID, deviceCode stri
 		SetHeader("content-type", "application/x-www-form-urlencoded").
 		SetBodyString(data.Encode()).
 		SetSuccessResult(&tokenResp).
-		Post(TokenURL)
+		Post(tokenURL())
 	if err != nil {
 		return nil, fmt.Errorf("device token request failed: %w", err)
 	}
@@ -309,5 +351,5 @@ func absURL(loc string) string {
 	if strings.HasPrefix(loc, "http") {
 		return loc
 	}
-	return "https://login.tado.com" + loc
+	return LoginURL + loc
 }
@@ -0,0 +1,103 @@
+package tado
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/imroc/req/v3"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+	"golang.org/x/net/html"
+)
+
+// loginError returns the error of a login the tado login page didn't accept.
+// It is a tokens.AuthError if the failure could be classified.
+func loginError(resp *req.Response) error {
+	body := resp.String()
+
+	message := parseLoginError(body)
+	if message == "" {
+		err := fmt.Errorf("authorization failed (%d): %s", resp.StatusCode, body)
+		switch {
+		case resp.StatusCode >= http.StatusInternalServerError:
+			return &tokens.AuthError{Failure: tokens.AuthFailureNetwork, Err: err}
+		case resp.StatusCode == http.StatusTooManyRequests:
+			return &tokens.AuthError{Failure: tokens.AuthFailureRateLimited, Err: err}
+		default:
+			return err
+		}
+	}
+
+	return &tokens.AuthError{
+		Failure: classifyLoginMessage(message),
+		Err:     fmt.Errorf("authorization failed (%d): %s", resp.StatusCode, message),
+	}
+}
+
+// classifyLoginMessage classifies an error message of the login page. tado shows the same
+// message for a wrong password and for many deleted accounts, so a wrong password can
+// also mean that the account is gone. "Too many attempts" only throttles the logins for a while.
+func classifyLoginMessage(message string) tokens.AuthFailure {
+	message = strings.ToLower(message)
+
+	switch {
+	case strings.Contains(message, "captcha") || strings.Contains(message, "robot"):
+		return tokens.AuthFailureCaptcha
+	case strings.Contains(message, "too many"):
+		return tokens.AuthFailureRateLimited
+	case strings.Contains(message, "locked") || strings.Contains(message, "disabled"):
+		return tokens.AuthFailureLocked
+	case strings.Contains(message, "deleted") || strings.Contains(message, "does not exist") ||
+		strings.Contains(message, "no longer exists"):
+		return tokens.AuthFailureDeleted
+	case strings.Contains(message, "credentials") || strings.Contains(message, "password"):
+		return tokens.AuthFailureWrongPassword
+	default:
+		return tokens.AuthFailureUnknown
+	}
+}
+
+// parseLoginError returns the text of the first error element of the login page.
+func parseLoginError(body string) string {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var message string
+
+	var findError func(n *html.Node)
+	findError = func(n *html.Node) {
+		if message != "" {
+			return
+		}
+
+		if n.Type == html.ElementNode && slices.Contains(strings.Fields(attr(n, "class")), "error") {
+			message = strings.Join(strings.Fields(textContent(n)), " ")
+			if message != "" {
+				return
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			findError(c)
+		}
+	}
+	findError(doc)
+
+	return message
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+		b.WriteString(" ")
+	}
+	return b.String()
+}
@@ -0,0 +1,41 @@
+package tado
+
+import (
+	"testing"
+
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+func TestClassifyLoginMessage(t *testing.T) {
+	tests := []struct {
+		message string
+		want    tokens.AuthFailure
+	}{
+		{"Invalid login credentials.", tokens.AuthFailureWrongPassword},
+		{"Your account has been locked.", tokens.AuthFailureLocked},
+		{"This account is disabled.", tokens.AuthFailureLocked},
+		{"Too many failed login attempts. Please try again later.", tokens.AuthFailureRateLimited},
+		{"Please confirm that you are not a robot.", tokens.AuthFailureCaptcha},
+		{"The account no longer exists.", tokens.AuthFailureDeleted},
+		{"Something went wrong.", tokens.AuthFailureUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := classifyLoginMessage(tt.message); got != tt.want {
+			t.Errorf("classifyLoginMessage(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestParseLoginError(t *testing.T) {
+	body := `<form><div class="field error-free"></div><span class="form error">
+		Too many   failed attempts.
+	</span></form>`
+
+	if got, want := parseLoginError(body), "Too many failed attempts."; got != want {
+		t.Errorf("parseLoginError() = %q, want %q", got, want)
+	}
+	if got := parseLoginError(`{"errors":[]}`); got != "" {
+		t.Errorf("parseLoginError() of a JSON body = %q, want none", got)
+	}
+}
@@ -0,0 +1,217 @@
+package tado
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+	"golang.org/x/net/html"
+)
+
+// MFASessionLifetime is how long tado accepts the code of a two-factor login.
+const MFASessionLifetime = 5 * time.Minute
+
+var (
+	// ErrMFASessionNotFound is returned if a two-factor login expired or the server was restarted.
+	ErrMFASessionNotFound = errors.New("two-factor login not found or expired")
+	// ErrInvalidMFACode is returned if tado rejected the code. The login can be retried.
+	ErrInvalidMFACode = errors.New("invalid two-factor code")
+)
+
+// mfaSession is a login waiting for a two-factor code.
+type mfaSession struct {
+	flow    *loginFlow
+	form    *twoFactorForm
+	expires time.Time
+}
+
+// twoFactorForm is a two-factor form of the tado login page.
+type twoFactorForm struct {
+	action  string
+	fields  url.Values
+	methods []string
+}
+
+// startMFASession selects the two-factor method if needed, stores the login and
+// returns a tokens.MFARequiredError to resume it with ResumeAuthorize.
+func (a *Auth) startMFASession(ctx context.Context, flow *loginFlow, form *twoFactorForm) error {
+	// Accounts with multiple methods have to choose one first, use tado's default
+	if len(form.methods) > 0 {
+		fields := maps.Clone(form.fields)
+		fields.Set("methodId", form.methods[0])
+
+		resp, err := a.submitTwoFactorForm(ctx, flow, form.action, fields)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusFound {
+			resp, err = flow.authClient.R().
+				SetContext(ctx).
+				SetHeader("referer", "https://login.tado.com/").
+				Get(absURL(resp.GetHeader("Location")))
+			if err != nil {
+				return fmt.Errorf("two-factor method selection failed: %w", err)
+			}
+		}
+
+		form = parseTwoFactorForm(resp.String())
+		if form == nil || len(form.methods) > 0 {
+			return fmt.Errorf("two-factor method selection failed (%d)", resp.StatusCode)
+		}
+	}
+
+	id, err := generateState()
+	if err != nil {
+		return err
+	}
+
+	expires := time.Now().Add(MFASessionLifetime)
+
+	a.mfaSessionsMu.Lock()
+	defer a.mfaSessionsMu.Unlock()
+
+	// drop logins nobody entered a code for
+	for sessionID, session := range a.mfaSessions {
+		if time.Now().After(session.expires) {
+			delete(a.mfaSessions, sessionID)
+		}
+	}
+
+	a.mfaSessions[id] = &mfaSession{flow: flow, form: form, expires: expires}
+
+	return &tokens.MFARequiredError{Session: id, Expires: expires}
+}
+
+// ResumeAuthorize finishes a password grant login that was waiting for a two-factor code.
+// If the code is rejected, ErrInvalidMFACode is returned and the login can be retried.
+func (a *Auth) ResumeAuthorize(ctx context.Context, sessionID, code string) (*tokens.TokenResult, error) {
+	a.mfaSessionsMu.Lock()
+	session, ok := a.mfaSessions[sessionID]
+	var form *twoFactorForm
+	if ok {
+		form = session.form
+	}
+	a.mfaSessionsMu.Unlock()
+	if !ok || time.Now().After(session.expires) {
+		a.DropMFASession(sessionID)
+		return nil, ErrMFASessionNotFound
+	}
+
+	fields := maps.Clone(form.fields)
+	fields.Set("code", strings.TrimSpace(code))
+	fields.Set("trustComputer", "true")
+
+	resp, err := a.submitTwoFactorForm(ctx, session.flow, form.action, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// tado shows the form again if the code was wrong
+	if resp.StatusCode == http.StatusOK {
+		if form := parseTwoFactorForm(resp.String()); form != nil {
+			a.mfaSessionsMu.Lock()
+			session.form = form
+			a.mfaSessionsMu.Unlock()
+			return nil, ErrInvalidMFACode
+		}
+	}
+
+	if resp.StatusCode != http.StatusFound {
+		return nil, fmt.Errorf("two-factor authentication failed (%d): %s", resp.StatusCode, resp.String())
+	}
+
+	a.DropMFASession(sessionID)
+	return a.finishAuthorize(ctx, session.flow, resp.GetHeader("Location"))
+}
+
+// DropMFASession forgets a login waiting for a two-factor code.
+func (a *Auth) DropMFASession(sessionID string) {
+	a.mfaSessionsMu.Lock()
+	defer a.mfaSessionsMu.Unlock()
+
+	delete(a.mfaSessions, sessionID)
+}
+
+func (a *Auth) submitTwoFactorForm(ctx context.Context, flow *loginFlow, action string, fields url.Values) (*req.Response, error) {
+	resp, err := flow.authClient.R().
+		SetContext(ctx).
+		SetHeader("content-type", "application/x-www-form-urlencoded").
+		SetHeader("origin", "https://login.tado.com").
+		SetHeader("referer", "https://login.tado.com/").
+		SetBodyString(fields.Encode()).
+		Post(absURL(action))
+	if err != nil {
+		return nil, fmt.Errorf("two-factor authentication failed: %w", err)
+	}
+
+	return resp, nil
+}
+
+// parseTwoFactorForm returns the two-factor form of a login page, or nil if there is none.
+func parseTwoFactorForm(body string) *twoFactorForm {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil
+	}
+
+	var form *twoFactorForm
+
+	var findForm func(n *html.Node)
+	findForm = func(n *html.Node) {
+		if form != nil {
+			return
+		}
+
+		if n.Type == html.ElementNode && n.Data == "form" {
+			action := attr(n, "action")
+			if strings.Contains(action, "/oauth2/two-factor") {
+				form = &twoFactorForm{action: action, fields: url.Values{}}
+				collectInputs(n, form)
+				return
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			findForm(c)
+		}
+	}
+	findForm(doc)
+
+	return form
+}
+
+// collectInputs adds the hidden fields and the method choices of the form.
+func collectInputs(n *html.Node, form *twoFactorForm) {
+	if n.Type == html.ElementNode && n.Data == "input" {
+		name := attr(n, "name")
+		switch attr(n, "type") {
+		case "hidden":
+			form.fields.Set(name, attr(n, "value"))
+		case "radio":
+			if name == "methodId" {
+				form.methods = append(form.methods, attr(n, "value"))
+			}
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		collectInputs(c, form)
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
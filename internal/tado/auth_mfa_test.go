@@ -0,0 +1,135 @@
+package tado_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/s1adem4n/tado-api-proxy/internal/tado"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/fake"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/faketest"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+const testMFACode = "123456"
+
+// newMFAServer starts a fake server with an account that asks for a two-factor code on every login.
+func newMFAServer(t *testing.T) *fake.Server {
+	return faketest.NewServer(t, fake.Account{
+		Email:    "test@example.com",
+		Password: "password",
+		MFACode:  testMFACode,
+		Homes:    []fake.Home{{ID: 1, Name: "Home"}},
+	})
+}
+
+// startMFALogin starts a login that waits for the two-factor code.
+func startMFALogin(t *testing.T, auth *tado.Auth) *tokens.MFARequiredError {
+	t.Helper()
+
+	_, err := auth.Authorize(context.Background(), testClientID, testRedirectURI, testScope, "test@example.com", "password", "web")
+	var mfaErr *tokens.MFARequiredError
+	if !errors.As(err, &mfaErr) {
+		t.Fatalf("Authorize() error = %v, want an MFARequiredError", err)
+	}
+	return mfaErr
+}
+
+func TestResumeAuthorize(t *testing.T) {
+	newMFAServer(t)
+	ctx := context.Background()
+	auth := tado.NewAuth()
+
+	mfaErr := startMFALogin(t, auth)
+
+	// a wrong code can be corrected
+	if _, err := auth.ResumeAuthorize(ctx, mfaErr.Session, "000000"); !errors.Is(err, tado.ErrInvalidMFACode) {
+		t.Fatalf("ResumeAuthorize() with a wrong code error = %v, want ErrInvalidMFACode", err)
+	}
+
+	token, err := auth.ResumeAuthorize(ctx, mfaErr.Session, " "+testMFACode+" ")
+	if err != nil {
+		t.Fatalf("ResumeAuthorize() error = %v", err)
+	}
+	if token.AccessToken == "" || token.RefreshToken == "" {
+		t.Error("ResumeAuthorize() returned no tokens")
+	}
+
+	// the finished login is gone
+	if _, err := auth.ResumeAuthorize(ctx, mfaErr.Session, testMFACode); !errors.Is(err, tado.ErrMFASessionNotFound) {
+		t.Errorf("ResumeAuthorize() of a finished login error = %v, want ErrMFASessionNotFound", err)
+	}
+}
+
+func TestResumeAuthorizeDroppedSession(t *testing.T) {
+	newMFAServer(t)
+	auth := tado.NewAuth()
+
+	mfaErr := startMFALogin(t, auth)
+	auth.DropMFASession(mfaErr.Session)
+
+	if _, err := auth.ResumeAuthorize(context.Background(), mfaErr.Session, testMFACode); !errors.Is(err, tado.ErrMFASessionNotFound) {
+		t.Errorf("ResumeAuthorize() error = %v, want ErrMFASessionNotFound", err)
+	}
+}
+
+func TestCompleteChallenge(t *testing.T) {
+	newMFAServer(t)
+	ctx := context.Background()
+	app := faketest.NewApp(t)
+	auth := tado.NewAuth()
+	manager := tokens.NewManager(app, auth, nil)
+	client := tado.NewClient(app, auth, manager)
+
+	clientRecord := faketest.NewClient(t, app, "web", 1000)
+	account := faketest.NewRecord(t, app, "accounts", map[string]any{
+		"email":    "test@example.com",
+		"password": "password",
+	})
+
+	mfaErr := startMFALogin(t, auth)
+	if err := manager.CreateChallenge(account.Id, clientRecord.Id, mfaErr); err != nil {
+		t.Fatalf("CreateChallenge() error = %v", err)
+	}
+	challenge, err := app.FindFirstRecordByFilter("challenges", "status = 'pending'")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	challenge.Set("code", "000000")
+	if err := client.CompleteChallenge(ctx, challenge); err != nil {
+		t.Fatalf("CompleteChallenge() error = %v", err)
+	}
+	if challenge.GetString("status") != "pending" || challenge.GetString("error") == "" {
+		t.Errorf("challenge with a wrong code: status = %q, error = %q, want pending with an error",
+			challenge.GetString("status"), challenge.GetString("error"))
+	}
+
+	challenge.Set("code", testMFACode)
+	if err := client.CompleteChallenge(ctx, challenge); err != nil {
+		t.Fatalf("CompleteChallenge() error = %v", err)
+	}
+	if challenge.GetString("status") != "completed" {
+		t.Errorf("status = %q, want completed", challenge.GetString("status"))
+	}
+	if challenge.GetString("code") != "" {
+		t.Error("the code was kept on the challenge")
+	}
+
+	token, err := app.FindFirstRecordByFilter("tokens", "account = {:account}", map[string]any{"account": account.Id})
+	if err != nil {
+		t.Fatalf("no token was stored: %v", err)
+	}
+	if token.GetString("status") != "valid" {
+		t.Errorf("token status = %q, want valid", token.GetString("status"))
+	}
+
+	// the account that never logged in without a code gets its homes now
+	account, err = app.FindRecordById("accounts", account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.GetString("tadoID") == "" {
+		t.Error("the homes of the account weren't loaded")
+	}
+}
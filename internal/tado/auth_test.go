@@ -0,0 +1,138 @@
+package tado_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/s1adem4n/tado-api-proxy/internal/tado"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/fake"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/faketest"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+const (
+	testClientID    = "test-client"
+	testRedirectURI = "https://app.tado.com/en/main/home"
+	testScope       = "home.user offline_access"
+)
+
+// newFakeServer starts a fake server with one account and points the tado services to it.
+func newFakeServer(t *testing.T) *fake.Server {
+	return faketest.NewServer(t, fake.Account{
+		Email:    "test@example.com",
+		Password: "password",
+		Homes:    []fake.Home{{ID: 1, Name: "Home"}},
+	})
+}
+
+func TestRefreshTokenRotates(t *testing.T) {
+	newFakeServer(t)
+	ctx := context.Background()
+	auth := tado.NewAuth()
+
+	token, err := auth.Authorize(ctx, testClientID, testRedirectURI, testScope, "test@example.com", "password", "web")
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+
+	refreshed, err := auth.RefreshToken(ctx, testClientID, token.RefreshToken, "web")
+	if err != nil {
+		t.Fatalf("RefreshToken() error = %v", err)
+	}
+	if refreshed.AccessToken == token.AccessToken || refreshed.RefreshToken == token.RefreshToken {
+		t.Fatal("RefreshToken() returned the old tokens")
+	}
+
+	// the used refresh token was rotated and can't be used again
+	_, err = auth.RefreshToken(ctx, testClientID, token.RefreshToken, "web")
+	var apiErr *tado.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 {
+		t.Fatalf("RefreshToken() with the rotated token error = %v, want a 400 APIError", err)
+	}
+
+	if _, err := auth.RefreshToken(ctx, testClientID, refreshed.RefreshToken, "web"); err != nil {
+		t.Fatalf("RefreshToken() with the new token error = %v", err)
+	}
+}
+
+func TestRefreshTokenExpired(t *testing.T) {
+	server := newFakeServer(t)
+	ctx := context.Background()
+	auth := tado.NewAuth()
+
+	token, err := auth.Authorize(ctx, testClientID, testRedirectURI, testScope, "test@example.com", "password", "mobile")
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+
+	server.ExpireRefreshTokens()
+
+	if _, err := auth.RefreshToken(ctx, testClientID, token.RefreshToken, "mobile"); err == nil {
+		t.Fatal("RefreshToken() with an expired token succeeded")
+	}
+}
+
+func TestDeviceCodeFlow(t *testing.T) {
+	server := newFakeServer(t)
+	ctx := context.Background()
+	auth := tado.NewAuth()
+
+	deviceAuth, err := auth.DeviceAuthorize(ctx, testClientID, testScope)
+	if err != nil {
+		t.Fatalf("DeviceAuthorize() error = %v", err)
+	}
+	if deviceAuth.DeviceCode == "" || deviceAuth.UserCode == "" || deviceAuth.ExpiresIn <= 0 {
+		t.Fatalf("DeviceAuthorize() = %+v, want a device code, user code and expiry", deviceAuth)
+	}
+
+	// polling fails until the user confirmed the code
+	if _, err := auth.ExchangeDeviceCode(ctx, testClientID, deviceAuth.DeviceCode); err == nil {
+		t.Fatal("ExchangeDeviceCode() succeeded before the code was approved")
+	}
+
+	if err := server.ApproveDeviceCode(deviceAuth.UserCode, "test@example.com"); err != nil {
+		t.Fatalf("ApproveDeviceCode() error = %v", err)
+	}
+
+	token, err := auth.ExchangeDeviceCode(ctx, testClientID, deviceAuth.DeviceCode)
+	if err != nil {
+		t.Fatalf("ExchangeDeviceCode() error = %v", err)
+	}
+	if token.AccessToken == "" || token.RefreshToken == "" {
+		t.Fatalf("ExchangeDeviceCode() = %+v, want an access and refresh token", token)
+	}
+
+	// a device code can only be exchanged once
+	if _, err := auth.ExchangeDeviceCode(ctx, testClientID, deviceAuth.DeviceCode); err == nil {
+		t.Fatal("ExchangeDeviceCode() succeeded twice")
+	}
+}
+
+func TestAuthorizeClassifiesLoginFailures(t *testing.T) {
+	server := newFakeServer(t)
+	server.AddAccount(fake.Account{Email: "locked@example.com", Password: "password", Locked: true})
+	ctx := context.Background()
+	auth := tado.NewAuth()
+
+	// a throttled login page without an error message
+	server.FailNext("POST", `^/oauth2/authorize$`, 429, 1, nil)
+
+	_, err := auth.Authorize(ctx, testClientID, testRedirectURI, testScope, "test@example.com", "password", "web")
+	if got := tokens.ClassifyAuthError(err); got != tokens.AuthFailureRateLimited {
+		t.Errorf("Authorize() with a 429 login page failure = %q, want rateLimited", got)
+	}
+	if tokens.ClassifyAuthError(err).Hard() {
+		t.Error("a throttled login counts as a hard failure")
+	}
+
+	_, err = auth.Authorize(ctx, testClientID, testRedirectURI, testScope, "test@example.com", "wrong", "web")
+	if got := tokens.ClassifyAuthError(err); got != tokens.AuthFailureWrongPassword {
+		t.Errorf("Authorize() with a wrong password failure = %q, want wrongPassword", got)
+	}
+
+	_, err = auth.Authorize(ctx, testClientID, testRedirectURI, testScope, "locked@example.com", "password", "web")
+	if got := tokens.ClassifyAuthError(err); got != tokens.AuthFailureLocked {
+		t.Errorf("Authorize() with a locked account failure = %q, want locked", got)
+	}
+}
@@ -2,8 +2,10 @@ package tado
 
 import (
 	"context"
+	"errors"
 
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/s1adem4n/tado-api-proxy/internal/secrets"
 	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
 )
 
@@ -49,28 +51,41 @@ func (c *Client) Register() {
 		return e.Next()
 	})
 
+	c.app.OnRecordUpdateRequest("challenges").BindFunc(func(e *core.RecordRequestEvent) error {
+		err := c.CompleteChallenge(e.Request.Context(), e.Record)
+		if err != nil {
+			return err
+		}
+
+		return e.Next()
+	})
+
+	c.app.Cron().MustAdd("expire-challenges", "* * * * *", func() {
+		err := c.ExpireChallenges(false)
+		if err != nil {
+			c.app.Logger().Error("failed to expire challenges", "error", err)
+		}
+	})
+
 	c.app.OnServe().BindFunc(func(e *core.ServeEvent) error {
 		err := c.DeleteUnusedCodes()
 		if err != nil {
 			c.app.Logger().Error("failed to delete unused codes", "error", err)
 		}
 
+		// pending logins are only kept in memory
+		err = c.ExpireChallenges(true)
+		if err != nil {
+			c.app.Logger().Error("failed to expire challenges", "error", err)
+		}
+
 		return e.Next()
 	})
 }
 
 // LoadAccountData creates tokens for all password grant clients and fetches account homes.
+// Logins that require a two-factor code are continued once the code is entered in the web UI.
 func (c *Client) LoadAccountData(ctx context.Context, account *core.Record) error {
-	tokensCollection, err := c.app.FindCollectionByNameOrId("tokens")
-	if err != nil {
-		return err
-	}
-
-	homesCollection, err := c.app.FindCollectionByNameOrId("homes")
-	if err != nil {
-		return err
-	}
-
 	clients, err := c.app.FindRecordsByFilter(
 		"clients",
 		"type = 'passwordGrant'",
@@ -80,6 +95,11 @@ func (c *Client) LoadAccountData(ctx context.Context, account *core.Record) erro
 		return err
 	}
 
+	password, err := secrets.Get(account, "password")
+	if err != nil {
+		return err
+	}
+
 	var accessToken string
 	var lastClientPlatform string
 
@@ -90,9 +110,17 @@ func (c *Client) LoadAccountData(ctx context.Context, account *core.Record) erro
 			client.GetString("redirectURI"),
 			client.GetString("scope"),
 			account.GetString("email"),
-			account.GetString("password"),
+			password,
 			client.GetString("platform"),
 		)
+
+		var mfaErr *tokens.MFARequiredError
+		if errors.As(err, &mfaErr) {
+			if err := c.tokenManager.CreateChallenge(account.Id, client.Id, mfaErr); err != nil {
+				return err
+			}
+			continue
+		}
 		if err != nil {
 			return err
 		}
@@ -100,22 +128,27 @@ func (c *Client) LoadAccountData(ctx context.Context, account *core.Record) erro
 		accessToken = token.AccessToken
 		lastClientPlatform = clientPlatform
 
-		tokenRecord := core.NewRecord(tokensCollection)
-		tokenRecord.Set("account", account.Id)
-		tokenRecord.Set("client", client.Id)
-		tokenRecord.Set("status", "valid")
-		tokenRecord.Set("accessToken", token.AccessToken)
-		tokenRecord.Set("refreshToken", token.RefreshToken)
-
-		expiry := tokens.CalculateTokenExpiry(token.ExpiresIn)
-		tokenRecord.Set("expires", expiry)
-
-		if err := c.app.Save(tokenRecord); err != nil {
+		if _, err := c.tokenManager.SaveToken(account.Id, client.Id, token); err != nil {
 			return err
 		}
 	}
 
-	me, err := c.GetMe(ctx, accessToken, lastClientPlatform)
+	// all logins wait for a two-factor code, the homes are loaded after the first one
+	if accessToken == "" {
+		return nil
+	}
+
+	return c.loadAccountHomes(ctx, account, accessToken, lastClientPlatform)
+}
+
+// loadAccountHomes fetches the tado ID and the homes of the account.
+func (c *Client) loadAccountHomes(ctx context.Context, account *core.Record, accessToken, platform string) error {
+	homesCollection, err := c.app.FindCollectionByNameOrId("homes")
+	if err != nil {
+		return err
+	}
+
+	me, err := c.GetMe(ctx, accessToken, platform)
 	if err != nil {
 		return err
 	}
@@ -126,8 +159,8 @@ func (c *Client) LoadAccountData(ctx context.Context, account *core.Record) erro
 		if err != nil {
 			homeRecord = core.NewRecord(homesCollection)
 			homeRecord.Set("tadoID", home.ID)
-			homeRecord.Set("name", home.Name)
 		}
+		homeRecord.Set("name", home.Name)
 
 		if err := c.app.Save(homeRecord); err != nil {
 			return err
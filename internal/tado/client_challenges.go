@@ -0,0 +1,111 @@
+package tado
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// CompleteChallenge resumes the login of a two-factor challenge with the code entered in the web UI.
+// The result is stored on the challenge record, which is saved by the caller.
+func (c *Client) CompleteChallenge(ctx context.Context, challenge *core.Record) error {
+	code := challenge.GetString("code")
+	challenge.Set("code", "")
+
+	if code == "" || challenge.GetString("status") != "pending" {
+		return nil
+	}
+
+	token, err := c.auth.ResumeAuthorize(ctx, challenge.GetString("session"), code)
+	if errors.Is(err, ErrInvalidMFACode) {
+		challenge.Set("error", err.Error())
+		return nil
+	}
+	if errors.Is(err, ErrMFASessionNotFound) {
+		challenge.Set("status", "expired")
+		challenge.Set("error", err.Error())
+		return nil
+	}
+	if err != nil {
+		c.app.Logger().Error("two-factor login failed", "id", challenge.Id, "error", err)
+		challenge.Set("status", "failed")
+		challenge.Set("error", err.Error())
+		return nil
+	}
+
+	account, err := c.app.FindRecordById("accounts", challenge.GetString("account"))
+	if err != nil {
+		return err
+	}
+
+	client, err := c.app.FindRecordById("clients", challenge.GetString("client"))
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.tokenManager.SaveToken(account.Id, client.Id, token); err != nil {
+		return err
+	}
+
+	challenge.Set("status", "completed")
+	challenge.Set("error", "")
+
+	// accounts that required a code for every login don't have their homes yet
+	if account.GetString("tadoID") == "" {
+		if err := c.loadAccountHomes(ctx, account, token.AccessToken, client.GetString("platform")); err != nil {
+			return err
+		}
+	}
+
+	c.app.Logger().Info("completed two-factor login", "account", account.Id, "client", client.Id)
+	return nil
+}
+
+// ExpireChallenges marks pending challenges whose login has expired as expired.
+// If all is true, every pending challenge is expired, e.g. because the logins were lost in a restart.
+// Challenges older than a day are deleted.
+func (c *Client) ExpireChallenges(all bool) error {
+	filter := "status = 'pending' && expires < {:now}"
+	if all {
+		filter = "status = 'pending'"
+	}
+
+	challenges, err := c.app.FindRecordsByFilter(
+		"challenges",
+		filter,
+		"", 0, 0,
+		map[string]any{"now": time.Now()},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, challenge := range challenges {
+		c.auth.DropMFASession(challenge.GetString("session"))
+
+		challenge.Set("status", "expired")
+		if err := c.app.Save(challenge); err != nil {
+			return err
+		}
+	}
+
+	old, err := c.app.FindRecordsByFilter(
+		"challenges",
+		"status != 'pending' && updated < {:cutoff}",
+		"", 0, 0,
+		map[string]any{"cutoff": time.Now().Add(-24 * time.Hour)},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, challenge := range old {
+		if err := c.app.Delete(challenge); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
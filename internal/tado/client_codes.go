@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/s1adem4n/tado-api-proxy/internal/metrics"
 	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
 )
 
@@ -47,6 +48,13 @@ func (c *Client) CreateCode(e *core.RecordRequestEvent) error {
 		if err != nil {
 			c.app.Logger().Error("device authorization failed", "error", err)
 		}
+
+		// the code status is still pending if the flow failed unexpectedly
+		outcome := e.Record.GetString("status")
+		if outcome == "pending" {
+			outcome = "error"
+		}
+		metrics.DeviceCodeAuthorizations.WithLabelValues(outcome).Inc()
 	}()
 
 	return nil
@@ -0,0 +1,230 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+func (s *Server) registerAPI(mux *http.ServeMux) {
+	// the web app page is used to find the current release
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		writeHTML(w, `<script>var release={version:"4000"};</script>`)
+	})
+
+	mux.HandleFunc("GET /api/v2/me", s.authenticated(s.handleMe))
+	mux.HandleFunc("GET /api/v2/homes/{homeID}", s.authenticated(s.withHome(s.handleHome)))
+	mux.HandleFunc("GET /api/v2/homes/{homeID}/state", s.authenticated(s.withHome(s.handleHomeState)))
+	mux.HandleFunc("GET /api/v2/homes/{homeID}/zones", s.authenticated(s.withHome(s.handleZones)))
+	mux.HandleFunc("GET /api/v2/homes/{homeID}/zoneStates", s.authenticated(s.withHome(s.handleZoneStates)))
+	mux.HandleFunc("GET /api/v2/homes/{homeID}/weather", s.authenticated(s.withHome(s.handleWeather)))
+	mux.HandleFunc("PUT /api/v2/homes/{homeID}/zones/{zoneID}/overlay", s.authenticated(s.withHome(s.handleSetOverlay)))
+	mux.HandleFunc("DELETE /api/v2/homes/{homeID}/zones/{zoneID}/overlay", s.authenticated(s.withHome(s.handleDeleteOverlay)))
+
+	// hops.tado.com
+	mux.HandleFunc("GET /homes/{homeID}/rooms", s.authenticated(s.withHome(s.handleRooms)))
+}
+
+// authenticated checks the access token and the rate limit of its client,
+// and sets the ratelimit headers like tado.
+func (s *Server) authenticated(next func(http.ResponseWriter, *http.Request, *Account)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		g, ok := s.accessTokens[accessToken]
+		if !ok || time.Now().After(g.expires) {
+			s.mu.Unlock()
+			writeError(w, http.StatusUnauthorized, "Full authentication is required to access this resource")
+			return
+		}
+
+		if recorder, ok := w.(*statusRecorder); ok {
+			recorder.clientID = g.clientID
+		}
+
+		s.usage[g.clientID]++
+		used := s.usage[g.clientID]
+		s.mu.Unlock()
+
+		remaining := max(s.DailyLimit-used, 0)
+		reset := resetSeconds()
+
+		w.Header().Set("Ratelimit-Policy", fmt.Sprintf(`"perday";q=%d;w=86400`, s.DailyLimit))
+		w.Header().Set("Ratelimit", fmt.Sprintf(`"perday";r=%d;t=%d`, remaining, reset))
+
+		if used > s.DailyLimit {
+			writeError(w, http.StatusTooManyRequests, "Too many requests")
+			return
+		}
+
+		next(w, r, g.account)
+	}
+}
+
+// withHome resolves the home of the request and checks that the account can access it.
+func (s *Server) withHome(next func(http.ResponseWriter, *http.Request, *Home)) func(http.ResponseWriter, *http.Request, *Account) {
+	return func(w http.ResponseWriter, r *http.Request, account *Account) {
+		homeID, err := strconv.Atoi(r.PathValue("homeID"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "home not found")
+			return
+		}
+
+		for i := range account.Homes {
+			if account.Homes[i].ID == homeID {
+				next(w, r, &account.Homes[i])
+				return
+			}
+		}
+
+		writeError(w, http.StatusForbidden, "Access denied")
+	}
+}
+
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request, account *Account) {
+	homes := make([]map[string]any, 0, len(account.Homes))
+	for _, home := range account.Homes {
+		homes = append(homes, map[string]any{"id": home.ID, "name": home.Name})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":    account.ID,
+		"email": account.Email,
+		"name":  account.Email,
+		"homes": homes,
+	})
+}
+
+func (s *Server) handleHome(w http.ResponseWriter, r *http.Request, home *Home) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":   home.ID,
+		"name": home.Name,
+	})
+}
+
+func (s *Server) handleHomeState(w http.ResponseWriter, r *http.Request, home *Home) {
+	writeJSON(w, http.StatusOK, map[string]any{"presence": "HOME", "presenceLocked": false})
+}
+
+func (s *Server) handleZones(w http.ResponseWriter, r *http.Request, home *Home) {
+	zones := make([]map[string]any, 0, len(home.Zones))
+	for _, zone := range home.Zones {
+		zones = append(zones, map[string]any{"id": zone.ID, "name": zone.Name, "type": "HEATING"})
+	}
+
+	writeJSON(w, http.StatusOK, zones)
+}
+
+func (s *Server) handleZoneStates(w http.ResponseWriter, r *http.Request, home *Home) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := map[string]any{}
+	for _, zone := range home.Zones {
+		setting := map[string]any{
+			"type":        "HEATING",
+			"power":       "ON",
+			"temperature": map[string]any{"celsius": zone.Setpoint},
+		}
+		var overlayType any
+
+		overlay := s.overlays[overlayKey(home.ID, strconv.Itoa(zone.ID))]
+		if overlay != nil {
+			var parsed struct {
+				Setting map[string]any `json:"setting"`
+			}
+			if err := json.Unmarshal(overlay, &parsed); err == nil && parsed.Setting != nil {
+				setting = parsed.Setting
+			}
+			overlayType = "MANUAL"
+		}
+
+		var openWindow any
+		if zone.WindowOpen {
+			openWindow = map[string]any{"durationInSeconds": 900, "remainingTimeInSeconds": 600}
+		}
+
+		states[strconv.Itoa(zone.ID)] = map[string]any{
+			"tadoMode":    "HOME",
+			"setting":     setting,
+			"overlayType": overlayType,
+			"overlay":     overlay,
+			"openWindow":  openWindow,
+			"activityDataPoints": map[string]any{
+				"heatingPower": map[string]any{"type": "PERCENTAGE", "percentage": zone.HeatingPower},
+			},
+			"sensorDataPoints": map[string]any{
+				"insideTemperature": map[string]any{"celsius": zone.Temperature},
+				"humidity":          map[string]any{"type": "PERCENTAGE", "percentage": zone.Humidity},
+			},
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"zoneStates": states})
+}
+
+func (s *Server) handleWeather(w http.ResponseWriter, r *http.Request, home *Home) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"outsideTemperature": map[string]any{"celsius": 12.5},
+		"weatherState":       map[string]any{"value": "CLOUDY"},
+	})
+}
+
+func (s *Server) handleSetOverlay(w http.ResponseWriter, r *http.Request, home *Home) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil || !json.Valid(body) {
+		writeError(w, http.StatusUnprocessableEntity, "invalid overlay")
+		return
+	}
+
+	s.mu.Lock()
+	s.overlays[overlayKey(home.ID, r.PathValue("zoneID"))] = body
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func (s *Server) handleDeleteOverlay(w http.ResponseWriter, r *http.Request, home *Home) {
+	s.mu.Lock()
+	delete(s.overlays, overlayKey(home.ID, r.PathValue("zoneID")))
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleRooms(w http.ResponseWriter, r *http.Request, home *Home) {
+	rooms := make([]map[string]any, 0, len(home.Zones))
+	for _, zone := range home.Zones {
+		rooms = append(rooms, map[string]any{
+			"id":   zone.ID,
+			"name": zone.Name,
+			"sensorDataPoints": map[string]any{
+				"insideTemperature": map[string]any{"value": zone.Temperature},
+			},
+		})
+	}
+
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+func overlayKey(homeID int, zoneID string) string {
+	return strconv.Itoa(homeID) + "/" + zoneID
+}
+
+// resetSeconds returns the seconds until the daily rate limit reset of tado.
+func resetSeconds() int {
+	cutoff, err := tokens.GetRatelimitCutoff()
+	if err != nil {
+		return 0
+	}
+
+	return int(time.Until(cutoff.Add(24 * time.Hour)).Seconds())
+}
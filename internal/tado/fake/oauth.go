@@ -0,0 +1,288 @@
+package fake
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// deviceCodeLifetime is how long a device code can be authorized.
+const deviceCodeLifetime = 5 * time.Minute
+
+// authCode is an authorization code issued after a successful login.
+type authCode struct {
+	clientID      string
+	account       *Account
+	codeChallenge string
+	redirectURI   string
+}
+
+// twoFactorLogin is a login waiting for the two-factor code.
+type twoFactorLogin struct {
+	account *Account
+	params  url.Values
+}
+
+// deviceCode is a device code authorization.
+type deviceCode struct {
+	clientID string
+	userCode string
+	account  *Account
+	expires  time.Time
+}
+
+func (s *Server) registerOAuth(mux *http.ServeMux) {
+	mux.HandleFunc("GET /oauth2/authorize", s.handleLoginPage)
+	mux.HandleFunc("POST /oauth2/authorize", s.handleLogin)
+	mux.HandleFunc("POST /oauth2/two-factor", s.handleTwoFactor)
+	mux.HandleFunc("POST /oauth2/token", s.handleToken)
+	mux.HandleFunc("POST /oauth2/device_authorize", s.handleDeviceAuthorize)
+	mux.HandleFunc("GET /oauth2/device", s.handleDevicePage)
+	mux.HandleFunc("POST /oauth2/device", s.handleDeviceApproval)
+}
+
+// ApproveDeviceCode authorizes the device code with the user code for the account,
+// like a user confirming it in the browser.
+func (s *Server) ApproveDeviceCode(userCode, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[email]
+	if !ok {
+		return fmt.Errorf("unknown account %s", email)
+	}
+
+	for _, code := range s.deviceCodes {
+		if code.userCode == userCode {
+			code.account = account
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown user code %s", userCode)
+}
+
+func (s *Server) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: "fusionauth.sso", Value: randomString(8), Path: "/"})
+	writeHTML(w, `<form action="/oauth2/authorize" method="POST"></form>`)
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[r.PostForm.Get("loginId")]
+	if !ok || account.Password != r.PostForm.Get("password") {
+		writeHTML(w, `<form action="/oauth2/authorize" method="POST"><span class="error">Invalid login credentials.</span></form>`)
+		return
+	}
+	if account.Locked {
+		writeHTML(w, `<form action="/oauth2/authorize" method="POST"><span class="error">Your account has been locked.</span></form>`)
+		return
+	}
+
+	if account.MFACode != "" {
+		twoFactorID := randomString(16)
+		s.twoFactor[twoFactorID] = &twoFactorLogin{account: account, params: r.PostForm}
+		writeTwoFactorForm(w, twoFactorID, r.PostForm, "")
+		return
+	}
+
+	s.redirectWithCode(w, r.PostForm, account)
+}
+
+func (s *Server) handleTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	twoFactorID := r.PostForm.Get("twoFactorId")
+	login, ok := s.twoFactor[twoFactorID]
+	if !ok {
+		writeHTML(w, `<form action="/oauth2/authorize" method="POST"><span class="error">Your login has expired.</span></form>`)
+		return
+	}
+
+	if r.PostForm.Get("code") != login.account.MFACode {
+		writeTwoFactorForm(w, twoFactorID, login.params, "Invalid code.")
+		return
+	}
+
+	delete(s.twoFactor, twoFactorID)
+	s.redirectWithCode(w, login.params, login.account)
+}
+
+// redirectWithCode redirects a successful login to the redirect URI with an authorization code.
+// The caller must hold the mutex.
+func (s *Server) redirectWithCode(w http.ResponseWriter, params url.Values, account *Account) {
+	code := randomString(16)
+	s.authCodes[code] = &authCode{
+		clientID:      params.Get("client_id"),
+		account:       account,
+		codeChallenge: params.Get("code_challenge"),
+		redirectURI:   params.Get("redirect_uri"),
+	}
+
+	location, err := url.Parse(params.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := location.Query()
+	q.Set("code", code)
+	q.Set("state", params.Get("state"))
+	location.RawQuery = q.Encode()
+
+	w.Header().Set("Location", location.String())
+	w.WriteHeader(http.StatusFound)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clientID := r.PostForm.Get("client_id")
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, ok := s.authCodes[r.PostForm.Get("code")]
+		if !ok || code.clientID != clientID || code.redirectURI != r.PostForm.Get("redirect_uri") {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+
+		hash := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(hash[:]) != code.codeChallenge {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+
+		delete(s.authCodes, r.PostForm.Get("code"))
+		writeJSON(w, http.StatusOK, s.issueTokens(clientID, code.account))
+
+	case "refresh_token":
+		refreshToken := r.PostForm.Get("refresh_token")
+		previous, ok := s.refreshTokens[refreshToken]
+		if !ok || previous.clientID != clientID || time.Now().After(previous.expires) {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+
+		// refresh tokens are rotated like by tado
+		delete(s.refreshTokens, refreshToken)
+		writeJSON(w, http.StatusOK, s.issueTokens(clientID, previous.account))
+
+	case "urn:ietf:params:oauth:grant-type:device_code":
+		code, ok := s.deviceCodes[r.PostForm.Get("device_code")]
+		switch {
+		case !ok || code.clientID != clientID:
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+		case time.Now().After(code.expires):
+			writeOAuthError(w, http.StatusBadRequest, "expired_token")
+		case code.account == nil:
+			writeOAuthError(w, http.StatusBadRequest, "authorization_pending")
+		default:
+			delete(s.deviceCodes, r.PostForm.Get("device_code"))
+			writeJSON(w, http.StatusOK, s.issueTokens(clientID, code.account))
+		}
+
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type")
+	}
+}
+
+func (s *Server) handleDeviceAuthorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code := randomString(16)
+	userCode := strings.ToUpper(randomString(3))
+	// tado accepts the client in the query, where the proxy sends it, or in the body
+	s.deviceCodes[code] = &deviceCode{
+		clientID: r.Form.Get("client_id"),
+		userCode: userCode,
+		expires:  time.Now().Add(deviceCodeLifetime),
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"device_code":               code,
+		"user_code":                 userCode,
+		"verification_uri":          s.URL + "/oauth2/device",
+		"verification_uri_complete": s.URL + "/oauth2/device?user_code=" + userCode,
+		"expires_in":                int(deviceCodeLifetime.Seconds()),
+		"interval":                  5,
+	})
+}
+
+// handleDevicePage shows the verification page of a device code,
+// where the account to authorize can be entered.
+func (s *Server) handleDevicePage(w http.ResponseWriter, r *http.Request) {
+	writeHTML(w, fmt.Sprintf(
+		`<form action="/oauth2/device" method="POST">`+
+			`<input type="hidden" name="user_code" value="%s">`+
+			`<input type="email" name="loginId" placeholder="Email">`+
+			`<button type="submit">Authorize</button></form>`,
+		html.EscapeString(r.URL.Query().Get("user_code")),
+	))
+}
+
+func (s *Server) handleDeviceApproval(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.ApproveDeviceCode(r.PostForm.Get("user_code"), r.PostForm.Get("loginId")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeHTML(w, `<p>Device authorized.</p>`)
+}
+
+// writeTwoFactorForm writes a two-factor page like the tado login, keeping the OAuth parameters.
+func writeTwoFactorForm(w http.ResponseWriter, twoFactorID string, params url.Values, message string) {
+	var b strings.Builder
+	b.WriteString(`<form id="2fa-form" action="/oauth2/two-factor" method="POST">`)
+	for _, key := range []string{"client_id", "code_challenge", "code_challenge_method", "redirect_uri", "response_type", "scope", "state"} {
+		fmt.Fprintf(&b, `<input type="hidden" name="%s" value="%s">`, key, html.EscapeString(params.Get(key)))
+	}
+	fmt.Fprintf(&b, `<input type="hidden" name="twoFactorId" value="%s">`, twoFactorID)
+	if message != "" {
+		fmt.Fprintf(&b, `<span class="error">%s</span>`, html.EscapeString(message))
+	}
+	b.WriteString(`<input type="text" name="code"><input type="checkbox" name="trustComputer" value="true"></form>`)
+
+	writeHTML(w, b.String())
+}
+
+func writeHTML(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "<!DOCTYPE html><html><body>%s</body></html>", body)
+}
@@ -0,0 +1,308 @@
+// Package fake provides an in-process fake of the tado login, API and hops servers,
+// so the proxy can be run and tested without network access.
+//
+// All services are served from the same base URL, which can be passed to tado.SetBaseURL.
+package fake
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+// Account is a tado account known to the fake server.
+type Account struct {
+	ID       string
+	Email    string
+	Password string
+	// MFACode is the two-factor code of the account. If set, every login asks for it.
+	MFACode string
+	// Locked rejects every login of the account like a locked account.
+	Locked bool
+	Homes  []Home
+}
+
+// Home is a home of an account.
+type Home struct {
+	ID    int
+	Name  string
+	Zones []Zone
+}
+
+// Zone is a heating zone of a home.
+type Zone struct {
+	ID          int
+	Name        string
+	Temperature float64
+	Humidity    float64
+	// Setpoint is the temperature of the schedule, overlays replace it
+	Setpoint     float64
+	HeatingPower float64
+	WindowOpen   bool
+}
+
+// Request is a request received by the fake server.
+type Request struct {
+	Method string
+	Path   string
+	// ClientID is the client of the access token used for API requests.
+	ClientID string
+	Status   int
+}
+
+// grant is an issued access or refresh token.
+type grant struct {
+	clientID string
+	account  *Account
+	expires  time.Time
+}
+
+// failure is a scripted failure of matching requests.
+type failure struct {
+	method    string
+	path      *regexp.Regexp
+	status    int
+	header    http.Header
+	remaining int
+}
+
+// Server is the fake tado server. Create it with NewServer and close it after use.
+type Server struct {
+	*httptest.Server
+
+	// TokenLifetime is the lifetime of issued access tokens.
+	TokenLifetime time.Duration
+	// RefreshTokenLifetime is the lifetime of issued refresh tokens.
+	RefreshTokenLifetime time.Duration
+	// DailyLimit is the number of API requests each client can make per day.
+	DailyLimit int
+
+	mu            sync.Mutex
+	accounts      map[string]*Account
+	authCodes     map[string]*authCode
+	twoFactor     map[string]*twoFactorLogin
+	deviceCodes   map[string]*deviceCode
+	accessTokens  map[string]*grant
+	refreshTokens map[string]*grant
+	overlays      map[string]json.RawMessage
+	usage         map[string]int
+	failures      []*failure
+	requests      []Request
+}
+
+// NewServer starts a new fake server without accounts.
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer creates a new fake server without starting it,
+// e.g. to replace its listener before calling Start.
+func NewUnstartedServer() *Server {
+	s := &Server{
+		TokenLifetime:        10 * time.Minute,
+		RefreshTokenLifetime: 30 * 24 * time.Hour,
+		DailyLimit:           1000,
+		accounts:             make(map[string]*Account),
+		authCodes:            make(map[string]*authCode),
+		twoFactor:            make(map[string]*twoFactorLogin),
+		deviceCodes:          make(map[string]*deviceCode),
+		accessTokens:         make(map[string]*grant),
+		refreshTokens:        make(map[string]*grant),
+		overlays:             make(map[string]json.RawMessage),
+		usage:                make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	s.registerOAuth(mux)
+	s.registerAPI(mux)
+
+	s.Server = httptest.NewUnstartedServer(s.middleware(mux))
+	return s
+}
+
+// AddAccount adds an account that can log in.
+func (s *Server) AddAccount(account Account) *Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if account.ID == "" {
+		account.ID = randomString(4)
+	}
+
+	s.accounts[account.Email] = &account
+	return &account
+}
+
+// FailNext makes the next n requests with the method and a path matching the pattern
+// fail with the status. An empty method matches all methods.
+func (s *Server) FailNext(method, pathPattern string, status, n int, header http.Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, &failure{
+		method:    method,
+		path:      regexp.MustCompile(pathPattern),
+		status:    status,
+		header:    header,
+		remaining: n,
+	})
+}
+
+// RevokeAccessTokens invalidates all issued access tokens, so API requests fail with 401.
+func (s *Server) RevokeAccessTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clear(s.accessTokens)
+}
+
+// ExpireRefreshTokens lets all issued refresh tokens expire, so refreshes fail with invalid_grant.
+func (s *Server) ExpireRefreshTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, refreshToken := range s.refreshTokens {
+		refreshToken.expires = time.Now()
+	}
+}
+
+// Usage returns the API requests made with the client since the last reset.
+func (s *Server) Usage(clientID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.usage[clientID]
+}
+
+// ResetUsage resets the rate limits of all clients, like the daily reset of tado.
+func (s *Server) ResetUsage() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clear(s.usage)
+}
+
+// Requests returns all requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+// middleware records all requests and applies the scripted failures.
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		if f := s.takeFailure(r); f != nil {
+			for key, values := range f.header {
+				w.Header()[key] = values
+			}
+			writeError(recorder, f.status, "scripted failure")
+		} else {
+			next.ServeHTTP(recorder, r)
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			ClientID: recorder.clientID,
+			Status:   recorder.status,
+		})
+		s.mu.Unlock()
+	})
+}
+
+func (s *Server) takeFailure(r *http.Request) *failure {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, f := range s.failures {
+		if (f.method == "" || f.method == r.Method) && f.path.MatchString(r.URL.Path) {
+			f.remaining--
+			if f.remaining <= 0 {
+				s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			}
+			return f
+		}
+	}
+
+	return nil
+}
+
+// issueTokens creates a new access and refresh token.
+// The caller must hold the mutex.
+func (s *Server) issueTokens(clientID string, account *Account) tokens.TokenResult {
+	accessToken := randomString(32)
+	refreshToken := randomString(32)
+
+	s.accessTokens[accessToken] = &grant{
+		clientID: clientID,
+		account:  account,
+		expires:  time.Now().Add(s.TokenLifetime),
+	}
+	s.refreshTokens[refreshToken] = &grant{
+		clientID: clientID,
+		account:  account,
+		expires:  time.Now().Add(s.RefreshTokenLifetime),
+	}
+
+	return tokens.TokenResult{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(s.TokenLifetime.Seconds()),
+	}
+}
+
+// statusRecorder records the response status and the client of API requests.
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	clientID string
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the format of the tado API.
+func writeError(w http.ResponseWriter, status int, title string) {
+	writeJSON(w, status, map[string]any{
+		"errors": []map[string]string{
+			{"code": http.StatusText(status), "title": title},
+		},
+	})
+}
+
+// writeOAuthError writes an error in the format of the OAuth endpoints.
+func writeOAuthError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{
+		"error":             code,
+		"error_description": fmt.Sprintf("fake server: %s", code),
+	})
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
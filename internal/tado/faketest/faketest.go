@@ -0,0 +1,188 @@
+// Package faketest sets up the tests that run against a migrated PocketBase app
+// and the fake tado server.
+package faketest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/fake"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+	_ "github.com/s1adem4n/tado-api-proxy/migrations"
+)
+
+// migrated holds the database files of an app after all migrations, so each test app starts
+// from a copy instead of migrating again, which takes seconds with the race detector.
+var migrated struct {
+	once  sync.Once
+	files map[string][]byte
+	err   error
+}
+
+// migratedFiles migrates an app once and returns its database files.
+func migratedFiles() (map[string][]byte, error) {
+	migrated.once.Do(func() {
+		empty, err := os.MkdirTemp("", "faketest")
+		if err != nil {
+			migrated.err = err
+			return
+		}
+		defer os.RemoveAll(empty)
+
+		app, err := tests.NewTestApp(empty)
+		if err != nil {
+			migrated.err = err
+			return
+		}
+		defer app.Cleanup()
+
+		// close the databases, so their files contain all migrations
+		if err := app.ResetBootstrapState(); err != nil {
+			migrated.err = err
+			return
+		}
+
+		entries, err := os.ReadDir(app.DataDir())
+		if err != nil {
+			migrated.err = err
+			return
+		}
+
+		migrated.files = make(map[string][]byte)
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(app.DataDir(), entry.Name()))
+			if err != nil {
+				migrated.err = err
+				return
+			}
+			migrated.files[entry.Name()] = data
+		}
+	})
+
+	return migrated.files, migrated.err
+}
+
+// NewApp returns a migrated app in a temporary directory, which is cleaned up after the test.
+// PocketBase can't decode its collections with the encoding/json v2 experiment, so the test
+// fails if the toolchain enables it.
+func NewApp(t testing.TB) *tests.TestApp {
+	t.Helper()
+
+	if jsonV2 {
+		t.Fatal("run the tests with GOEXPERIMENT=nojsonv2")
+	}
+
+	files, err := migratedFiles()
+	if err != nil {
+		t.Fatalf("failed to migrate test app: %v", err)
+	}
+
+	dir := t.TempDir()
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatalf("failed to copy test app: %v", err)
+		}
+	}
+
+	app, err := tests.NewTestApp(dir)
+	if err != nil {
+		t.Fatalf("failed to create test app: %v", err)
+	}
+	t.Cleanup(app.Cleanup)
+
+	return app
+}
+
+// NewRecord saves a record with the data in the collection.
+func NewRecord(t testing.TB, app core.App, collection string, data map[string]any) *core.Record {
+	t.Helper()
+
+	c, err := app.FindCollectionByNameOrId(collection)
+	if err != nil {
+		t.Fatalf("failed to find collection %s: %v", collection, err)
+	}
+
+	record := core.NewRecord(c)
+	record.Load(data)
+	if err := app.Save(record); err != nil {
+		t.Fatalf("failed to save %s record: %v", collection, err)
+	}
+	return record
+}
+
+// NewServer starts a fake server with the accounts and points the tado services to it
+// until the test ends.
+func NewServer(t testing.TB, accounts ...fake.Account) *fake.Server {
+	t.Helper()
+
+	server := fake.NewServer()
+	t.Cleanup(server.Close)
+
+	for _, account := range accounts {
+		server.AddAccount(account)
+	}
+
+	apiURL, loginURL, hopsURL, appURL := tado.APIURL, tado.LoginURL, tado.HopsURL, tado.AppURL
+	tado.SetBaseURL(server.URL)
+	t.Cleanup(func() {
+		tado.APIURL, tado.LoginURL, tado.HopsURL, tado.AppURL = apiURL, loginURL, hopsURL, appURL
+	})
+
+	return server
+}
+
+// NewClient saves a password grant client of the platform with the daily limit.
+func NewClient(t testing.TB, app core.App, platform string, dailyLimit int) *core.Record {
+	t.Helper()
+
+	return NewRecord(t, app, "clients", map[string]any{
+		"name":        platform,
+		"clientID":    platform + "-client",
+		"redirectURI": "https://app.tado.com/en/main/home",
+		"scope":       "home.user offline_access",
+		"type":        "passwordGrant",
+		"platform":    platform,
+		"dailyLimit":  dailyLimit,
+	})
+}
+
+// Authorize logs in to the account with the client and returns the issued tokens.
+func Authorize(t testing.TB, auth *tado.Auth, client *core.Record, email, password string) *tokens.TokenResult {
+	t.Helper()
+
+	issued, err := auth.Authorize(
+		context.Background(),
+		client.GetString("clientID"), client.GetString("redirectURI"), client.GetString("scope"),
+		email, password, client.GetString("platform"),
+	)
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+	return issued
+}
+
+// NewToken logs in to the account with the client and saves the issued tokens, which expire at the time.
+func NewToken(t testing.TB, app core.App, auth *tado.Auth, account, client *core.Record, password string, expires time.Time) *core.Record {
+	t.Helper()
+
+	issued := Authorize(t, auth, client, account.GetString("email"), password)
+
+	return NewRecord(t, app, "tokens", map[string]any{
+		"account":      account.Id,
+		"client":       client.Id,
+		"status":       "valid",
+		"accessToken":  issued.AccessToken,
+		"refreshToken": issued.RefreshToken,
+		"expires":      expires,
+	})
+}
@@ -239,7 +239,7 @@ func NewFirefoxTokenClient() *req.Client {
 func GetWebAppRelease() string {
 	client := NewFirefoxClient()
 
-	resp, err := client.R().Get("https://app.tado.com/")
+	resp, err := client.R().Get(AppURL + "/")
 	if err != nil {
 		slog.Error("failed to get web app release", "error", err)
 		return "tado=webapp-3847"
@@ -0,0 +1,36 @@
+package tado
+
+import "strings"
+
+// BaseURLEnv can point all tado services to a single server, e.g. the fake server in internal/tado/fake.
+const BaseURLEnv = "TADO_BASE_URL"
+
+// Base URLs of the tado services. They must only be changed before the first request.
+var (
+	LoginURL = "https://login.tado.com"
+	APIURL   = "https://my.tado.com"
+	HopsURL  = "https://hops.tado.com"
+	AppURL   = "https://app.tado.com"
+)
+
+// SetBaseURL points all tado services to a single server.
+func SetBaseURL(baseURL string) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	LoginURL = baseURL
+	APIURL = baseURL
+	HopsURL = baseURL
+	AppURL = baseURL
+}
+
+func tokenURL() string {
+	return LoginURL + "/oauth2/token"
+}
+
+func authorizeURL() string {
+	return LoginURL + "/oauth2/authorize"
+}
+
+func deviceAuthURL() string {
+	return LoginURL + "/oauth2/device_authorize"
+}
@@ -0,0 +1,87 @@
+package tokens
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// The circuit breaker of a token stops password grant logins after they failed, so a broken token
+// doesn't log in on every request. Each failed login opens the breaker for an exponentially growing
+// time without any login. Afterwards it is half-open and lets a single login through as a probe,
+// which closes the breaker if it succeeds. The state is stored on the token record, so it survives restarts.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "halfOpen"
+
+	minBreakerBackoff = time.Minute
+	maxBreakerBackoff = 6 * time.Hour
+)
+
+// BreakerOpenError is returned instead of logging in while the circuit breaker of a token is open.
+type BreakerOpenError struct {
+	Until time.Time
+}
+
+func (e *BreakerOpenError) Error() string {
+	return fmt.Sprintf("logins paused after repeated failures until %s", e.Until.Format(time.RFC3339))
+}
+
+// checkBreaker returns a BreakerOpenError if the circuit breaker of the token is open.
+func checkBreaker(tokenRecord *core.Record) error {
+	if tokenRecord.GetString("breakerState") != breakerOpen {
+		return nil
+	}
+
+	until := tokenRecord.GetDateTime("breakerOpenUntil").Time()
+	if time.Now().Before(until) {
+		return &BreakerOpenError{Until: until}
+	}
+
+	return nil
+}
+
+// probeBreaker checks whether the token may log in and half-opens its breaker once the open time
+// passed. The caller must hold the token mutex, so concurrent requests wait for the result
+// of the probe instead of logging in themselves.
+func (m *Manager) probeBreaker(tokenRecord *core.Record) error {
+	if err := checkBreaker(tokenRecord); err != nil {
+		return err
+	}
+	if tokenRecord.GetString("breakerState") != breakerOpen {
+		return nil
+	}
+
+	tokenRecord.Set("breakerState", breakerHalfOpen)
+	if err := m.app.Save(tokenRecord); err != nil {
+		return err
+	}
+
+	m.app.Logger().Info("probing login after repeated failures", "id", tokenRecord.Id, "failures", tokenRecord.GetInt("breakerFailures"))
+	return nil
+}
+
+// openBreaker opens the breaker of the token after a failed login.
+func openBreaker(tokenRecord *core.Record) {
+	failures := tokenRecord.GetInt("breakerFailures") + 1
+
+	tokenRecord.Set("breakerState", breakerOpen)
+	tokenRecord.Set("breakerFailures", failures)
+	tokenRecord.Set("breakerOpenUntil", time.Now().Add(breakerBackoff(failures)))
+}
+
+// closeBreaker closes the breaker of the token after a successful refresh or login.
+func closeBreaker(tokenRecord *core.Record) {
+	tokenRecord.Set("breakerState", breakerClosed)
+	tokenRecord.Set("breakerFailures", 0)
+	tokenRecord.Set("breakerOpenUntil", nil)
+}
+
+// breakerBackoff returns how long the breaker stays open after the given number of failed logins in a row.
+func breakerBackoff(failures int) time.Duration {
+	backoff := min(minBreakerBackoff<<min(max(failures-1, 0), 10), maxBreakerBackoff)
+	return backoff + rand.N(backoff/5)
+}
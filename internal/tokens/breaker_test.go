@@ -0,0 +1,156 @@
+package tokens_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/fake"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+// countLogins returns the number of submitted logins.
+func countLogins(server *fake.Server) int {
+	n := 0
+	for _, r := range server.Requests() {
+		if r.Method == http.MethodPost && r.Path == "/oauth2/authorize" {
+			n++
+		}
+	}
+	return n
+}
+
+// failLogin makes the login of the token fail and returns the breaker error of the next use.
+func failLogin(t *testing.T, env *testEnv) *tokens.BreakerOpenError {
+	t.Helper()
+
+	env.server.ExpireRefreshTokens()
+	env.server.AddAccount(fake.Account{Email: testEmail, Password: "changed"})
+
+	if _, err := env.manager.GetValidToken(context.Background(), env.token); err == nil {
+		t.Fatal("GetValidToken() with a wrong password succeeded")
+	}
+
+	var breakerErr *tokens.BreakerOpenError
+	if _, err := env.manager.GetValidToken(context.Background(), reloadToken(t, env)); !errors.As(err, &breakerErr) {
+		t.Fatalf("GetValidToken() after a failed login error = %v, want a BreakerOpenError", err)
+	}
+	return breakerErr
+}
+
+// halfOpen lets the open time of the breaker pass.
+func halfOpen(t *testing.T, env *testEnv) {
+	t.Helper()
+
+	token := reloadToken(t, env)
+	token.Set("breakerOpenUntil", time.Now().Add(-time.Second))
+	if err := env.app.Save(token); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBreakerOpensAfterFailedLogin(t *testing.T) {
+	env := newTestEnv(t)
+	logins := countLogins(env.server)
+
+	breakerErr := failLogin(t, env)
+
+	if n := countLogins(env.server) - logins; n != 1 {
+		t.Errorf("%d logins, want 1 and none while the breaker is open", n)
+	}
+	if !breakerErr.Until.After(time.Now()) {
+		t.Errorf("breaker open until %v, want a time in the future", breakerErr.Until)
+	}
+
+	token := reloadToken(t, env)
+	if token.GetString("breakerState") != "open" || token.GetInt("breakerFailures") != 1 {
+		t.Errorf("breakerState = %q, breakerFailures = %d, want open after 1 failure",
+			token.GetString("breakerState"), token.GetInt("breakerFailures"))
+	}
+}
+
+func TestBreakerClosesAfterSuccessfulProbe(t *testing.T) {
+	env := newTestEnv(t)
+	failLogin(t, env)
+
+	env.server.AddAccount(fake.Account{Email: testEmail, Password: testPassword})
+	halfOpen(t, env)
+
+	token, err := env.manager.GetValidToken(context.Background(), reloadToken(t, env))
+	if err != nil {
+		t.Fatalf("GetValidToken() error = %v", err)
+	}
+	if token.GetString("status") != "valid" {
+		t.Errorf("status = %q, want valid", token.GetString("status"))
+	}
+	if token.GetString("breakerState") != "closed" || token.GetInt("breakerFailures") != 0 {
+		t.Errorf("breakerState = %q, breakerFailures = %d, want closed",
+			token.GetString("breakerState"), token.GetInt("breakerFailures"))
+	}
+}
+
+func TestBreakerLetsOneProbeThrough(t *testing.T) {
+	env := newTestEnv(t)
+	first := failLogin(t, env)
+	halfOpen(t, env)
+	logins := countLogins(env.server)
+
+	var wg sync.WaitGroup
+	for range 5 {
+		token := reloadToken(t, env)
+		wg.Go(func() {
+			env.manager.GetValidToken(context.Background(), token)
+		})
+	}
+	wg.Wait()
+
+	if n := countLogins(env.server) - logins; n != 1 {
+		t.Errorf("%d logins while half-open, want a single probe", n)
+	}
+
+	// the failed probe opens the breaker for longer
+	token := reloadToken(t, env)
+	if token.GetString("breakerState") != "open" || token.GetInt("breakerFailures") != 2 {
+		t.Fatalf("breakerState = %q, breakerFailures = %d, want open after 2 failures",
+			token.GetString("breakerState"), token.GetInt("breakerFailures"))
+	}
+	firstBackoff := time.Until(first.Until)
+	if backoff := time.Until(token.GetDateTime("breakerOpenUntil").Time()); backoff <= firstBackoff {
+		t.Errorf("breaker open for %v after the second failure, want longer than %v", backoff, firstBackoff)
+	}
+}
+
+func TestFailedRefreshAndLoginCountOnce(t *testing.T) {
+	env := newTestEnv(t)
+	env.server.ExpireRefreshTokens()
+	env.server.AddAccount(fake.Account{Email: testEmail, Password: "changed"})
+
+	for want := 1; want <= 2; want++ {
+		if want > 1 {
+			// let the refresh backoff and the breaker pass
+			halfOpen(t, env)
+			token := reloadToken(t, env)
+			token.Set("nextRefresh", time.Now().Add(-time.Second))
+			if err := env.app.Save(token); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		logins := countLogins(env.server)
+		if err := env.manager.RefreshDueTokens(context.Background()); err != nil {
+			t.Fatalf("RefreshDueTokens() error = %v", err)
+		}
+		if n := countLogins(env.server) - logins; n != 1 {
+			t.Fatalf("%d logins after the rejected refresh token, want 1", n)
+		}
+
+		// the rejected refresh token and the failed login are a single attempt
+		token := reloadToken(t, env)
+		if got := token.GetInt("refreshFailures"); got != want {
+			t.Errorf("refreshFailures = %d after %d attempts, want %d", got, want, want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package tokens
+
+import (
+	"errors"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ErrChallengePending is returned if a login still waits for a two-factor code,
+// so no new login (and no new code) is requested.
+var ErrChallengePending = errors.New("waiting for the two-factor code to be entered in the web UI")
+
+// CreateChallenge stores a pending two-factor challenge for the login of the account with the client,
+// so the code can be entered in the web UI. Older pending challenges of the same login are expired.
+func (m *Manager) CreateChallenge(accountID, clientID string, mfaErr *MFARequiredError) error {
+	collection, err := m.app.FindCollectionByNameOrId("challenges")
+	if err != nil {
+		return err
+	}
+
+	pending, err := m.app.FindRecordsByFilter(
+		"challenges",
+		"account = {:accountID} && client = {:clientID} && status = 'pending'",
+		"", 0, 0,
+		map[string]any{"accountID": accountID, "clientID": clientID},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, challenge := range pending {
+		challenge.Set("status", "expired")
+		if err := m.app.Save(challenge); err != nil {
+			return err
+		}
+	}
+
+	challenge := core.NewRecord(collection)
+	challenge.Set("account", accountID)
+	challenge.Set("client", clientID)
+	challenge.Set("session", mfaErr.Session)
+	challenge.Set("status", "pending")
+	challenge.Set("expires", mfaErr.Expires)
+	if err := m.app.Save(challenge); err != nil {
+		return err
+	}
+
+	m.app.Logger().Info("login requires two-factor authentication", "account", accountID, "client", clientID)
+	return nil
+}
+
+// hasPendingChallenge reports whether a login of the account with the client waits for a two-factor code.
+func (m *Manager) hasPendingChallenge(accountID, clientID string) bool {
+	challenge, err := m.app.FindFirstRecordByFilter(
+		"challenges",
+		"account = {:accountID} && client = {:clientID} && status = 'pending' && expires > {:now}",
+		map[string]any{"accountID": accountID, "clientID": clientID, "now": time.Now()},
+	)
+	return err == nil && challenge != nil
+}
+
+// SaveToken stores the token of a new login of the account with the client.
+// An existing token of the same account and client is replaced.
+func (m *Manager) SaveToken(accountID, clientID string, token *TokenResult) (*core.Record, error) {
+	tokenRecord, err := m.app.FindFirstRecordByFilter(
+		"tokens",
+		"account = {:accountID} && client = {:clientID}",
+		map[string]any{"accountID": accountID, "clientID": clientID},
+	)
+	if err != nil {
+		collection, err := m.app.FindCollectionByNameOrId("tokens")
+		if err != nil {
+			return nil, err
+		}
+		tokenRecord = core.NewRecord(collection)
+	}
+
+	if !tokenRecord.IsNew() {
+		mu := m.getTokenMutex(tokenRecord.Id)
+		mu.Lock()
+		defer mu.Unlock()
+	}
+
+	tokenRecord.Set("account", accountID)
+	tokenRecord.Set("client", clientID)
+	tokenRecord.Set("status", "valid")
+	tokenRecord.Set("accessToken", token.AccessToken)
+	tokenRecord.Set("refreshToken", token.RefreshToken)
+	tokenRecord.Set("expires", CalculateTokenExpiry(token.ExpiresIn))
+	recordRefreshSuccess(tokenRecord)
+
+	if err := m.app.Save(tokenRecord); err != nil {
+		return nil, err
+	}
+
+	return tokenRecord, nil
+}
@@ -0,0 +1,83 @@
+package tokens_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/fake"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+func TestGetValidTokenWaitsForTwoFactorCode(t *testing.T) {
+	env := newTestEnv(t)
+	env.server.ExpireRefreshTokens()
+	// the account asks for a code from now on
+	env.server.AddAccount(fake.Account{Email: testEmail, Password: testPassword, MFACode: "123456"})
+
+	var mfaErr *tokens.MFARequiredError
+	if _, err := env.manager.GetValidToken(context.Background(), env.token); !errors.As(err, &mfaErr) {
+		t.Fatalf("GetValidToken() error = %v, want an MFARequiredError", err)
+	}
+
+	challenges, err := env.app.FindRecordsByFilter("challenges", "status = 'pending'", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(challenges) != 1 || challenges[0].GetString("session") != mfaErr.Session {
+		t.Fatalf("%d pending challenges, want one for the login", len(challenges))
+	}
+
+	// waiting for a code isn't a failed login
+	token := reloadToken(t, env)
+	if token.GetString("breakerState") == "open" {
+		t.Error("the breaker opened while waiting for the code")
+	}
+
+	logins := countRequests(env.server, "/oauth2/authorize")
+	if _, err := env.manager.GetValidToken(context.Background(), token); !errors.Is(err, tokens.ErrChallengePending) {
+		t.Fatalf("GetValidToken() error = %v, want ErrChallengePending", err)
+	}
+	if n := countRequests(env.server, "/oauth2/authorize") - logins; n != 0 {
+		t.Errorf("%d logins while the code is pending", n)
+	}
+}
+
+func TestCreateChallengeExpiresPendingChallenges(t *testing.T) {
+	env := newTestEnv(t)
+	accountID, clientID := env.token.GetString("account"), env.token.GetString("client")
+
+	for _, session := range []string{"first", "second"} {
+		if err := env.manager.CreateChallenge(accountID, clientID, &tokens.MFARequiredError{Session: session}); err != nil {
+			t.Fatalf("CreateChallenge() error = %v", err)
+		}
+	}
+
+	pending, err := env.app.FindRecordsByFilter("challenges", "status = 'pending'", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].GetString("session") != "second" {
+		t.Errorf("%d pending challenges, want only the second one", len(pending))
+	}
+}
+
+func TestSaveTokenReplacesToken(t *testing.T) {
+	env := newTestEnv(t)
+
+	token, err := env.manager.SaveToken(env.token.GetString("account"), env.token.GetString("client"), &tokens.TokenResult{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		ExpiresIn:    600,
+	})
+	if err != nil {
+		t.Fatalf("SaveToken() error = %v", err)
+	}
+
+	if token.Id != env.token.Id {
+		t.Errorf("SaveToken() created token %s, want the existing one replaced", token.Id)
+	}
+	if token.GetString("status") != "valid" || token.GetString("accessToken") != "access" {
+		t.Errorf("status = %q, accessToken = %q, want the saved token", token.GetString("status"), token.GetString("accessToken"))
+	}
+}
@@ -0,0 +1,151 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// AuthFailure classifies why a login failed.
+type AuthFailure string
+
+const (
+	AuthFailureWrongPassword AuthFailure = "wrongPassword"
+	AuthFailureLocked        AuthFailure = "locked"
+	AuthFailureDeleted       AuthFailure = "deleted"
+	AuthFailureCaptcha       AuthFailure = "captcha"
+	AuthFailureRateLimited   AuthFailure = "rateLimited"
+	AuthFailureNetwork       AuthFailure = "network"
+	AuthFailureUnknown       AuthFailure = "unknown"
+)
+
+// quarantineAfter is the number of hard login failures in a row after which an account is quarantined.
+const quarantineAfter = 3
+
+// ErrAccountQuarantined is returned instead of logging in to a quarantined account.
+var ErrAccountQuarantined = errors.New("account is quarantined after repeated login failures")
+
+// Hard reports whether retrying the login can't succeed until someone changes
+// something, so every further attempt only draws attention to the account.
+// Throttled logins aren't hard, the breaker of the token backs off until tado accepts them again.
+func (f AuthFailure) Hard() bool {
+	switch f {
+	case AuthFailureWrongPassword, AuthFailureLocked, AuthFailureDeleted, AuthFailureCaptcha:
+		return true
+	default:
+		return false
+	}
+}
+
+// AuthError is returned by TokenAuthProvider.Authorize if tado rejected the login for a known reason.
+type AuthError struct {
+	Failure AuthFailure
+	Err     error
+}
+
+func (e *AuthError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// ClassifyAuthError returns why a login failed. Errors the provider didn't
+// classify are network failures if the request didn't reach tado.
+func ClassifyAuthError(err error) AuthFailure {
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return authErr.Failure
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return AuthFailureNetwork
+	}
+
+	return AuthFailureUnknown
+}
+
+// recordLoginFailure records a failed login on the account and quarantines it after
+// repeated hard failures. It returns whether the account was quarantined now.
+func (m *Manager) recordLoginFailure(account *core.Record, failure AuthFailure, err error) bool {
+	failures := 0
+	if failure.Hard() {
+		failures = account.GetInt("authFailures") + 1
+	}
+
+	account.Set("authFailure", failure)
+	account.Set("authError", err.Error())
+	account.Set("authFailures", failures)
+
+	quarantined := failures >= quarantineAfter && account.GetString("status") != "quarantined"
+	if quarantined {
+		account.Set("status", "quarantined")
+		account.Set("quarantinedAt", time.Now())
+	}
+
+	if err := m.app.Save(account); err != nil {
+		m.app.Logger().Error("failed to record login failure", "account", account.Id, "error", err)
+	}
+
+	return quarantined
+}
+
+// recordLoginSuccess resets the login failures of the account.
+func (m *Manager) recordLoginSuccess(account *core.Record) {
+	if account.GetInt("authFailures") == 0 && account.GetString("authFailure") == "" {
+		return
+	}
+
+	account.Set("authFailure", "")
+	account.Set("authError", "")
+	account.Set("authFailures", 0)
+
+	if err := m.app.Save(account); err != nil {
+		m.app.Logger().Error("failed to record login success", "account", account.Id, "error", err)
+	}
+}
+
+// releaseQuarantine resets the login failures when an operator acknowledges a quarantined account,
+// so its tokens are refreshed or logged in again right away, regardless of their circuit breakers.
+func (m *Manager) releaseQuarantine(e *core.RecordEvent) error {
+	released := e.Record.Original().GetString("status") == "quarantined" &&
+		e.Record.GetString("status") != "quarantined"
+	if released {
+		e.Record.Set("authFailures", 0)
+		e.Record.Set("quarantinedAt", nil)
+	}
+
+	if err := e.Next(); err != nil {
+		return err
+	}
+	if !released {
+		return nil
+	}
+
+	m.app.Logger().Info("released account from quarantine", "account", e.Record.Id)
+
+	tokenRecords, err := m.app.FindRecordsByFilter(
+		"tokens",
+		"account = {:account} && status = 'invalid'",
+		"", 0, 0,
+		map[string]any{"account": e.Record.Id},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, tokenRecord := range tokenRecords {
+		tokenRecord.Set("nextRefresh", time.Now())
+		closeBreaker(tokenRecord)
+		if err := m.app.Save(tokenRecord); err != nil {
+			m.app.Logger().Error("failed to schedule token refresh", "id", tokenRecord.Id, "error", err)
+		}
+	}
+
+	return nil
+}
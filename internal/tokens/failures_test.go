@@ -0,0 +1,90 @@
+package tokens_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/fake"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+func TestHardLoginFailuresQuarantineAccount(t *testing.T) {
+	env := newTestEnv(t)
+	env.manager.Register()
+	env.server.ExpireRefreshTokens()
+	// the password was changed at tado
+	env.server.AddAccount(fake.Account{Email: testEmail, Password: "changed"})
+
+	account, err := env.app.FindRecordById("accounts", env.token.GetString("account"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	account.Set("authFailures", 2)
+	if err := env.app.Save(account); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.manager.GetValidToken(context.Background(), env.token); err == nil {
+		t.Fatal("GetValidToken() with a wrong password succeeded")
+	}
+
+	account, err = env.app.FindRecordById("accounts", account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.GetString("status") != "quarantined" || account.GetDateTime("quarantinedAt").IsZero() {
+		t.Fatalf("status = %q, quarantinedAt = %v, want the account quarantined",
+			account.GetString("status"), account.GetDateTime("quarantinedAt"))
+	}
+	if got := account.GetString("authFailure"); got != string(tokens.AuthFailureWrongPassword) {
+		t.Errorf("authFailure = %q, want wrongPassword", got)
+	}
+
+	// acknowledging the account after fixing the password resumes its tokens right away
+	env.server.AddAccount(fake.Account{Email: testEmail, Password: testPassword})
+	account.Set("status", "active")
+	if err := env.app.Save(account); err != nil {
+		t.Fatal(err)
+	}
+
+	account, err = env.app.FindRecordById("accounts", account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.GetInt("authFailures") != 0 || !account.GetDateTime("quarantinedAt").IsZero() {
+		t.Errorf("authFailures = %d, quarantinedAt = %v, want them reset",
+			account.GetInt("authFailures"), account.GetDateTime("quarantinedAt"))
+	}
+
+	token := reloadToken(t, env)
+	if token.GetString("breakerState") == "open" {
+		t.Error("the breaker of the token is still open")
+	}
+	if err := env.manager.RefreshDueTokens(context.Background()); err != nil {
+		t.Fatalf("RefreshDueTokens() error = %v", err)
+	}
+	if token := reloadToken(t, env); token.GetString("status") != "valid" {
+		t.Errorf("status = %q, want the token logged in again", token.GetString("status"))
+	}
+}
+
+func TestAuthFailureHard(t *testing.T) {
+	tests := []struct {
+		failure tokens.AuthFailure
+		want    bool
+	}{
+		{tokens.AuthFailureWrongPassword, true},
+		{tokens.AuthFailureLocked, true},
+		{tokens.AuthFailureDeleted, true},
+		{tokens.AuthFailureCaptcha, true},
+		{tokens.AuthFailureRateLimited, false},
+		{tokens.AuthFailureNetwork, false},
+		{tokens.AuthFailureUnknown, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.failure.Hard(); got != tt.want {
+			t.Errorf("%s.Hard() = %v, want %v", tt.failure, got, tt.want)
+		}
+	}
+}
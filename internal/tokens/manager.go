@@ -2,11 +2,15 @@ package tokens
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/s1adem4n/tado-api-proxy/internal/metrics"
+	"github.com/s1adem4n/tado-api-proxy/internal/notify"
+	"github.com/s1adem4n/tado-api-proxy/internal/secrets"
 )
 
 // TokenAuthProvider defines the interface for token authentication operations.
@@ -25,10 +29,22 @@ type TokenResult struct {
 	ExpiresIn    int    `json:"expires_in"`
 }
 
+// MFARequiredError is returned by TokenAuthProvider.Authorize if the account has
+// two-factor authentication enabled. The login waits for the code in the session.
+type MFARequiredError struct {
+	Session string
+	Expires time.Time
+}
+
+func (e *MFARequiredError) Error() string {
+	return "two-factor authentication code required"
+}
+
 // Manager handles token lifecycle including refresh and access.
 type Manager struct {
 	app          core.App
 	authProvider TokenAuthProvider
+	notifier     *notify.Notifier
 
 	// Per-token mutex to prevent concurrent refresh operations
 	tokenMutexes   map[string]*sync.Mutex
@@ -36,10 +52,11 @@ type Manager struct {
 }
 
 // NewManager creates a new token manager.
-func NewManager(app core.App, authProvider TokenAuthProvider) *Manager {
+func NewManager(app core.App, authProvider TokenAuthProvider, notifier *notify.Notifier) *Manager {
 	return &Manager{
 		app:          app,
 		authProvider: authProvider,
+		notifier:     notifier,
 		tokenMutexes: make(map[string]*sync.Mutex),
 	}
 }
@@ -82,28 +99,77 @@ func (m *Manager) fixPasswordGrantToken(ctx context.Context, tokenRecord *core.R
 	mu.Lock()
 	defer mu.Unlock()
 
+	// another request may have logged in or failed while this one waited for the lock
+	tokenRecord, err := m.app.FindRecordById("tokens", tokenRecord.Id)
+	if err != nil {
+		return err
+	}
+	if tokenRecord.GetString("status") == "valid" {
+		return nil
+	}
+
 	account, err := m.app.FindRecordById("accounts", tokenRecord.GetString("account"))
 	if err != nil {
 		return err
 	}
 
+	password, err := secrets.Get(account, "password")
+	if err != nil {
+		return err
+	}
+
+	// don't start another login while the last one waits for a two-factor code
+	if m.hasPendingChallenge(account.Id, clientRecord.Id) {
+		return ErrChallengePending
+	}
+
+	// repeated logins to a banned or deleted account make the detection worse
+	if account.GetString("status") == "quarantined" {
+		return ErrAccountQuarantined
+	}
+
+	if err := m.probeBreaker(tokenRecord); err != nil {
+		return err
+	}
+
 	newToken, err := m.authProvider.Authorize(
 		ctx,
 		clientRecord.GetString("clientID"),
 		clientRecord.GetString("redirectURI"),
 		clientRecord.GetString("scope"),
 		account.GetString("email"),
-		account.GetString("password"),
+		password,
 		clientRecord.GetString("platform"),
 	)
 	if err != nil {
+		metrics.TokenRefreshes.WithLabelValues("login", "failure").Inc()
+
+		var mfaErr *MFARequiredError
+		if errors.As(err, &mfaErr) {
+			if err := m.CreateChallenge(account.Id, clientRecord.Id, mfaErr); err != nil {
+				return err
+			}
+		}
+
+		recordRefreshFailure(tokenRecord, err, true)
+		if mfaErr == nil {
+			openBreaker(tokenRecord)
+		}
+		m.app.Save(tokenRecord)
+
+		if mfaErr == nil {
+			m.handleLoginFailure(tokenRecord, account, err)
+		}
 		return err
 	}
+	metrics.TokenRefreshes.WithLabelValues("login", "success").Inc()
+	m.recordLoginSuccess(account)
 
 	tokenRecord.Set("status", "valid")
 	tokenRecord.Set("accessToken", newToken.AccessToken)
 	tokenRecord.Set("refreshToken", newToken.RefreshToken)
 	tokenRecord.Set("expires", CalculateTokenExpiry(newToken.ExpiresIn))
+	recordRefreshSuccess(tokenRecord)
 
 	if err := m.app.Save(tokenRecord); err != nil {
 		return err
@@ -113,6 +179,36 @@ func (m *Manager) fixPasswordGrantToken(ctx context.Context, tokenRecord *core.R
 	return nil
 }
 
+// handleLoginFailure classifies a failed login, quarantines the account after
+// repeated hard failures and sends the notifications.
+func (m *Manager) handleLoginFailure(tokenRecord *core.Record, account *core.Record, err error) {
+	failure := ClassifyAuthError(err)
+	metrics.LoginFailures.WithLabelValues(string(failure)).Inc()
+
+	m.notifier.Notify(notify.Event{
+		Type:  notify.EventLoginFailed,
+		Key:   tokenRecord.Id,
+		Title: "Login failed",
+		Message: fmt.Sprintf("The login of %s failed (%s): %v",
+			notify.DescribeToken(m.app, tokenRecord), failure, err),
+	})
+
+	if m.recordLoginFailure(account, failure, err) {
+		m.app.Logger().Warn("quarantined account after repeated login failures", "account", account.Id, "failure", failure)
+
+		m.notifier.Notify(notify.Event{
+			Type:  notify.EventAccountQuarantined,
+			Key:   account.Id,
+			Title: "Account quarantined",
+			Message: fmt.Sprintf(
+				"The logins of %s failed %d times in a row (%s), so the proxy stopped logging in. "+
+					"Check the account and acknowledge it in the web UI to resume.\nLast error: %v",
+				account.GetString("email"), quarantineAfter, failure, err,
+			),
+		})
+	}
+}
+
 // fixDeviceCodeToken checks if the rate limit has reset and re-enables the token.
 func (m *Manager) fixDeviceCodeToken(tokenRecord *core.Record) error {
 	cutoff, err := GetRatelimitCutoff()
@@ -154,33 +250,46 @@ func (m *Manager) refreshToken(ctx context.Context, tokenRecord *core.Record) er
 		return nil
 	}
 
+	return m.exchangeRefreshToken(ctx, tokenRecord)
+}
+
+// exchangeRefreshToken refreshes the token using its refresh token and records the result.
+// The caller must hold the token mutex.
+func (m *Manager) exchangeRefreshToken(ctx context.Context, tokenRecord *core.Record) error {
 	clientRecord, err := m.app.FindRecordById("clients", tokenRecord.GetString("client"))
 	if err != nil {
 		return err
 	}
 
+	refreshToken, err := secrets.Get(tokenRecord, "refreshToken")
+	if err != nil {
+		return err
+	}
+
 	newToken, err := m.authProvider.RefreshToken(
 		ctx,
 		clientRecord.GetString("clientID"),
-		tokenRecord.GetString("refreshToken"),
+		refreshToken,
 		clientRecord.GetString("platform"),
 	)
+	if err == nil && (newToken.AccessToken == "" || newToken.RefreshToken == "") {
+		err = fmt.Errorf("empty access or refresh token received")
+	}
 	if err != nil {
+		metrics.TokenRefreshes.WithLabelValues("refresh", "failure").Inc()
 		tokenRecord.Set("status", "invalid")
+		// password grant tokens log in again next, which counts the failure
+		recordRefreshFailure(tokenRecord, err, clientRecord.GetString("type") != "passwordGrant")
 		m.app.Save(tokenRecord)
 		return err
 	}
-
-	if newToken.AccessToken == "" || newToken.RefreshToken == "" {
-		tokenRecord.Set("status", "invalid")
-		m.app.Save(tokenRecord)
-		return fmt.Errorf("empty access or refresh token received")
-	}
+	metrics.TokenRefreshes.WithLabelValues("refresh", "success").Inc()
 
 	tokenRecord.Set("status", "valid")
 	tokenRecord.Set("accessToken", newToken.AccessToken)
 	tokenRecord.Set("refreshToken", newToken.RefreshToken)
 	tokenRecord.Set("expires", CalculateTokenExpiry(newToken.ExpiresIn))
+	recordRefreshSuccess(tokenRecord)
 
 	if err := m.app.Save(tokenRecord); err != nil {
 		return err
@@ -194,6 +303,13 @@ func (m *Manager) refreshToken(ctx context.Context, tokenRecord *core.Record) er
 // This is the main method the proxy should use to get a token.
 // It ensures the token is fresh and valid before returning.
 func (m *Manager) GetValidToken(ctx context.Context, tokenRecord *core.Record) (*core.Record, error) {
+	// a broken token doesn't refresh or log in until its breaker half-opens
+	if tokenRecord.GetString("status") != "valid" {
+		if err := checkBreaker(tokenRecord); err != nil {
+			return nil, err
+		}
+	}
+
 	m.refreshToken(ctx, tokenRecord)
 
 	// Re-fetch the token record to get updated values
@@ -232,23 +348,35 @@ func (m *Manager) MarkTokenInvalid(tokenID string) error {
 
 	tokenRecord.Set("status", "invalid")
 	tokenRecord.Set("used", time.Now())
+	// let the background refresher try to fix it right away
+	tokenRecord.Set("nextRefresh", time.Now())
 	return m.app.Save(tokenRecord)
 }
 
-// UpdateTokenUsed updates the token's last used timestamp.
-func (m *Manager) UpdateTokenUsed(tokenID string) error {
+// SetTokenCooldown excludes a token from selection until the given time (e.g. after a 429 response).
+func (m *Manager) SetTokenCooldown(tokenID string, until time.Time) error {
+	mu := m.getTokenMutex(tokenID)
+	mu.Lock()
+	defer mu.Unlock()
+
 	tokenRecord, err := m.app.FindRecordById("tokens", tokenID)
 	if err != nil {
 		return err
 	}
 
+	tokenRecord.Set("cooldownUntil", until)
 	tokenRecord.Set("used", time.Now())
 	return m.app.Save(tokenRecord)
 }
 
+// RatelimitLocation returns the time zone of the daily rate limit reset.
+func RatelimitLocation() (*time.Location, error) {
+	return time.LoadLocation("Europe/Berlin")
+}
+
 // GetRatelimitCutoff returns the cutoff time for rate limit calculations.
 func GetRatelimitCutoff() (time.Time, error) {
-	loc, err := time.LoadLocation("Europe/Berlin")
+	loc, err := RatelimitLocation()
 	if err != nil {
 		return time.Time{}, err
 	}
@@ -0,0 +1,200 @@
+package tokens_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/fake"
+	"github.com/s1adem4n/tado-api-proxy/internal/tado/faketest"
+	"github.com/s1adem4n/tado-api-proxy/internal/tokens"
+)
+
+const (
+	testEmail    = "test@example.com"
+	testPassword = "password"
+	testClientID = "web-client"
+)
+
+// testEnv is a migrated app with an account, a password grant client and a token
+// issued by the fake server.
+type testEnv struct {
+	app     *tests.TestApp
+	server  *fake.Server
+	auth    *tado.Auth
+	manager *tokens.Manager
+	token   *core.Record
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	server := faketest.NewServer(t, fake.Account{Email: testEmail, Password: testPassword})
+	app := faketest.NewApp(t)
+
+	client := faketest.NewClient(t, app, "web", 1000)
+	account := faketest.NewRecord(t, app, "accounts", map[string]any{
+		"email":    testEmail,
+		"password": testPassword,
+	})
+
+	auth := tado.NewAuth()
+	// the access token expired, so the next use refreshes it
+	token := faketest.NewToken(t, app, auth, account, client, testPassword, time.Now().Add(-time.Minute))
+
+	return &testEnv{
+		app:     app,
+		server:  server,
+		auth:    auth,
+		manager: tokens.NewManager(app, auth, nil),
+		token:   token,
+	}
+}
+
+// countRequests returns the number of requests the fake server received for the path.
+func countRequests(server *fake.Server, path string) int {
+	n := 0
+	for _, r := range server.Requests() {
+		if r.Path == path {
+			n++
+		}
+	}
+	return n
+}
+
+func TestGetValidTokenRefreshesExpiredToken(t *testing.T) {
+	env := newTestEnv(t)
+	previousRefreshToken := env.token.GetString("refreshToken")
+
+	token, err := env.manager.GetValidToken(context.Background(), env.token)
+	if err != nil {
+		t.Fatalf("GetValidToken() error = %v", err)
+	}
+
+	if token.GetString("status") != "valid" {
+		t.Errorf("status = %q, want valid", token.GetString("status"))
+	}
+	if token.GetString("accessToken") == env.token.GetString("accessToken") {
+		t.Error("access token wasn't refreshed")
+	}
+	if !token.GetDateTime("expires").Time().After(time.Now()) {
+		t.Errorf("expires = %v, want a time in the future", token.GetDateTime("expires"))
+	}
+	if token.GetString("lastRefreshResult") != "success" {
+		t.Errorf("lastRefreshResult = %q, want success", token.GetString("lastRefreshResult"))
+	}
+
+	// the rotated refresh token was stored, the previous one is spent
+	if token.GetString("refreshToken") == previousRefreshToken {
+		t.Fatal("refresh token wasn't rotated")
+	}
+	if _, err := env.auth.RefreshToken(context.Background(), testClientID, previousRefreshToken, "web"); err == nil {
+		t.Error("the previous refresh token can still be used")
+	}
+	if _, err := env.auth.RefreshToken(context.Background(), testClientID, token.GetString("refreshToken"), "web"); err != nil {
+		t.Errorf("the stored refresh token can't be used: %v", err)
+	}
+}
+
+func TestGetValidTokenKeepsFreshToken(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.token.Set("expires", time.Now().Add(time.Hour))
+	if err := env.app.Save(env.token); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := env.manager.GetValidToken(context.Background(), env.token)
+	if err != nil {
+		t.Fatalf("GetValidToken() error = %v", err)
+	}
+	if token.GetString("accessToken") != env.token.GetString("accessToken") {
+		t.Error("a token that didn't expire yet was refreshed")
+	}
+}
+
+func TestGetValidTokenLogsInAgainAfterExpiredRefreshToken(t *testing.T) {
+	env := newTestEnv(t)
+	env.server.ExpireRefreshTokens()
+
+	token, err := env.manager.GetValidToken(context.Background(), env.token)
+	if err != nil {
+		t.Fatalf("GetValidToken() error = %v", err)
+	}
+
+	if token.GetString("status") != "valid" {
+		t.Errorf("status = %q, want valid", token.GetString("status"))
+	}
+	if token.GetString("refreshToken") == env.token.GetString("refreshToken") {
+		t.Error("the login didn't store a new refresh token")
+	}
+}
+
+func TestGetValidTokenStopsAtQuarantinedAccount(t *testing.T) {
+	env := newTestEnv(t)
+	env.server.ExpireRefreshTokens()
+
+	account, err := env.app.FindRecordById("accounts", env.token.GetString("account"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	account.Set("status", "quarantined")
+	if err := env.app.Save(account); err != nil {
+		t.Fatal(err)
+	}
+
+	logins := countRequests(env.server, "/oauth2/authorize")
+
+	if _, err := env.manager.GetValidToken(context.Background(), env.token); !errors.Is(err, tokens.ErrAccountQuarantined) {
+		t.Fatalf("GetValidToken() error = %v, want ErrAccountQuarantined", err)
+	}
+
+	if n := countRequests(env.server, "/oauth2/authorize") - logins; n != 0 {
+		t.Errorf("the quarantined account logged in %d times", n)
+	}
+}
+
+func TestGetValidTokenDoesntQuarantineThrottledLogins(t *testing.T) {
+	env := newTestEnv(t)
+	env.server.ExpireRefreshTokens()
+
+	// one more hard failure would quarantine the account
+	account, err := env.app.FindRecordById("accounts", env.token.GetString("account"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	account.Set("authFailures", 2)
+	if err := env.app.Save(account); err != nil {
+		t.Fatal(err)
+	}
+
+	env.server.FailNext("POST", `^/oauth2/authorize$`, 429, 1, nil)
+
+	if _, err := env.manager.GetValidToken(context.Background(), env.token); err == nil {
+		t.Fatal("GetValidToken() with a throttled login succeeded")
+	}
+
+	account, err = env.app.FindRecordById("accounts", account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.GetString("status") == "quarantined" {
+		t.Error("a throttled login quarantined the account")
+	}
+	if got := account.GetString("authFailure"); got != string(tokens.AuthFailureRateLimited) {
+		t.Errorf("authFailure = %q, want rateLimited", got)
+	}
+
+	// the breaker still backs off before the next login
+	token, err := env.app.FindRecordById("tokens", env.token.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.GetString("breakerState") != "open" {
+		t.Errorf("breakerState = %q, want open", token.GetString("breakerState"))
+	}
+}
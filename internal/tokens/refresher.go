@@ -0,0 +1,161 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	// refreshLead is the minimum time before expiry at which a token is refreshed.
+	refreshLead = 90 * time.Second
+	// refreshJitter spreads the refreshes of different tokens, so they don't line up.
+	refreshJitter = 2 * time.Minute
+	// refreshTimeout limits a single background refresh.
+	refreshTimeout = 30 * time.Second
+
+	minRefreshBackoff = 30 * time.Second
+	maxRefreshBackoff = time.Hour
+)
+
+// Register schedules the background token refresher and resumes the tokens of accounts released from quarantine.
+func (m *Manager) Register() {
+	m.app.OnRecordUpdate("accounts").BindFunc(m.releaseQuarantine)
+
+	m.app.Cron().MustAdd("refresh-tokens", "* * * * *", func() {
+		err := m.RefreshDueTokens(context.Background())
+		if err != nil {
+			m.app.Logger().Error("failed to refresh tokens", "error", err)
+		}
+	})
+}
+
+// RefreshDueTokens refreshes all enabled tokens whose scheduled refresh time has passed,
+// so requests don't have to wait for a refresh or a new login.
+func (m *Manager) RefreshDueTokens(ctx context.Context) error {
+	tokenRecords, err := m.app.FindRecordsByFilter(
+		"tokens",
+		"disabled = false",
+		"", 0, 0,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, tokenRecord := range tokenRecords {
+		if time.Now().Before(nextRefreshTime(tokenRecord)) {
+			continue
+		}
+
+		refreshCtx, cancel := context.WithTimeout(ctx, refreshTimeout)
+		err := m.refreshScheduledToken(refreshCtx, tokenRecord.Id)
+		cancel()
+
+		var breakerErr *BreakerOpenError
+		if errors.Is(err, ErrAccountQuarantined) || errors.As(err, &breakerErr) {
+			m.app.Logger().Debug("skipped background token refresh", "id", tokenRecord.Id, "reason", err)
+		} else if err != nil {
+			m.app.Logger().Warn("background token refresh failed", "id", tokenRecord.Id, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// refreshScheduledToken refreshes a token from the background refresher.
+// Password grant tokens whose refresh token was rejected are fixed by logging in again.
+func (m *Manager) refreshScheduledToken(ctx context.Context, tokenID string) error {
+	mu := m.getTokenMutex(tokenID)
+	mu.Lock()
+
+	tokenRecord, err := m.app.FindRecordById("tokens", tokenID)
+	if err != nil {
+		mu.Unlock()
+		return err
+	}
+
+	// a request may have refreshed the token in the meantime
+	if time.Now().Before(nextRefreshTime(tokenRecord)) {
+		mu.Unlock()
+		return nil
+	}
+
+	// like logins, refreshes don't contact tado for a quarantined account or while the breaker is open
+	if err := checkBreaker(tokenRecord); err != nil {
+		mu.Unlock()
+		return err
+	}
+	account, err := m.app.FindRecordById("accounts", tokenRecord.GetString("account"))
+	if err != nil {
+		mu.Unlock()
+		return err
+	}
+	if account.GetString("status") == "quarantined" {
+		mu.Unlock()
+		return ErrAccountQuarantined
+	}
+
+	err = m.exchangeRefreshToken(ctx, tokenRecord)
+	mu.Unlock()
+	if err == nil {
+		m.app.Logger().Debug("refreshed token in background", "id", tokenID)
+		return nil
+	}
+
+	clientRecord, clientErr := m.app.FindRecordById("clients", tokenRecord.GetString("client"))
+	if clientErr != nil || clientRecord.GetString("type") != "passwordGrant" {
+		return err
+	}
+
+	return m.fixPasswordGrantToken(ctx, tokenRecord, clientRecord)
+}
+
+// nextRefreshTime returns when the background refresher should refresh the token next.
+func nextRefreshTime(tokenRecord *core.Record) time.Time {
+	if next := tokenRecord.GetDateTime("nextRefresh"); !next.IsZero() {
+		return next.Time()
+	}
+
+	return scheduleRefresh(tokenRecord.GetDateTime("expires").Time())
+}
+
+// scheduleRefresh returns a randomized refresh time shortly before the token expires.
+func scheduleRefresh(expires time.Time) time.Time {
+	return expires.Add(-refreshLead - rand.N(refreshJitter))
+}
+
+// refreshBackoff returns the exponential backoff after the given number of failed refreshes.
+func refreshBackoff(failures int) time.Duration {
+	backoff := min(minRefreshBackoff<<min(max(failures-1, 0), 10), maxRefreshBackoff)
+	return backoff + rand.N(backoff/5)
+}
+
+// recordRefreshSuccess records a successful refresh or login on the token record.
+// It has to be called after the new expiry is set.
+func recordRefreshSuccess(tokenRecord *core.Record) {
+	tokenRecord.Set("lastRefresh", time.Now())
+	tokenRecord.Set("lastRefreshResult", "success")
+	tokenRecord.Set("lastRefreshError", "")
+	tokenRecord.Set("refreshFailures", 0)
+	tokenRecord.Set("nextRefresh", scheduleRefresh(tokenRecord.GetDateTime("expires").Time()))
+	closeBreaker(tokenRecord)
+}
+
+// recordRefreshFailure records a failed refresh or login on the token record
+// and schedules the next attempt with backoff. If count isn't set, the attempt goes on
+// with a login that counts the failure, so it's only counted once.
+func recordRefreshFailure(tokenRecord *core.Record, err error, count bool) {
+	failures := tokenRecord.GetInt("refreshFailures")
+	if count {
+		failures++
+	}
+
+	tokenRecord.Set("lastRefresh", time.Now())
+	tokenRecord.Set("lastRefreshResult", "failure")
+	tokenRecord.Set("lastRefreshError", err.Error())
+	tokenRecord.Set("refreshFailures", failures)
+	tokenRecord.Set("nextRefresh", time.Now().Add(refreshBackoff(failures)))
+}
@@ -0,0 +1,133 @@
+package tokens_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// reloadToken returns the stored state of the token.
+func reloadToken(t *testing.T, env *testEnv) *core.Record {
+	t.Helper()
+
+	token, err := env.app.FindRecordById("tokens", env.token.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestRefreshDueTokensRefreshesDueTokens(t *testing.T) {
+	env := newTestEnv(t)
+
+	if err := env.manager.RefreshDueTokens(context.Background()); err != nil {
+		t.Fatalf("RefreshDueTokens() error = %v", err)
+	}
+
+	token := reloadToken(t, env)
+	if token.GetString("accessToken") == env.token.GetString("accessToken") {
+		t.Fatal("the due token wasn't refreshed")
+	}
+	next, expires := token.GetDateTime("nextRefresh").Time(), token.GetDateTime("expires").Time()
+	if !next.After(time.Now()) || !next.Before(expires) {
+		t.Errorf("nextRefresh = %v, want between now and the expiry %v", next, expires)
+	}
+
+	// the refreshed token isn't due anymore
+	refreshes := countRequests(env.server, "/oauth2/token")
+	if err := env.manager.RefreshDueTokens(context.Background()); err != nil {
+		t.Fatalf("RefreshDueTokens() error = %v", err)
+	}
+	if n := countRequests(env.server, "/oauth2/token") - refreshes; n != 0 {
+		t.Errorf("%d token requests for a token that isn't due", n)
+	}
+}
+
+func TestRefreshDueTokensLogsInAfterRejectedRefreshToken(t *testing.T) {
+	env := newTestEnv(t)
+	env.server.ExpireRefreshTokens()
+
+	if err := env.manager.RefreshDueTokens(context.Background()); err != nil {
+		t.Fatalf("RefreshDueTokens() error = %v", err)
+	}
+
+	token := reloadToken(t, env)
+	if token.GetString("status") != "valid" {
+		t.Errorf("status = %q, want valid", token.GetString("status"))
+	}
+	if token.GetString("refreshToken") == env.token.GetString("refreshToken") {
+		t.Error("the login didn't store a new refresh token")
+	}
+}
+
+func TestRefreshDueTokensBacksOffAfterFailure(t *testing.T) {
+	env := newTestEnv(t)
+	env.server.FailNext("POST", `^/oauth2/`, 500, 10, nil)
+
+	if err := env.manager.RefreshDueTokens(context.Background()); err != nil {
+		t.Fatalf("RefreshDueTokens() error = %v", err)
+	}
+
+	token := reloadToken(t, env)
+	if token.GetString("lastRefreshResult") != "failure" {
+		t.Errorf("lastRefreshResult = %q, want failure", token.GetString("lastRefreshResult"))
+	}
+	if token.GetInt("refreshFailures") == 0 {
+		t.Error("refreshFailures wasn't counted")
+	}
+	if next := token.GetDateTime("nextRefresh").Time(); !next.After(time.Now()) {
+		t.Errorf("nextRefresh = %v, want a backoff into the future", next)
+	}
+
+	// the refresher waits for the backoff
+	requests := len(env.server.Requests())
+	if err := env.manager.RefreshDueTokens(context.Background()); err != nil {
+		t.Fatalf("RefreshDueTokens() error = %v", err)
+	}
+	if n := len(env.server.Requests()) - requests; n != 0 {
+		t.Errorf("%d requests during the backoff", n)
+	}
+}
+
+func TestRefreshDueTokensSkipsQuarantinedAccounts(t *testing.T) {
+	env := newTestEnv(t)
+
+	account, err := env.app.FindRecordById("accounts", env.token.GetString("account"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	account.Set("status", "quarantined")
+	if err := env.app.Save(account); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := len(env.server.Requests())
+	if err := env.manager.RefreshDueTokens(context.Background()); err != nil {
+		t.Fatalf("RefreshDueTokens() error = %v", err)
+	}
+	if n := len(env.server.Requests()) - requests; n != 0 {
+		t.Errorf("%d requests for the token of a quarantined account", n)
+	}
+}
+
+func TestRefreshDueTokensSkipsOpenBreakers(t *testing.T) {
+	env := newTestEnv(t)
+	failLogin(t, env)
+
+	// the breaker stays open after the refresh backoff passed
+	token := reloadToken(t, env)
+	token.Set("nextRefresh", time.Now().Add(-time.Second))
+	if err := env.app.Save(token); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := len(env.server.Requests())
+	if err := env.manager.RefreshDueTokens(context.Background()); err != nil {
+		t.Fatalf("RefreshDueTokens() error = %v", err)
+	}
+	if n := len(env.server.Requests()) - requests; n != 0 {
+		t.Errorf("%d requests while the breaker is open", n)
+	}
+}
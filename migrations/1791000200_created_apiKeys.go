@@ -0,0 +1,215 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"createRule": null,
+			"deleteRule": null,
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "text3208210256",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1579384326",
+					"max": 0,
+					"min": 0,
+					"name": "name",
+					"pattern": "",
+					"presentable": true,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "[a-zA-Z0-9]{32}",
+					"hidden": false,
+					"id": "text2324736937",
+					"max": 0,
+					"min": 8,
+					"name": "key",
+					"pattern": "^[a-zA-Z0-9_-]+$",
+					"presentable": false,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "select107555668",
+					"maxSelect": 1,
+					"name": "access",
+					"presentable": false,
+					"required": true,
+					"system": false,
+					"type": "select",
+					"values": [
+						"readOnly",
+						"readWrite"
+					]
+				},
+				{
+					"cascadeDelete": false,
+					"collectionId": "pbc_1458206008",
+					"hidden": false,
+					"id": "relation2645347283",
+					"maxSelect": 999,
+					"minSelect": 0,
+					"name": "homes",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "relation"
+				},
+				{
+					"hidden": false,
+					"id": "json2344257041",
+					"maxSize": 0,
+					"name": "paths",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "json"
+				},
+				{
+					"hidden": false,
+					"id": "date2593941644",
+					"max": "",
+					"min": "",
+					"name": "expires",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "date"
+				},
+				{
+					"hidden": false,
+					"id": "autodate2990389176",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "autodate3332085495",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_441824284",
+			"indexes": [
+				"CREATE UNIQUE INDEX ` + "`" + `idx_apiKeys_key` + "`" + ` ON ` + "`" + `apiKeys` + "`" + ` (` + "`" + `key` + "`" + `)"
+			],
+			"listRule": null,
+			"name": "apiKeys",
+			"system": false,
+			"type": "base",
+			"updateRule": null,
+			"viewRule": null
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// move the existing proxy token into a full access key
+		settings, err := app.FindFirstRecordByFilter("pbc_2769025244", "")
+		if err == nil && settings.GetString("proxyToken") != "" {
+			record := core.NewRecord(collection)
+			record.Set("name", "Default")
+			record.Set("key", settings.GetString("proxyToken"))
+			record.Set("access", "readWrite")
+			if err := app.Save(record); err != nil {
+				return err
+			}
+		}
+
+		settingsCollection, err := app.FindCollectionByNameOrId("pbc_2769025244")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		settingsCollection.Fields.RemoveById("text1763905485")
+
+		return app.Save(settingsCollection)
+	}, func(app core.App) error {
+		settingsCollection, err := app.FindCollectionByNameOrId("pbc_2769025244")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := settingsCollection.Fields.AddMarshaledJSONAt(1, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text1763905485",
+			"max": 0,
+			"min": 0,
+			"name": "proxyToken",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		if err := app.Save(settingsCollection); err != nil {
+			return err
+		}
+
+		key, err := app.FindFirstRecordByFilter("pbc_441824284", "name = 'Default'")
+		if err == nil {
+			settings, err := app.FindFirstRecordByFilter("pbc_2769025244", "")
+			if err == nil {
+				settings.Set("proxyToken", key.GetString("key"))
+				if err := app.Save(settings); err != nil {
+					return err
+				}
+			}
+		}
+
+		collection, err := app.FindCollectionByNameOrId("pbc_441824284")
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/s1adem4n/tado-api-proxy/internal/secrets"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		box, err := secrets.Default()
+		if err != nil {
+			return err
+		}
+
+		// encryption is disabled, "encryption rotate" encrypts the rows later
+		if box == nil {
+			return nil
+		}
+
+		_, err = secrets.RewrapAll(app, box, box)
+		return err
+	}, func(app core.App) error {
+		box, err := secrets.Default()
+		if err != nil {
+			return err
+		}
+
+		return secrets.DecryptAll(app, box)
+	})
+}
@@ -0,0 +1,180 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1003195976")
+		if err != nil {
+			return err
+		}
+
+		// update field
+		if err := collection.Fields.AddMarshaledJSONAt(1, []byte(`{
+			"cascadeDelete": true,
+			"collectionId": "pbc_2638834880",
+			"hidden": false,
+			"id": "relation1597481275",
+			"maxSelect": 1,
+			"minSelect": 0,
+			"name": "token",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "relation"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(8, []byte(`{
+			"hidden": false,
+			"id": "number2254405824",
+			"max": null,
+			"min": 0,
+			"name": "duration",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(9, []byte(`{
+			"hidden": false,
+			"id": "number2979611598",
+			"max": null,
+			"min": 0,
+			"name": "bytes",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(10, []byte(`{
+			"hidden": false,
+			"id": "number418120294",
+			"max": null,
+			"min": 0,
+			"name": "attempt",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(11, []byte(`{
+			"hidden": false,
+			"id": "number207944961",
+			"max": null,
+			"min": 0,
+			"name": "tokensTried",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(12, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text3475444733",
+			"max": 0,
+			"min": 0,
+			"name": "host",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(13, []byte(`{
+			"hidden": false,
+			"id": "select903383996",
+			"maxSelect": 1,
+			"name": "errorClass",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "select",
+			"values": [
+				"rateLimited",
+				"serverError",
+				"clientError",
+				"unauthorized",
+				"network",
+				"noToken"
+			]
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1003195976")
+		if err != nil {
+			return err
+		}
+
+		// update field
+		if err := collection.Fields.AddMarshaledJSONAt(1, []byte(`{
+			"cascadeDelete": true,
+			"collectionId": "pbc_2638834880",
+			"hidden": false,
+			"id": "relation1597481275",
+			"maxSelect": 1,
+			"minSelect": 0,
+			"name": "token",
+			"presentable": false,
+			"required": true,
+			"system": false,
+			"type": "relation"
+		}`)); err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("number2254405824")
+
+		// remove field
+		collection.Fields.RemoveById("number2979611598")
+
+		// remove field
+		collection.Fields.RemoveById("number418120294")
+
+		// remove field
+		collection.Fields.RemoveById("number207944961")
+
+		// remove field
+		collection.Fields.RemoveById("text3475444733")
+
+		// remove field
+		collection.Fields.RemoveById("select903383996")
+
+		return app.Save(collection)
+	})
+}
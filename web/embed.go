@@ -4,3 +4,8 @@ import "embed"
 
 //go:embed dist/*
 var FS embed.FS
+
+// OpenAPISpec is the bundled definition of the tado API, also served with the docs.
+//
+//go:embed public/docs/openapi.yml
+var OpenAPISpec []byte
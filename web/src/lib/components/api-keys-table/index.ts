@@ -0,0 +1,3 @@
+import ApiKeysTable from './api-keys-table.svelte';
+
+export { ApiKeysTable };
@@ -0,0 +1 @@
+export { default as MqttSettings } from './mqtt-settings.svelte';
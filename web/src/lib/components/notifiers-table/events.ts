@@ -0,0 +1,32 @@
+import type { NotificationEvent, NotifierType } from '@/lib/pb';
+
+export const eventLabels: Record<NotificationEvent, string> = {
+	tokenStatus: 'Token status',
+	loginFailed: 'Login failures',
+	accountQuarantined: 'Quarantined accounts',
+	quota: 'Quota thresholds',
+	deviceCodeExpired: 'Expired device codes',
+	upstreamErrors: 'Upstream errors',
+	quotaForecast: 'Quota forecast'
+};
+
+export const events = Object.keys(eventLabels) as NotificationEvent[];
+
+// matches the defaults of the server
+export const defaultDedupMinutes: Record<NotificationEvent, number> = {
+	tokenStatus: 60,
+	loginFailed: 360,
+	accountQuarantined: 0,
+	quota: 1440,
+	deviceCodeExpired: 0,
+	upstreamErrors: 30,
+	quotaForecast: 1440
+};
+
+export const typeLabels: Record<NotifierType, string> = {
+	webhook: 'Webhook',
+	ntfy: 'ntfy',
+	gotify: 'Gotify',
+	email: 'Email',
+	telegram: 'Telegram'
+};
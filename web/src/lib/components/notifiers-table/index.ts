@@ -0,0 +1 @@
+export { default as NotifiersTable } from './notifiers-table.svelte';
@@ -0,0 +1 @@
+export { default as PollScheduler } from './poll-scheduler.svelte';
@@ -0,0 +1 @@
+export { default as QuotaForecast } from './quota-forecast.svelte';
@@ -0,0 +1 @@
+export { default as TokenSelection } from './token-selection.svelte';
@@ -0,0 +1,3 @@
+import TwoFactorChallenges from './two-factor-challenges.svelte';
+
+export { TwoFactorChallenges };
@@ -6,11 +6,27 @@ export interface Base {
 	updated: string;
 }
 
+export type AccountStatus = 'active' | 'quarantined';
+
+export type AuthFailure =
+	| 'wrongPassword'
+	| 'locked'
+	| 'deleted'
+	| 'captcha'
+	| 'rateLimited'
+	| 'network'
+	| 'unknown';
+
 export interface Account extends Base {
 	tadoID: string;
 	email: string;
 	password: string;
 	homes: string[];
+	status: AccountStatus | '';
+	authFailure: AuthFailure | '';
+	authFailures: number;
+	authError: string;
+	quarantinedAt: string;
 }
 
 export interface Client extends Base {
@@ -31,20 +47,67 @@ export interface Code extends Base {
 	expires: string;
 }
 
+export type ChallengeStatus = 'pending' | 'completed' | 'failed' | 'expired';
+
+export interface Challenge extends Base {
+	account: string;
+	client: string;
+	status: ChallengeStatus;
+	code: string;
+	error: string;
+	expires: string;
+}
+
 export interface Home extends Base {
 	tadoID: string;
 	name: string;
 }
 
+export type RequestErrorClass =
+	| 'rateLimited'
+	| 'serverError'
+	| 'clientError'
+	| 'unauthorized'
+	| 'network'
+	| 'noToken';
+
 export interface Requests extends Base {
 	token: string;
 	method: string;
 	url: string;
+	endpoint: string;
 	status: number;
+	coalesced: boolean;
+	apiKey: string;
+	strategy: TokenStrategy | '';
+	duration: number;
+	bytes: number;
+	attempt: number;
+	tokensTried: number;
+	host: string;
+	errorClass: RequestErrorClass | '';
+}
+
+export type RollupPeriod = 'hour' | 'day';
+
+export interface RequestRollup extends Base {
+	period: RollupPeriod;
+	bucket: string;
+	token: string;
+	client: string;
+	account: string;
+	endpoint: string;
+	statusClass: string;
+	count: number;
+	coalesced: number;
 }
 
 export type TokenStatus = 'valid' | 'invalid';
 
+export type TokenRefreshResult = 'success' | 'failure';
+
+export type TokenBreakerState = 'closed' | 'open' | 'halfOpen';
+
 export interface Token extends Base {
 	account: string;
 	client: string;
@@ -54,20 +117,104 @@ export interface Token extends Base {
 	disabled: boolean;
 	expires: string;
 	used: string;
+	cooldownUntil: string;
+	lastRefresh: string;
+	lastRefreshResult: TokenRefreshResult | '';
+	lastRefreshError: string;
+	refreshFailures: number;
+	nextRefresh: string;
+	breakerState: TokenBreakerState | '';
+	breakerFailures: number;
+	breakerOpenUntil: string;
+}
+
+export type ApiKeyAccess = 'readOnly' | 'readWrite';
+
+export interface ApiKey extends Base {
+	name: string;
+	key: string;
+	access: ApiKeyAccess;
+	homes: string[];
+	paths: string[] | null;
+	expires: string;
+	dailyBudget: number;
+	budgetPercent: number;
 }
 
 export interface Settings extends Base {
-	proxyToken: string;
 	proxyTokenEnabled: boolean;
+	retryOnServerError: boolean;
+	cacheTTLs: Record<string, number> | null;
+	metricsKey: string;
+	mqttEnabled: boolean;
+	mqttBrokerURL: string;
+	mqttUsername: string;
+	mqttPassword: string;
+	mqttTopicPrefix: string;
+	mqttPollInterval: number;
+	mqttDiscovery: boolean;
+	mqttDiscoveryPrefix: string;
+	pollEndpoints: string[] | null;
+	pollQuotaShare: number;
+	pollHourlyRates: number[] | null;
+	notificationEvents: Partial<Record<NotificationEvent, NotificationEventSettings>> | null;
+	tokenStrategy: TokenStrategy | '';
+	tokenWeights: TokenWeights | null;
+	rollupRetention: number;
+	requestRetention: number;
+	requestMaxRows: number;
+}
+
+export type TokenStrategy =
+	| 'priority'
+	| 'leastUsed'
+	| 'weightedRoundRobin'
+	| 'weightedRandom'
+	| 'spreadAccounts';
+
+export interface TokenWeights {
+	clients?: Record<string, number>;
+	accounts?: Record<string, number>;
+}
+
+export type NotifierType = 'webhook' | 'ntfy' | 'gotify' | 'email' | 'telegram';
+
+export type NotificationEvent =
+	| 'tokenStatus'
+	| 'loginFailed'
+	| 'accountQuarantined'
+	| 'quota'
+	| 'deviceCodeExpired'
+	| 'upstreamErrors'
+	| 'quotaForecast';
+
+export interface NotificationEventSettings {
+	dedupMinutes?: number;
+	quietHours?: string;
+}
+
+export interface Notifier extends Base {
+	name: string;
+	type: NotifierType;
+	url: string;
+	target: string;
+	events: NotificationEvent[];
+	enabled: boolean;
+	lastSent: string;
+	lastError: string;
 }
 
 export interface TypedPocketBase extends PocketBase {
 	collection(idOrName: string): RecordService;
 	collection(idOrName: 'accounts'): RecordService<Account>;
+	collection(idOrName: 'apiKeys'): RecordService<ApiKey>;
 	collection(idOrName: 'clients'): RecordService<Client>;
+	collection(idOrName: 'challenges'): RecordService<Challenge>;
 	collection(idOrName: 'codes'): RecordService<Code>;
 	collection(idOrName: 'homes'): RecordService<Home>;
+	collection(idOrName: 'notifiers'): RecordService<Notifier>;
 	collection(idOrName: 'requests'): RecordService<Requests>;
+	collection(idOrName: 'requestRollups'): RecordService<RequestRollup>;
 	collection(idOrName: 'tokens'): RecordService<Token>;
 	collection(idOrName: 'settings'): RecordService<Settings>;
 }
@@ -80,6 +227,12 @@ export type RatelimitDetails = {
 	remaining: number;
 	used: number;
 	status: TokenStatus;
+	cooldownUntil: string;
+	burnRate: number;
+	projected: number;
+	exhaustsAt: string | null;
+	exhaustsEarly: boolean;
+	reset: string;
 };
 
 export type Ratelimits = Record<string, RatelimitDetails>;
@@ -87,3 +240,47 @@ export type Ratelimits = Record<string, RatelimitDetails>;
 export async function fetchRatelimits() {
 	return await pb.send<Ratelimits>('/api/ratelimits', { method: 'GET' });
 }
+
+export type QuotaForecast = {
+	used: number;
+	limit: number;
+	remaining: number;
+	burnRate: number;
+	projected: number;
+	exhaustsAt: string | null;
+	exhaustsEarly: boolean;
+};
+
+export type HomeForecast = QuotaForecast & {
+	id: string;
+	name: string;
+	tokens: string[];
+};
+
+export type Forecast = {
+	reset: string;
+	since: string;
+	tokens: Record<string, QuotaForecast>;
+	homes: HomeForecast[];
+	pool: QuotaForecast;
+};
+
+export async function fetchForecast() {
+	return await pb.send<Forecast>('/api/ratelimits/forecast', { method: 'GET' });
+}
+
+export type SchedulerStatus = {
+	endpoints: string[];
+	interval: number;
+	lastPoll: string;
+	nextPoll: string;
+	lastError: string;
+};
+
+export async function fetchSchedulerStatus() {
+	return await pb.send<SchedulerStatus>('/api/scheduler', { method: 'GET' });
+}
+
+export async function testNotifier(id: string) {
+	return await pb.send(`/api/notifiers/${id}/test`, { method: 'POST' });
+}